@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,6 +24,30 @@ func (c *Client) Connect() error {
 	return c.t.Connect()
 }
 
+// connectRetrying calls Connect until it succeeds or fails permanently,
+// backing off between attempts, for a transport that could not connect at
+// startup.
+func (c *Client) connectRetrying() {
+	backoff := transport.ExponentialBackoff{
+		Min:        time.Second,
+		Max:        transport.DefaultMaxBackoff,
+		Multiplier: transport.DefaultBackoffMultiplier,
+	}
+	for attempt := 1; ; attempt++ {
+		time.Sleep(backoff.NextDelay(attempt))
+		err := c.Connect()
+		if err == nil {
+			log.Info("connected using transport")
+			return
+		}
+		if !errors.Is(err, transport.ErrTransientTransport) {
+			log.Errorf("cannot connect using transport: %v", err)
+			return
+		}
+		log.Warnf("cannot connect using transport, retrying: %v", err)
+	}
+}
+
 func (c *Client) SendDataMessage(msg *yggdrasil.Data) ([]byte, error) {
 	return c.sendMessage(msg, "data")
 }
@@ -94,7 +119,11 @@ func (c *Client) ReceiveControlMessage(msg *yggdrasil.Control) error {
 			}
 			time.Sleep(time.Duration(delay) * time.Second)
 
-			if err := c.t.Connect(); err != nil {
+			connect := c.t.Connect
+			if r, ok := c.t.(transport.Reconnecter); ok {
+				connect = r.Reconnect
+			}
+			if err := connect(); err != nil {
 				return fmt.Errorf("cannot reconnect to broker: %w", err)
 			}
 		default:
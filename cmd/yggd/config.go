@@ -22,6 +22,7 @@ const (
 	cliDataHost        = "data-host"
 	cliExcludeWorker   = "exclude-worker"
 	cliWorkerConfigDir = "worker-config-dir"
+	cliUserAgent       = "user-agent"
 )
 
 // Config contains current configuration state for yggdrasil.
@@ -55,7 +56,7 @@ type Config struct {
 	PathPrefix string
 
 	// Protocol is the protocol used by yggd when connecting to Server. Can be
-	// either MQTT or HTTP.
+	// MQTT, HTTP or WebSocket.
 	Protocol string
 
 	// DataHost is a hostname value to interject into all HTTP requests when
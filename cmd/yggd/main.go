@@ -3,12 +3,14 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -28,7 +30,7 @@ import (
 
 var (
 	DefaultConfig = Config{}
-	UserAgent     = yggdrasil.LongName + "/" + yggdrasil.Version
+	UserAgent     = yggdrasil.UserAgent("")
 )
 
 func main() {
@@ -75,7 +77,7 @@ func main() {
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:  cliProtocol,
-			Usage: "Transmit data remotely using `PROTOCOL` ('mqtt' or 'http')",
+			Usage: "Transmit data remotely using `PROTOCOL` ('mqtt', 'http', 'websocket', 'amqp' or 'kafka')",
 			Value: "mqtt",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
@@ -116,6 +118,11 @@ func main() {
 			Hidden:    true,
 			Value:     filepath.Join(yggdrasil.SysconfDir, yggdrasil.LongName, "workers"),
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:   cliUserAgent,
+			Hidden: true,
+			Usage:  "Send `VALUE` as the User-Agent instead of one naming the version and platform",
+		}),
 	}
 
 	// This BeforeFunc will load flag values from a config file only if the
@@ -183,6 +190,10 @@ func main() {
 			yggdrasil.PathPrefix = DefaultConfig.PathPrefix
 		}
 
+		if ua := c.String(cliUserAgent); ua != "" {
+			UserAgent = ua
+		}
+
 		// Set DataHost globally if the config option is non-zero
 		if DefaultConfig.DataHost != "" {
 			yggdrasil.DataHost = DefaultConfig.DataHost
@@ -262,22 +273,45 @@ func main() {
 		switch DefaultConfig.Protocol {
 		case "mqtt":
 			var err error
-			transporter, err = transport.NewMQTTTransport(DefaultConfig.ClientID, DefaultConfig.Server, tlsConfig, client.DataReceiveHandlerFunc)
+			transporter, err = transport.NewMQTTTransport(DefaultConfig.ClientID, DefaultConfig.Server, tlsConfig, transport.NoErrorHandler(client.DataReceiveHandlerFunc))
 			if err != nil {
 				return cli.Exit(fmt.Errorf("cannot create MQTT transport: %w", err), 1)
 			}
 		case "http":
 			var err error
-			transporter, err = transport.NewHTTPTransport(DefaultConfig.ClientID, DefaultConfig.Server, tlsConfig, UserAgent, time.Second*5, client.DataReceiveHandlerFunc)
+			transporter, err = transport.NewHTTPTransport(DefaultConfig.ClientID, DefaultConfig.Server, tlsConfig, UserAgent, time.Second*5, transport.NoErrorHandler(client.DataReceiveHandlerFunc))
 			if err != nil {
 				return cli.Exit(fmt.Errorf("cannot create HTTP transport: %w", err), 1)
 			}
+		case "websocket":
+			var err error
+			transporter, err = transport.NewWebSocketTransport(DefaultConfig.ClientID, DefaultConfig.Server, tlsConfig, UserAgent, transport.NoErrorHandler(client.DataReceiveHandlerFunc))
+			if err != nil {
+				return cli.Exit(fmt.Errorf("cannot create WebSocket transport: %w", err), 1)
+			}
+		case "amqp":
+			var err error
+			transporter, err = transport.NewAMQPTransport(DefaultConfig.ClientID, DefaultConfig.Server, tlsConfig, transport.NoErrorHandler(client.DataReceiveHandlerFunc))
+			if err != nil {
+				return cli.Exit(fmt.Errorf("cannot create AMQP transport: %w", err), 1)
+			}
+		case "kafka":
+			var err error
+			transporter, err = transport.NewKafkaTransport(DefaultConfig.ClientID, strings.Split(DefaultConfig.Server, ","), tlsConfig, transport.NoErrorHandler(client.DataReceiveHandlerFunc))
+			if err != nil {
+				return cli.Exit(fmt.Errorf("cannot create Kafka transport: %w", err), 1)
+			}
 		default:
 			return cli.Exit(fmt.Errorf("unsupported transport protocol: %v", DefaultConfig.Protocol), 1)
 		}
 		client.t = transporter
 		if err := client.Connect(); err != nil {
-			return cli.Exit(fmt.Errorf("cannot connect using transport: %w", err), 1)
+			// A transient failure is retried in the background.
+			if !errors.Is(err, transport.ErrTransientTransport) {
+				return cli.Exit(fmt.Errorf("cannot connect using transport: %w", err), 1)
+			}
+			log.Warnf("cannot connect using transport, retrying: %v", err)
+			go client.connectRetrying()
 		}
 
 		// Start a goroutine that receives values on the 'TLSEvents' channel and
@@ -300,9 +334,8 @@ func main() {
 				}
 				log.Info("transport TLS configuration reloaded")
 
-				log.Debug("setting dispatcher HTTP client")
-				httpClient := http.NewHTTPClient(cfg, UserAgent)
-				d.httpClient = httpClient
+				log.Debug("reloading dispatcher HTTP client TLS configuration")
+				d.httpClient.ReloadTLSConfig(cfg)
 				log.Info("dispatcher HTTP client updated")
 			}
 		}()
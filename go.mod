@@ -4,15 +4,25 @@ go 1.13
 
 require (
 	git.sr.ht/~spc/go-log v0.0.0-20210409014304-ce6a6f3602dc
+	github.com/Azure/go-amqp v0.13.1
 	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
 	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/fxamacker/cbor/v2 v2.3.0
 	github.com/google/go-cmp v0.5.6
 	github.com/google/uuid v1.1.2
+	github.com/gorilla/websocket v1.4.2
 	github.com/pelletier/go-toml v1.9.3
+	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/rjeczalik/notify v0.9.2
+	github.com/segmentio/kafka-go v0.4.20
 	github.com/urfave/cli/v2 v2.3.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/net v0.0.0-20201031054903-ff519b6c9102
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/grpc v1.34.0
-	google.golang.org/protobuf v1.25.0
+	google.golang.org/protobuf v1.26.0
 )
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// BasicAuth holds the credentials sent with HTTP basic authentication.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// TokenFunc returns the bearer token to send with a request. It is called for
+// every request, so a rotated token is picked up by the next request.
+type TokenFunc func() (string, error)
+
+// authorize sets the Authorization header of req from the credentials in
+// opts, if any. A bearer token takes precedence over basic authentication.
+func authorize(req *http.Request, opts ClientOptions) error {
+	switch {
+	case opts.BearerToken != nil:
+		token, err := opts.BearerToken()
+		if err != nil {
+			return fmt.Errorf("cannot get bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case opts.BasicAuth != nil:
+		req.SetBasicAuth(opts.BasicAuth.Username, opts.BasicAuth.Password)
+	}
+	return nil
+}
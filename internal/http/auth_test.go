@@ -0,0 +1,82 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAuthorization(t *testing.T) {
+	headers := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers <- req.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	var calls int
+	tests := []struct {
+		description string
+		opts        ClientOptions
+		want        []string
+	}{
+		{
+			description: "none",
+			want:        []string{"", ""},
+		},
+		{
+			description: "basic",
+			opts:        ClientOptions{BasicAuth: &BasicAuth{Username: "user", Password: "secret"}},
+			want:        []string{"Basic dXNlcjpzZWNyZXQ=", "Basic dXNlcjpzZWNyZXQ="},
+		},
+		{
+			description: "bearer",
+			opts: ClientOptions{
+				BasicAuth: &BasicAuth{Username: "user", Password: "secret"},
+				BearerToken: func() (string, error) {
+					calls++
+					return fmt.Sprintf("token-%v", calls), nil
+				},
+			},
+			want: []string{"Bearer token-1", "Bearer token-2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			client := NewHTTPClientWithOptions(nil, "testUA", test.opts)
+			for _, want := range test.want {
+				res, err := client.Get(srv.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Body.Close()
+				if got := <-headers; got != want {
+					t.Errorf("authorization %q != %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientBearerTokenError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("request sent without a token")
+	}))
+	defer srv.Close()
+
+	errExpired := errors.New("token expired")
+	client := NewHTTPClientWithOptions(nil, "testUA", ClientOptions{
+		BearerToken: func() (string, error) { return "", errExpired },
+	})
+	res, err := client.Get(srv.URL)
+	if err == nil {
+		res.Body.Close()
+	}
+	if !errors.Is(err, errExpired) {
+		t.Errorf("expected the token error, got %v", err)
+	}
+}
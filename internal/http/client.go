@@ -3,49 +3,297 @@ package http
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"git.sr.ht/~spc/go-log"
 )
 
+// ErrResponseHeaderTooLarge is returned, wrapped, when the response headers
+// sent by a server exceed ClientOptions.MaxResponseHeaderBytes.
+var ErrResponseHeaderTooLarge = errors.New("response headers too large")
+
+// headerTooLargeMessage is part of the message of the error net/http returns
+// when response headers exceed the transport limit, for which it has no
+// error value to match.
+const headerTooLargeMessage = "server response headers exceeded"
+
 // Client is a specialized HTTP client, configured with mutual TLS certificate
 // authentication.
 type Client struct {
 	client    *http.Client
 	userAgent string
+
+	// certificates holds the []tls.Certificate presented to servers that
+	// request a client certificate. It is swapped by ReloadTLSConfig and read
+	// on every handshake, so certificates can be rotated without replacing
+	// the underlying transport and its connection pool.
+	certificates atomic.Value
+
+	// transport sends requests through the current *http.Transport, which
+	// is replaced when ReloadTLSConfig changes the root CAs. It is nil for
+	// clients created with NewHTTPClientWithTransport.
+	transport *reloadingTransport
+	opts      ClientOptions
+
+	// dns caches the addresses dialed by the current transport, if
+	// DNSCacheTTL is set. It outlives transports replaced by
+	// ReloadTLSConfig.
+	dns *dnsCache
+
+	// mu guards tlsConfig, the TLS config of the current transport.
+	mu        sync.Mutex
+	tlsConfig *tls.Config
+
+	// activityMu guards lastActivity, the time the last request was sent or
+	// completed, if RefreshAfterIdle is set.
+	activityMu   sync.Mutex
+	lastActivity time.Time
+}
+
+// reloadingTransport is an http.RoundTripper that delegates to an
+// atomically replaceable *http.Transport, so the transport can be swapped
+// while requests are in flight.
+type reloadingTransport struct {
+	current atomic.Value
+}
+
+func (t *reloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current.Load().(*http.Transport).RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the current transport.
+func (t *reloadingTransport) CloseIdleConnections() {
+	t.current.Load().(*http.Transport).CloseIdleConnections()
 }
 
 // NewHTTPClient creates a client with the given TLS configuration and
-// user-agent string.
+// user-agent string. The configuration is cloned, so later changes made by the
+// caller do not affect the client. A nil config leaves the default TLS
+// settings in place, which is sufficient for plain HTTP.
 func NewHTTPClient(config *tls.Config, ua string) *Client {
-	client := &http.Client{
-		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+	return NewHTTPClientWithOptions(config, ua, ClientOptions{})
+}
+
+// NewHTTPClientWithOptions creates a client with the given TLS configuration,
+// user-agent string and options.
+func NewHTTPClientWithOptions(config *tls.Config, ua string, opts ClientOptions) *Client {
+	transport := &reloadingTransport{}
+	c := NewHTTPClientWithTransport(transport, ua, opts)
+	c.transport = transport
+
+	tlsConfig := &tls.Config{}
+	if config != nil {
+		tlsConfig = config.Clone()
+	}
+	if opts.TLSPolicy != nil {
+		tlsConfig = opts.TLSPolicy.Apply(tlsConfig)
+	}
+	c.certificates.Store(tlsConfig.Certificates)
+	if len(opts.PinnedSPKI) > 0 {
+		tlsConfig.VerifyPeerCertificate = verifyPins(opts.PinnedSPKI, tlsConfig.VerifyPeerCertificate)
+	}
+	if opts.RequireOCSPStaple {
+		tlsConfig.VerifyConnection = verifyOCSPStaple(tlsConfig.VerifyConnection, time.Now)
+	}
+	tlsConfig.Renegotiation = opts.Renegotiation
+	if opts.ServerName != "" {
+		tlsConfig.ServerName = opts.ServerName
 	}
-	client.Transport.(*http.Transport).TLSClientConfig = config.Clone()
+	if opts.SessionResumption && tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(opts.SessionCacheSize)
+	}
+	if tlsConfig.GetClientCertificate == nil {
+		tlsConfig.Certificates = nil
+		tlsConfig.GetClientCertificate = c.getClientCertificate
+	}
+
+	if opts.DNSCacheTTL > 0 {
+		var resolver ipResolver = net.DefaultResolver
+		if opts.Resolver != nil {
+			resolver = opts.Resolver
+		}
+		c.dns = newDNSCache(resolver, opts.DNSCacheTTL)
+	}
+
+	c.tlsConfig = tlsConfig
+	transport.current.Store(newTransport(tlsConfig, opts, c.dns))
 
+	return c
+}
+
+// NewHTTPClientWithTransport creates a client that sends requests through
+// transport, with the given user-agent string. This allows requests to be
+// sent to a fake server in tests, or wrapped in middleware. Only the overall
+// Timeout, the Redirects policy, the credentials and the interceptors of opts
+// apply; all other options, including TLS settings, are up to transport, and
+// ReloadTLSConfig has no effect.
+func NewHTTPClientWithTransport(transport http.RoundTripper, ua string, opts ClientOptions) *Client {
 	return &Client{
-		client:    client,
+		client: &http.Client{
+			Transport:     transport,
+			Timeout:       timeout(opts.Timeout, DefaultTimeout),
+			CheckRedirect: opts.Redirects.checkRedirect(),
+		},
 		userAgent: ua,
+		opts:      opts,
 	}
 }
 
+// newTransport creates an HTTP transport using tlsConfig and opts, resolving
+// hosts through dns if it is not nil.
+func newTransport(tlsConfig *tls.Config, opts ClientOptions, dns *dnsCache) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	dialer := &net.Dialer{
+		Timeout:   timeout(opts.DialTimeout, DefaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+		Resolver:  opts.Resolver,
+	}
+	transport.DialContext = dialer.DialContext
+	if dns != nil {
+		transport.DialContext = dns.dialContext(dialer.DialContext)
+	}
+	transport.Proxy = proxyFunc(opts)
+	transport.TLSHandshakeTimeout = timeout(opts.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = timeout(opts.ResponseHeaderTimeout, DefaultResponseHeaderTimeout)
+	transport.ExpectContinueTimeout = timeout(opts.ExpectContinueTimeout, DefaultExpectContinueTimeout)
+	transport.MaxIdleConns = limit(opts.MaxIdleConns, DefaultMaxIdleConns)
+	transport.MaxIdleConnsPerHost = limit(opts.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost)
+	transport.IdleConnTimeout = timeout(opts.IdleConnTimeout, DefaultIdleConnTimeout)
+	transport.DisableKeepAlives = opts.DisableKeepAlives
+	transport.MaxResponseHeaderBytes = opts.MaxResponseHeaderBytes
+	if transport.MaxResponseHeaderBytes <= 0 {
+		transport.MaxResponseHeaderBytes = DefaultMaxResponseHeaderBytes
+	}
+	return transport
+}
+
+// ReloadTLSConfig replaces the client certificates and root CAs with those
+// of config. Requests in flight are not affected. New certificates are
+// presented on the next TLS handshake, keeping the connection pool. New root
+// CAs replace the underlying transport and, if SessionResumption is set, the
+// session cache, so that neither connections nor sessions verified against
+// the old CAs are reused. Other TLS settings are kept from the configuration
+// the client was created with.
+func (c *Client) ReloadTLSConfig(config *tls.Config) {
+	if c.transport == nil {
+		return
+	}
+	var certificates []tls.Certificate
+	var roots *x509.CertPool
+	if config != nil {
+		certificates = config.Certificates
+		roots = config.RootCAs
+	}
+	c.certificates.Store(certificates)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if roots.Equal(c.tlsConfig.RootCAs) {
+		return
+	}
+	tlsConfig := c.tlsConfig.Clone()
+	tlsConfig.RootCAs = roots
+	if c.opts.SessionResumption {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(c.opts.SessionCacheSize)
+	}
+	c.tlsConfig = tlsConfig
+
+	old := c.transport.current.Load().(*http.Transport)
+	c.transport.current.Store(newTransport(tlsConfig, c.opts, c.dns))
+	old.CloseIdleConnections()
+	log.Debug("reloaded HTTP client root CAs")
+}
+
+// CloseIdleConnections closes the connections kept alive for reuse that are
+// not in use.
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// refreshIfIdle closes the idle connections if no request was sent or
+// completed for RefreshAfterIdle, and records the request about to be sent.
+func (c *Client) refreshIfIdle() {
+	c.activityMu.Lock()
+	idle := !c.lastActivity.IsZero() && time.Since(c.lastActivity) > c.opts.RefreshAfterIdle
+	c.lastActivity = time.Now()
+	c.activityMu.Unlock()
+
+	if idle {
+		log.Debugf("HTTP client idle for more than %v, closing idle connections", c.opts.RefreshAfterIdle)
+		c.CloseIdleConnections()
+	}
+}
+
+// recordActivity records that a request completed.
+func (c *Client) recordActivity() {
+	c.activityMu.Lock()
+	defer c.activityMu.Unlock()
+	c.lastActivity = time.Now()
+}
+
+// getClientCertificate returns the current client certificate that best
+// matches the server's request, following the same selection rules crypto/tls
+// applies to tls.Config.Certificates.
+func (c *Client) getClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certificates := c.certificates.Load().([]tls.Certificate)
+	for i := range certificates {
+		if err := cri.SupportsCertificate(&certificates[i]); err == nil {
+			return &certificates[i], nil
+		}
+	}
+	if len(certificates) > 0 {
+		return &certificates[0], nil
+	}
+	// An empty certificate tells the server that none is available.
+	return &tls.Certificate{}, nil
+}
+
 func (c *Client) Get(url string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create HTTP request: %w", err)
 	}
-	req.Header.Add("User-Agent", c.userAgent)
 
-	log.Debugf("sending HTTP request: %v %v", req.Method, req.URL)
-	log.Tracef("request: %v", req)
-
-	return c.client.Do(req)
+	return c.Do(req)
 }
 
 func (c *Client) Post(url string, headers map[string]string, body []byte) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	return c.send(http.MethodPost, url, headers, body)
+}
+
+// Put sends body to url in a PUT request with the given headers.
+func (c *Client) Put(url string, headers map[string]string, body []byte) (*http.Response, error) {
+	return c.send(http.MethodPut, url, headers, body)
+}
+
+// Patch sends body to url in a PATCH request with the given headers.
+func (c *Client) Patch(url string, headers map[string]string, body []byte) (*http.Response, error) {
+	return c.send(http.MethodPatch, url, headers, body)
+}
+
+// Delete sends a DELETE request to url with the given headers.
+func (c *Client) Delete(url string, headers map[string]string) (*http.Response, error) {
+	return c.send(http.MethodDelete, url, headers, nil)
+}
+
+// send creates a request with the given method, URL, headers and body, and
+// sends it with Do. A nil body is sent as no body at all.
+func (c *Client) send(method, url string, headers map[string]string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, r)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create HTTP request: %w", err)
 	}
@@ -53,10 +301,45 @@ func (c *Client) Post(url string, headers map[string]string, body []byte) (*http
 	for k, v := range headers {
 		req.Header.Add(k, strings.TrimSpace(v))
 	}
+
+	return c.Do(req)
+}
+
+// Do sets the client user-agent and credentials on req and sends it. Callers
+// that need to control the request context or headers should construct the
+// request themselves and send it with Do.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	req.Header.Add("User-Agent", c.userAgent)
 
 	log.Debugf("sending HTTP request: %v %v", req.Method, req.URL)
 	log.Tracef("request: %v", req)
 
-	return c.client.Do(req)
+	// Credentials are added after the request is traced, so they never
+	// reach the log.
+	if err := authorize(req, c.opts); err != nil {
+		return nil, err
+	}
+	req, err := interceptRequest(req, c.opts.RequestInterceptors)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.opts.RefreshAfterIdle > 0 {
+		c.refreshIfIdle()
+		defer c.recordActivity()
+	}
+	var res *http.Response
+	if c.opts.TraceConnections || c.opts.OnConnectionTiming != nil {
+		res, err = c.doTraced(req)
+	} else {
+		res, err = c.client.Do(req)
+	}
+	if err != nil && strings.Contains(err.Error(), headerTooLargeMessage) {
+		log.Warnf("server sent oversized response headers: %v %v", req.Method, req.URL)
+		err = fmt.Errorf("%w: %v", ErrResponseHeaderTooLarge, err)
+	}
+	if err != nil {
+		return res, err
+	}
+	return interceptResponse(res, c.opts.ResponseInterceptors)
 }
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+)
+
+// ConnectionTiming breaks down the time taken by a request up to the first
+// byte of its response. Phases that did not take place, such as the DNS
+// lookup of an IP address, or all of them on a reused connection, are zero.
+// For a redirected request, it describes the last request.
+type ConnectionTiming struct {
+	// DNSLookup is the time taken to resolve the host name.
+	DNSLookup time.Duration
+
+	// Connect is the time taken to establish the TCP connection.
+	Connect time.Duration
+
+	// TLSHandshake is the time taken by the TLS handshake.
+	TLSHandshake time.Duration
+
+	// FirstByte is the time from starting the request to receiving the
+	// first byte of the response.
+	FirstByte time.Duration
+
+	// Reused is true if the request was sent on a pooled connection.
+	Reused bool
+}
+
+// ConnectionTimingFunc is called with the timing of each request sent by a
+// client.
+type ConnectionTimingFunc func(req *http.Request, timing ConnectionTiming)
+
+// connectionTrace collects the ConnectionTiming of a request from the
+// httptrace hooks, which may be called concurrently.
+type connectionTrace struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+	timing       ConnectionTiming
+}
+
+// traceConnection returns req with a context that records its timing in the
+// returned connectionTrace.
+func traceConnection(req *http.Request) (*http.Request, *connectionTrace) {
+	t := &connectionTrace{
+		start:        time.Now(),
+		connectStart: make(map[string]time.Time),
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.record(func(now time.Time) { t.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.record(func(now time.Time) { t.timing.DNSLookup = now.Sub(t.dnsStart) })
+		},
+		ConnectStart: func(network, addr string) {
+			t.record(func(now time.Time) { t.connectStart[network+" "+addr] = now })
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				return
+			}
+			t.record(func(now time.Time) { t.timing.Connect = now.Sub(t.connectStart[network+" "+addr]) })
+		},
+		TLSHandshakeStart: func() {
+			t.record(func(now time.Time) { t.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.record(func(now time.Time) { t.timing.TLSHandshake = now.Sub(t.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.record(func(time.Time) { t.timing.Reused = info.Reused })
+		},
+		GotFirstResponseByte: func() {
+			t.record(func(now time.Time) { t.timing.FirstByte = now.Sub(t.start) })
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), t
+}
+
+func (t *connectionTrace) record(f func(now time.Time)) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(now)
+}
+
+// result returns the timing recorded so far.
+func (t *connectionTrace) result() ConnectionTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.timing
+}
+
+// doTraced sends req, logging its timing at debug level if
+// TraceConnections is set and passing it to OnConnectionTiming, if set.
+func (c *Client) doTraced(req *http.Request) (*http.Response, error) {
+	req, trace := traceConnection(req)
+	resp, err := c.client.Do(req)
+	timing := trace.result()
+	if c.opts.TraceConnections {
+		log.Debugf("HTTP request timing: %v %v: dns=%v connect=%v tls=%v first_byte=%v reused=%v",
+			req.Method, req.URL, timing.DNSLookup, timing.Connect, timing.TLSHandshake, timing.FirstByte, timing.Reused)
+	}
+	if c.opts.OnConnectionTiming != nil {
+		c.opts.OnConnectionTiming(req, timing)
+	}
+	return resp, err
+}
@@ -0,0 +1,70 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionTiming(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	var timings []ConnectionTiming
+	// The test certificate is issued for example.com.
+	tlsConfig := &tls.Config{RootCAs: roots, ServerName: "example.com"}
+	client := NewHTTPClientWithOptions(tlsConfig, "testUA", ClientOptions{
+		TraceConnections: true,
+		OnConnectionTiming: func(req *http.Request, timing ConnectionTiming) {
+			timings = append(timings, timing)
+		},
+	})
+
+	// Use a host name, so that it must be resolved.
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if len(timings) != 2 {
+		t.Fatalf("%v timings recorded, want 2", len(timings))
+	}
+
+	first := timings[0]
+	if first.Reused {
+		t.Error("first request reported a reused connection")
+	}
+	for name, d := range map[string]time.Duration{
+		"DNS lookup":    first.DNSLookup,
+		"connect":       first.Connect,
+		"TLS handshake": first.TLSHandshake,
+		"first byte":    first.FirstByte,
+	} {
+		if d <= 0 {
+			t.Errorf("%v took %v, want a positive duration", name, d)
+		}
+	}
+
+	second := timings[1]
+	if !second.Reused {
+		t.Error("second request did not reuse the connection")
+	}
+	if second.DNSLookup != 0 || second.Connect != 0 || second.TLSHandshake != 0 {
+		t.Errorf("reused connection reported connection phases: %+v", second)
+	}
+	if second.FirstByte <= 0 {
+		t.Errorf("first byte took %v, want a positive duration", second.FirstByte)
+	}
+}
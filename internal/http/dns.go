@@ -0,0 +1,96 @@
+package http
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+)
+
+// ipResolver looks up the IP addresses of a host, as *net.Resolver does.
+type ipResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dnsCache caches the addresses of the hosts dialed, resolving them again
+// once they are older than ttl. When resolving a host fails, the addresses it
+// last resolved to are used instead, however old, so that a DNS outage does
+// not take down connections to servers that are still reachable.
+type dnsCache struct {
+	resolver ipResolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+// dnsEntry holds the addresses a host last resolved to.
+type dnsEntry struct {
+	addrs    []net.IPAddr
+	resolved time.Time
+}
+
+// newDNSCache creates a cache of the addresses resolved by resolver,
+// refreshed after ttl.
+func newDNSCache(resolver ipResolver, ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[string]dnsEntry),
+	}
+}
+
+// lookup returns the addresses of host, from the cache unless they are older
+// than the TTL.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	entry, cached := c.entries[host]
+	c.mu.Unlock()
+	if cached && c.now().Sub(entry.resolved) < c.ttl {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		if cached && ctx.Err() == nil {
+			log.Debugf("cannot resolve %v, using addresses resolved %v ago: %v", host, c.now().Sub(entry.resolved), err)
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, resolved: c.now()}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// dialContext returns a dial function that resolves hosts through the cache
+// and dials their addresses with dial in turn until one connects.
+func (c *dnsCache) dialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			var conn net.Conn
+			conn, err = dial(ctx, network, net.JoinHostPort(addr.String(), port))
+			if err == nil || ctx.Err() != nil {
+				return conn, err
+			}
+		}
+		return nil, err
+	}
+}
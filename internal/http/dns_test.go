@@ -0,0 +1,123 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// stubResolver resolves every host to addrs, unless fail is set.
+type stubResolver struct {
+	mu      sync.Mutex
+	addrs   []net.IPAddr
+	fail    bool
+	lookups int
+}
+
+func (r *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if r.fail {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+	return r.addrs, nil
+}
+
+func (r *stubResolver) set(fail bool, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail = fail
+	r.addrs = nil
+	for _, addr := range addrs {
+		r.addrs = append(r.addrs, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	resolver := &stubResolver{}
+	cache := newDNSCache(resolver, 10*time.Second)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	tests := []struct {
+		description string
+		elapsed     time.Duration
+		fail        bool
+		addr        string
+		want        string
+		wantLookups int
+	}{
+		{description: "resolved", addr: "192.0.2.1", want: "192.0.2.1", wantLookups: 1},
+		{description: "cached", elapsed: 5 * time.Second, addr: "192.0.2.2", want: "192.0.2.1", wantLookups: 1},
+		{description: "expired", elapsed: 6 * time.Second, addr: "192.0.2.2", want: "192.0.2.2", wantLookups: 2},
+		{description: "failure falls back", elapsed: 11 * time.Second, fail: true, want: "192.0.2.2", wantLookups: 3},
+		{description: "still failing", elapsed: time.Hour, fail: true, want: "192.0.2.2", wantLookups: 4},
+		{description: "recovered", elapsed: time.Second, addr: "192.0.2.3", want: "192.0.2.3", wantLookups: 5},
+	}
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		resolver.set(test.fail, test.addr)
+		addrs, err := cache.lookup(context.Background(), "server.test")
+		if err != nil {
+			t.Fatalf("%v: %v", test.description, err)
+		}
+		var got []string
+		for _, addr := range addrs {
+			got = append(got, addr.String())
+		}
+		if !cmp.Equal(got, []string{test.want}) {
+			t.Errorf("%v: got %v, want %v", test.description, got, test.want)
+		}
+		if resolver.lookups != test.wantLookups {
+			t.Errorf("%v: got %v lookups, want %v", test.description, resolver.lookups, test.wantLookups)
+		}
+	}
+
+	resolver.set(true)
+	var dnsErr *net.DNSError
+	if _, err := cache.lookup(context.Background(), "other.test"); !errors.As(err, &dnsErr) {
+		t.Errorf("got %v for a host never resolved, want a DNS error", err)
+	}
+}
+
+func TestClientDNSCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections are not kept alive, so every request dials and resolves
+	// the server again.
+	client := NewHTTPClientWithOptions(nil, "test", ClientOptions{
+		DNSCacheTTL:       time.Nanosecond,
+		DisableKeepAlives: true,
+	})
+	resolver := &stubResolver{}
+	client.dns.resolver = resolver
+
+	for i, fail := range []bool{false, true, false, true} {
+		resolver.set(fail, "127.0.0.1")
+		resp, err := client.Get("http://server.test:" + port + "/")
+		if err != nil {
+			t.Fatalf("request %v: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+	if resolver.lookups != 4 {
+		t.Errorf("got %v lookups, want 4", resolver.lookups)
+	}
+}
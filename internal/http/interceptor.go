@@ -0,0 +1,56 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// RequestInterceptor is called with each request before it is sent, and
+// returns the request to send in its place, which may be req itself, modified,
+// or a new request. Returning an error aborts the request, and the error is
+// returned, wrapped, to the caller of Client.Do.
+type RequestInterceptor func(req *http.Request) (*http.Request, error)
+
+// ResponseInterceptor is called with each response received, before its body
+// is read, and returns the response to pass on to the caller in its place. It
+// may change the status code or headers, for example to treat a custom
+// status as success or to add metadata, or replace the response altogether;
+// it must close the body of a response it replaces. Returning an error fails
+// the request, and the error is returned, wrapped, to the caller of
+// Client.Do.
+type ResponseInterceptor func(res *http.Response) (*http.Response, error)
+
+// interceptRequest passes req through interceptors in order, each receiving
+// the request returned by the one before.
+func interceptRequest(req *http.Request, interceptors []RequestInterceptor) (*http.Request, error) {
+	for _, intercept := range interceptors {
+		var err error
+		req, err = intercept(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot intercept request: %w", err)
+		}
+		if req == nil {
+			return nil, fmt.Errorf("cannot intercept request: interceptor returned no request")
+		}
+	}
+	return req, nil
+}
+
+// interceptResponse passes res through interceptors in order, each receiving
+// the response returned by the one before. The body of the response is
+// closed if an interceptor fails.
+func interceptResponse(res *http.Response, interceptors []ResponseInterceptor) (*http.Response, error) {
+	for _, intercept := range interceptors {
+		next, err := intercept(res)
+		if err != nil {
+			res.Body.Close()
+			return nil, fmt.Errorf("cannot intercept response: %w", err)
+		}
+		if next == nil {
+			res.Body.Close()
+			return nil, fmt.Errorf("cannot intercept response: interceptor returned no response")
+		}
+		res = next
+	}
+	return res, nil
+}
@@ -0,0 +1,137 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRequestInterceptors(t *testing.T) {
+	var order []string
+	tag := func(name string) RequestInterceptor {
+		return func(req *http.Request) (*http.Request, error) {
+			order = append(order, name)
+			req.Header.Add("X-Intercepted", name)
+			return req, nil
+		}
+	}
+	recorder := &recordingRoundTripper{}
+	client := NewHTTPClientWithTransport(recorder, "testUA", ClientOptions{
+		BearerToken:         func() (string, error) { return "token", nil },
+		RequestInterceptors: []RequestInterceptor{tag("first"), tag("second")},
+	})
+
+	res, err := client.Post("http://example.com/out", nil, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if !cmp.Equal(order, []string{"first", "second"}) {
+		t.Errorf("interceptors called in order %v", order)
+	}
+	req := recorder.requests[0]
+	if got := req.Header.Values("X-Intercepted"); !cmp.Equal(got, []string{"first", "second"}) {
+		t.Errorf("X-Intercepted %v != [first second]", got)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization %q != %q", got, "Bearer token")
+	}
+}
+
+func TestRequestInterceptorAborts(t *testing.T) {
+	errDenied := errors.New("denied")
+	var called bool
+	recorder := &recordingRoundTripper{}
+	client := NewHTTPClientWithTransport(recorder, "testUA", ClientOptions{
+		RequestInterceptors: []RequestInterceptor{
+			func(req *http.Request) (*http.Request, error) {
+				return nil, errDenied
+			},
+			func(req *http.Request) (*http.Request, error) {
+				called = true
+				return req, nil
+			},
+		},
+	})
+
+	_, err := client.Get("http://example.com/in")
+	if !errors.Is(err, errDenied) {
+		t.Errorf("got %v, want the interceptor error", err)
+	}
+	if called {
+		t.Error("interceptor called after the chain was aborted")
+	}
+	if len(recorder.requests) != 0 {
+		t.Errorf("sent %v requests, want none", len(recorder.requests))
+	}
+}
+
+func TestResponseInterceptors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClientWithOptions(nil, "testUA", ClientOptions{
+		ResponseInterceptors: []ResponseInterceptor{
+			func(res *http.Response) (*http.Response, error) {
+				if res.StatusCode == http.StatusTeapot {
+					res.StatusCode = http.StatusOK
+					res.Header.Set("X-Reclassified", "418")
+				}
+				return res, nil
+			},
+			func(res *http.Response) (*http.Response, error) {
+				res.Header.Set("X-Seen", res.Header.Get("X-Reclassified"))
+				return res, nil
+			},
+		},
+	})
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status %v != %v", res.StatusCode, http.StatusOK)
+	}
+	if got := res.Header.Get("X-Seen"); got != "418" {
+		t.Errorf("X-Seen %q != %q", got, "418")
+	}
+	if string(body) != "short and stout" {
+		t.Errorf("body %q != %q", body, "short and stout")
+	}
+}
+
+func TestResponseInterceptorFails(t *testing.T) {
+	errRejected := errors.New("rejected")
+	recorder := &recordingRoundTripper{}
+	client := NewHTTPClientWithTransport(recorder, "testUA", ClientOptions{
+		ResponseInterceptors: []ResponseInterceptor{
+			func(res *http.Response) (*http.Response, error) {
+				return nil, errRejected
+			},
+		},
+	})
+
+	res, err := client.Post("http://example.com/out", nil, []byte(`{}`))
+	if !errors.Is(err, errRejected) {
+		t.Errorf("got %v, want the interceptor error", err)
+	}
+	if res != nil {
+		t.Errorf("got response %v, want none", res.Status)
+	}
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// verifyOCSPStaple returns a tls.Config.VerifyConnection function that
+// requires the server to staple a current OCSP response reporting its
+// certificate as good, then calls next, if set. now is the time source used
+// to check that the response has not expired.
+func verifyOCSPStaple(next func(tls.ConnectionState) error, now func() time.Time) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if err := checkOCSPStaple(cs, now()); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+// checkOCSPStaple checks the OCSP response stapled in cs against the verified
+// server certificate and its issuer.
+func checkOCSPStaple(cs tls.ConnectionState, now time.Time) error {
+	if len(cs.OCSPResponse) == 0 {
+		return fmt.Errorf("cannot verify server certificate: no stapled OCSP response")
+	}
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return fmt.Errorf("cannot verify stapled OCSP response: no verified issuer")
+	}
+	leaf, issuer := cs.VerifiedChains[0][0], cs.VerifiedChains[0][1]
+
+	res, err := ocsp.ParseResponseForCert(cs.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("cannot parse stapled OCSP response: %w", err)
+	}
+	switch res.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		return fmt.Errorf("server certificate revoked at %v", res.RevokedAt)
+	default:
+		return fmt.Errorf("server certificate status unknown")
+	}
+	if !res.NextUpdate.IsZero() && now.After(res.NextUpdate) {
+		return fmt.Errorf("stapled OCSP response expired at %v", res.NextUpdate)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testPKI is a CA and a server certificate it issued, acting as the OCSP
+// responder for that certificate.
+type testPKI struct {
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	leaf   *x509.Certificate
+	server tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testPKI{
+		ca:     ca,
+		caKey:  caKey,
+		leaf:   leaf,
+		server: tls.Certificate{Certificate: [][]byte{leafDER}, PrivateKey: key},
+	}
+}
+
+// respond creates an OCSP response for the server certificate with the given
+// status, valid until nextUpdate.
+func (p *testPKI) respond(t *testing.T, status int, nextUpdate time.Time) []byte {
+	t.Helper()
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: p.leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   nextUpdate,
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+	}
+	res, err := ocsp.CreateResponse(p.ca, p.ca, template, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRequireOCSPStaple(t *testing.T) {
+	pki := newTestPKI(t)
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		description string
+		staple      []byte
+		require     bool
+		wantErr     bool
+	}{
+		{description: "good", staple: pki.respond(t, ocsp.Good, later), require: true},
+		{description: "revoked", staple: pki.respond(t, ocsp.Revoked, later), require: true, wantErr: true},
+		{description: "unknown", staple: pki.respond(t, ocsp.Unknown, later), require: true, wantErr: true},
+		{description: "expired", staple: pki.respond(t, ocsp.Good, time.Now().Add(-time.Second)), require: true, wantErr: true},
+		{description: "missing", require: true, wantErr: true},
+		{description: "missing but not required"},
+		{description: "revoked but not required", staple: pki.respond(t, ocsp.Revoked, later)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cert := pki.server
+			cert.OCSPStaple = test.staple
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			srv.StartTLS()
+			defer srv.Close()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(pki.ca)
+			client := NewHTTPClientWithOptions(&tls.Config{RootCAs: roots}, "testUA", ClientOptions{
+				RequireOCSPStaple: test.require,
+			})
+
+			res, err := client.Get(srv.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
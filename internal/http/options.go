@@ -0,0 +1,312 @@
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Default timeouts applied by NewHTTPClientWithOptions to zero-valued
+// ClientOptions fields.
+const (
+	// DefaultTimeout bounds the total time of a request, including reading
+	// the response body.
+	DefaultTimeout = 5 * time.Minute
+
+	// DefaultDialTimeout bounds the time taken to establish a TCP
+	// connection.
+	DefaultDialTimeout = 30 * time.Second
+
+	// DefaultTLSHandshakeTimeout bounds the time taken by the TLS handshake.
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+
+	// DefaultResponseHeaderTimeout bounds the time between sending a request
+	// and receiving the response headers.
+	DefaultResponseHeaderTimeout = time.Minute
+
+	// DefaultIdleConnTimeout bounds the time an idle connection is kept in
+	// the pool.
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// DefaultExpectContinueTimeout bounds the time waited for the server to
+	// accept a request sent with "Expect: 100-continue" before sending its
+	// body anyway.
+	DefaultExpectContinueTimeout = time.Second
+)
+
+// DefaultMaxResponseHeaderBytes is the default limit on the size of the
+// response headers a server may send.
+const DefaultMaxResponseHeaderBytes = 1 << 20
+
+// Default connection pool limits applied by NewHTTPClientWithOptions. A
+// polling client talks to a single server, keeping a connection per polled
+// channel and a few for concurrent sends.
+const (
+	// DefaultMaxIdleConns is the maximum number of idle connections kept
+	// across all hosts.
+	DefaultMaxIdleConns = 8
+
+	// DefaultMaxIdleConnsPerHost is the maximum number of idle connections
+	// kept to each host.
+	DefaultMaxIdleConnsPerHost = 4
+)
+
+// ClientOptions holds optional settings for a Client. Zero-valued fields are
+// replaced with their defaults; a negative timeout disables that timeout.
+type ClientOptions struct {
+	// Timeout bounds the total time of a request, including reading the
+	// response body. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// DialTimeout bounds the time taken to establish a TCP connection,
+	// including resolving the host. It is enforced by the dialer apart from
+	// Timeout, so that an unreachable server can fail fast while the
+	// overall timeout stays generous enough for slow transfers. Defaults to
+	// DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// Resolver, if set, resolves the hosts dialed instead of the default
+	// resolver, for example to query a specific DNS server.
+	Resolver *net.Resolver
+
+	// DNSCacheTTL caches the addresses of the hosts dialed for DNSCacheTTL
+	// instead of resolving them again for every connection. If resolving
+	// a host fails, the addresses it last resolved to are dialed instead,
+	// however old, so that a brief DNS outage does not fail requests to a
+	// server that is still reachable. Zero disables the cache.
+	DNSCacheTTL time.Duration
+
+	// TLSHandshakeTimeout bounds the time taken by the TLS handshake.
+	// Defaults to DefaultTLSHandshakeTimeout.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout bounds the time between sending a request and
+	// receiving the response headers. Defaults to
+	// DefaultResponseHeaderTimeout.
+	ResponseHeaderTimeout time.Duration
+
+	// ExpectContinueTimeout bounds the time waited for the server to accept
+	// a request sent with "Expect: 100-continue" before sending its body
+	// anyway. Defaults to DefaultExpectContinueTimeout; a negative value
+	// sends the body without waiting.
+	ExpectContinueTimeout time.Duration
+
+	// MaxResponseHeaderBytes limits the size, in bytes, of the response
+	// headers a server may send, including the status line. Requests whose
+	// response headers exceed it fail with an error wrapping
+	// ErrResponseHeaderTooLarge. Defaults to DefaultMaxResponseHeaderBytes.
+	MaxResponseHeaderBytes int64
+
+	// ProxyURL is the proxy through which requests are sent, overriding the
+	// HTTP_PROXY and HTTPS_PROXY environment variables. Basic authentication
+	// credentials for the proxy may be given in its user info. Hosts listed
+	// in NO_PROXY bypass the proxy either way. When nil, the proxy is taken
+	// from the environment.
+	ProxyURL *url.URL
+
+	// RequireOCSPStaple rejects TLS connections unless the server staples
+	// an OCSP response reporting its certificate as good. Go clients request
+	// a stapled response in every handshake; servers return it in the
+	// CertificateStatus message in TLS 1.2 and in the certificate entry in
+	// TLS 1.3, and in the same way as TLS 1.2 for TLS 1.0 and 1.1. Servers
+	// that do not staple are rejected whatever the version. It has no
+	// effect on plain HTTP.
+	RequireOCSPStaple bool
+
+	// TLSPolicy, if set, is applied to the TLS config the client is created
+	// with, enforcing a minimum TLS version and restricting the cipher
+	// suites and curves negotiated.
+	TLSPolicy *TLSPolicy
+
+	// SessionResumption keeps TLS sessions in a client session cache, so
+	// that reconnecting to a server resumes the previous session instead of
+	// performing a full handshake. A ClientSessionCache already set on the
+	// TLS config is used as is. Off by default.
+	SessionResumption bool
+
+	// SessionCacheSize is the number of sessions kept when
+	// SessionResumption is set. Defaults to 64.
+	SessionCacheSize int
+
+	// Disable0RTT forbids sending 0-RTT early data on resumed TLS 1.3
+	// sessions. The crypto/tls client never sends early data, so 0-RTT is
+	// always disabled; the option lets a security policy state the
+	// requirement explicitly and keeps it in force should the client ever
+	// gain support for it.
+	Disable0RTT bool
+
+	// Renegotiation controls whether servers may renegotiate TLS 1.2
+	// connections, overriding the setting of the TLS config the client is
+	// created with. Renegotiation has been the vector of attacks such as
+	// the TLS authentication gap (CVE-2009-3555) and triple handshake
+	// attacks, and lets a server request a different client certificate
+	// mid-connection, so the zero value, tls.RenegotiateNever, refuses it.
+	// TLS 1.3 does not support renegotiation.
+	Renegotiation tls.RenegotiationSupport
+
+	// ServerName, if set, overrides the server name of the TLS config the
+	// client is created with. It is sent in the SNI extension and the
+	// server certificate is verified against it, while connections are
+	// still made to the host of each request URL. This covers servers
+	// reached by an IP address or internal DNS name whose certificate is
+	// issued for another name.
+	ServerName string
+
+	// PinnedSPKI lists base64-encoded SHA-256 hashes of the
+	// SubjectPublicKeyInfo of trusted server certificates. When set, a
+	// handshake fails unless a certificate in the chain presented by the
+	// server matches a pin, in addition to the usual chain verification.
+	PinnedSPKI []string
+
+	// MaxIdleConns is the maximum number of idle connections kept across all
+	// hosts. Defaults to DefaultMaxIdleConns; a negative value removes the
+	// limit.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept to
+	// each host. Defaults to DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout bounds the time an idle connection is kept in the
+	// pool. Defaults to DefaultIdleConnTimeout. It should be longer than the
+	// polling interval for connections to be reused between polls.
+	IdleConnTimeout time.Duration
+
+	// RefreshAfterIdle closes the pooled connections before a request sent
+	// after the client has sent no request for RefreshAfterIdle, so that it
+	// dials a fresh connection instead of reusing one that a NAT gateway,
+	// firewall or load balancer may have silently dropped in the meantime,
+	// failing the request. Unlike IdleConnTimeout, which the pool enforces
+	// on each connection, it is driven by the idle time of the client as a
+	// whole, observed as requests are sent, and so catches connections that
+	// intermediaries drop sooner than the pool expires them. Zero disables
+	// it.
+	RefreshAfterIdle time.Duration
+
+	// DisableKeepAlives closes every connection after a single request
+	// instead of returning it to the pool.
+	DisableKeepAlives bool
+
+	// TraceConnections logs, at debug level, how long each request spent
+	// on the DNS lookup, the TCP connection, the TLS handshake and waiting
+	// for the first byte of the response. Off by default, as tracing adds
+	// overhead to every request.
+	TraceConnections bool
+
+	// OnConnectionTiming, if set, is called with the timing of each
+	// request, as logged by TraceConnections, for example to feed metrics.
+	OnConnectionTiming ConnectionTimingFunc
+
+	// BasicAuth, if set, authenticates every request with HTTP basic
+	// authentication.
+	BasicAuth *BasicAuth
+
+	// BearerToken, if set, is called before every request for a token sent
+	// in an "Authorization: Bearer" header, taking precedence over
+	// BasicAuth.
+	BearerToken TokenFunc
+
+	// RequestInterceptors are applied in order to every request the client
+	// sends, after its credentials have been added, so that they can sign
+	// or otherwise rewrite the request as it is about to be sent. None are
+	// applied by default.
+	RequestInterceptors []RequestInterceptor
+
+	// ResponseInterceptors are applied in order to every response the
+	// client receives, before its body is read, so that they can inspect
+	// or rewrite its status and headers. None are applied by default.
+	ResponseInterceptors []ResponseInterceptor
+
+	// Redirects controls which redirects the client follows. The zero
+	// value follows up to 10 redirects to any host, like net/http.
+	Redirects RedirectPolicy
+}
+
+// RedirectPolicy controls how a client follows HTTP redirects.
+type RedirectPolicy struct {
+	// Disable stops the client from following any redirect. The redirect
+	// response itself is returned to the caller.
+	Disable bool
+
+	// Max is the maximum number of redirects followed for a request.
+	// Defaults to 10.
+	Max int
+
+	// SameHost restricts redirects to the scheme and host of the original
+	// request, so credentials and client certificates are never presented
+	// to another server.
+	SameHost bool
+}
+
+// defaultMaxRedirects is the number of redirects net/http follows by default.
+const defaultMaxRedirects = 10
+
+// checkRedirect returns an http.Client.CheckRedirect function enforcing p.
+func (p RedirectPolicy) checkRedirect() func(*http.Request, []*http.Request) error {
+	max := p.Max
+	if max == 0 {
+		max = defaultMaxRedirects
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if p.Disable {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > max {
+			return fmt.Errorf("stopped after %v redirects", max)
+		}
+		if p.SameHost {
+			orig := via[0].URL
+			if req.URL.Scheme != orig.Scheme || req.URL.Host != orig.Host {
+				return fmt.Errorf("refusing redirect from %v://%v to %v://%v", orig.Scheme, orig.Host, req.URL.Scheme, req.URL.Host)
+			}
+		}
+		return nil
+	}
+}
+
+// timeout returns d, or def if d is zero. Negative durations disable the
+// timeout and are returned as zero, which the net/http package treats as no
+// timeout.
+func timeout(d, def time.Duration) time.Duration {
+	switch {
+	case d == 0:
+		return def
+	case d < 0:
+		return 0
+	default:
+		return d
+	}
+}
+
+// limit returns n, or def if n is zero. Negative values remove the limit and
+// are returned as zero, which the net/http package treats as no limit.
+func limit(n, def int) int {
+	switch {
+	case n == 0:
+		return def
+	case n < 0:
+		return 0
+	default:
+		return n
+	}
+}
+
+// proxyFunc returns a function that selects the proxy for a request according
+// to opts and the proxy environment variables as they are set now.
+func proxyFunc(opts ClientOptions) func(*http.Request) (*url.URL, error) {
+	config := httpproxy.FromEnvironment()
+	if opts.ProxyURL != nil {
+		config.HTTPProxy = opts.ProxyURL.String()
+		config.HTTPSProxy = opts.ProxyURL.String()
+	}
+	proxy := config.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// spkiPin returns the base64-encoded SHA-256 hash of the SubjectPublicKeyInfo
+// of cert.
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPins returns a tls.Config.VerifyPeerCertificate function that
+// rejects a handshake unless the public key of a certificate in a verified
+// chain of the server's certificate matches one of pins, then calls next, if
+// set. The certificates the server sent are not matched as such: a server
+// can send any certificate, including a pinned one it holds no key for.
+// Without verified chains, as with InsecureSkipVerify, only the server's own
+// certificate is matched.
+func verifyPins(pins []string, next func([][]byte, [][]*x509.Certificate) error) func([][]byte, [][]*x509.Certificate) error {
+	allowed := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		allowed[pin] = true
+	}
+
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		chains := verifiedChains
+		if len(chains) == 0 && len(rawCerts) > 0 {
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return fmt.Errorf("cannot parse server certificate: %w", err)
+			}
+			chains = [][]*x509.Certificate{{leaf}}
+		}
+		matched := false
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if allowed[spkiPin(cert)] {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return fmt.Errorf("cannot verify server certificate: no pinned public key in chain")
+		}
+		if next != nil {
+			return next(rawCerts, verifiedChains)
+		}
+		return nil
+	}
+}
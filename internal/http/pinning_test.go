@@ -0,0 +1,91 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPinnedSPKI(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		description string
+		pins        []string
+		wantErr     bool
+	}{
+		{description: "no pins"},
+		{description: "matching pin", pins: []string{spkiPin(srv.Certificate())}},
+		{description: "one of several pins", pins: []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", spkiPin(srv.Certificate())}},
+		{description: "wrong pin", pins: []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			roots := x509.NewCertPool()
+			roots.AddCert(srv.Certificate())
+			client := NewHTTPClientWithOptions(&tls.Config{RootCAs: roots}, "testUA", ClientOptions{
+				PinnedSPKI: test.pins,
+			})
+
+			res, err := client.Get(srv.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPinnedSPKIAppendedCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	pinned := newCertificate(t, "pinned")
+	pinnedCert, err := x509.ParseCertificate(pinned.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		pin         string
+		wantErr     bool
+	}{
+		{description: "pinned CA", pin: spkiPin(pki.ca)},
+		{description: "pinned certificate outside the verified chain", pin: spkiPin(pinnedCert), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// The server sends the pinned certificate after its own, whose
+			// chain to the trusted CA does not include it.
+			cert := pki.server
+			cert.Certificate = append(cert.Certificate[:1:1], pinned.Certificate[0])
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			srv.StartTLS()
+			defer srv.Close()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(pki.ca)
+			client := NewHTTPClientWithOptions(&tls.Config{RootCAs: roots}, "testUA", ClientOptions{
+				PinnedSPKI: []string{test.pin},
+			})
+
+			res, err := client.Get(srv.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer other.Close()
+
+	// /twice redirects to /once, which redirects to /, on the same host;
+	// /away redirects to another host.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/twice":
+			http.Redirect(w, req, "/once", http.StatusFound)
+		case "/once":
+			http.Redirect(w, req, "/", http.StatusFound)
+		case "/away":
+			http.Redirect(w, req, other.URL, http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		description string
+		policy      RedirectPolicy
+		path        string
+		wantStatus  int
+		wantErr     bool
+	}{
+		{description: "default same host", path: "/twice", wantStatus: http.StatusOK},
+		{description: "default other host", path: "/away", wantStatus: http.StatusOK},
+		{description: "disabled", policy: RedirectPolicy{Disable: true}, path: "/once", wantStatus: http.StatusFound},
+		{description: "within limit", policy: RedirectPolicy{Max: 2}, path: "/twice", wantStatus: http.StatusOK},
+		{description: "over limit", policy: RedirectPolicy{Max: 1}, path: "/twice", wantErr: true},
+		{description: "same host allowed", policy: RedirectPolicy{SameHost: true}, path: "/twice", wantStatus: http.StatusOK},
+		{description: "other host refused", policy: RedirectPolicy{SameHost: true}, path: "/away", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			client := NewHTTPClientWithOptions(nil, "testUA", ClientOptions{Redirects: test.policy})
+			res, err := client.Get(srv.URL + test.path)
+			if test.wantErr {
+				if err == nil {
+					res.Body.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != test.wantStatus {
+				t.Errorf("status %v != %v", res.StatusCode, test.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package http
+
+import "crypto/tls"
+
+// TLSPolicy restricts the TLS versions, cipher suites and key exchange curves
+// a client negotiates. Zero-valued fields leave the corresponding settings
+// of the config it is applied to unchanged.
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version accepted, such as
+	// tls.VersionTLS12.
+	MinVersion uint16
+
+	// CipherSuites lists the cipher suites enabled for TLS 1.2 and below.
+	// TLS 1.3 cipher suites are not configurable.
+	CipherSuites []uint16
+
+	// CurvePreferences lists the elliptic curves used for key exchange, in
+	// order of preference.
+	CurvePreferences []tls.CurveID
+}
+
+// ModernTLSPolicy returns a policy that requires TLS 1.3.
+func ModernTLSPolicy() TLSPolicy {
+	return TLSPolicy{
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	}
+}
+
+// IntermediateTLSPolicy returns a policy that requires TLS 1.2 or later and
+// restricts TLS 1.2 to forward-secret AEAD cipher suites.
+func IntermediateTLSPolicy() TLSPolicy {
+	return TLSPolicy{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	}
+}
+
+// Apply returns a clone of config with the policy applied. All other
+// settings, including certificates and root CAs, are kept. The policy only
+// tightens config: a minimum version config already sets above MinVersion is
+// kept, and cipher suites and curves that config already lists are narrowed
+// to those the policy also allows, in the order of config. If config lists
+// no cipher suite the policy allows, TLS 1.3 is required, since its cipher
+// suites are not configurable; if it lists no such curve, those of the
+// policy are used. A nil config is treated as an empty one.
+func (p TLSPolicy) Apply(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if p.MinVersion > config.MinVersion {
+		config.MinVersion = p.MinVersion
+	}
+	if p.CipherSuites != nil {
+		config.CipherSuites = intersectCipherSuites(config.CipherSuites, p.CipherSuites)
+		if len(config.CipherSuites) == 0 {
+			// An empty list would enable the default cipher suites.
+			config.CipherSuites = append([]uint16(nil), p.CipherSuites...)
+			config.MinVersion = tls.VersionTLS13
+		}
+	}
+	if p.CurvePreferences != nil {
+		config.CurvePreferences = intersectCurves(config.CurvePreferences, p.CurvePreferences)
+		if len(config.CurvePreferences) == 0 {
+			config.CurvePreferences = append([]tls.CurveID(nil), p.CurvePreferences...)
+		}
+	}
+	return config
+}
+
+// intersectCipherSuites returns the cipher suites of configured that allowed
+// also lists, or allowed if configured is empty.
+func intersectCipherSuites(configured, allowed []uint16) []uint16 {
+	if len(configured) == 0 {
+		return append([]uint16(nil), allowed...)
+	}
+	var suites []uint16
+	for _, s := range configured {
+		for _, a := range allowed {
+			if s == a {
+				suites = append(suites, s)
+				break
+			}
+		}
+	}
+	return suites
+}
+
+// intersectCurves returns the curves of configured that allowed also lists,
+// or allowed if configured is empty.
+func intersectCurves(configured, allowed []tls.CurveID) []tls.CurveID {
+	if len(configured) == 0 {
+		return append([]tls.CurveID(nil), allowed...)
+	}
+	var curves []tls.CurveID
+	for _, c := range configured {
+		for _, a := range allowed {
+			if c == a {
+				curves = append(curves, c)
+				break
+			}
+		}
+	}
+	return curves
+}
@@ -0,0 +1,122 @@
+//go:build go1.16
+// +build go1.16
+
+package http
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTLSPolicyApply(t *testing.T) {
+	tests := []struct {
+		description string
+		policy      TLSPolicy
+		min         uint16
+		want        uint16
+	}{
+		{description: "modern", policy: ModernTLSPolicy(), min: tls.VersionTLS10, want: tls.VersionTLS13},
+		{description: "intermediate", policy: IntermediateTLSPolicy(), min: tls.VersionTLS10, want: tls.VersionTLS12},
+		{description: "empty", policy: TLSPolicy{}, min: tls.VersionTLS10, want: tls.VersionTLS10},
+		{description: "stricter config", policy: IntermediateTLSPolicy(), min: tls.VersionTLS13, want: tls.VersionTLS13},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cert := tls.Certificate{Certificate: [][]byte{{0x01}}}
+			config := &tls.Config{
+				MinVersion:   test.min,
+				Certificates: []tls.Certificate{cert},
+				ServerName:   "example.com",
+			}
+
+			got := test.policy.Apply(config)
+			if got.MinVersion != test.want {
+				t.Errorf("MinVersion %x != %x", got.MinVersion, test.want)
+			}
+			if len(got.Certificates) != 1 || got.ServerName != "example.com" {
+				t.Error("policy did not keep the caller's settings")
+			}
+			if config.MinVersion != test.min {
+				t.Error("policy modified the caller's config")
+			}
+		})
+	}
+}
+
+func TestTLSPolicyApplyLists(t *testing.T) {
+	tests := []struct {
+		description string
+		suites      []uint16
+		curves      []tls.CurveID
+		wantSuites  []uint16
+		wantCurves  []tls.CurveID
+		wantMin     uint16
+	}{
+		{
+			description: "unset",
+			wantSuites:  IntermediateTLSPolicy().CipherSuites,
+			wantCurves:  IntermediateTLSPolicy().CurvePreferences,
+			wantMin:     tls.VersionTLS12,
+		},
+		{
+			description: "narrower config",
+			suites:      []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305, tls.TLS_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			curves:      []tls.CurveID{tls.CurveP384, tls.CurveP521},
+			wantSuites:  []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			wantCurves:  []tls.CurveID{tls.CurveP384},
+			wantMin:     tls.VersionTLS12,
+		},
+		{
+			description: "disjoint config",
+			suites:      []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+			curves:      []tls.CurveID{tls.CurveP521},
+			wantSuites:  IntermediateTLSPolicy().CipherSuites,
+			wantCurves:  IntermediateTLSPolicy().CurvePreferences,
+			wantMin:     tls.VersionTLS13,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got := IntermediateTLSPolicy().Apply(&tls.Config{
+				CipherSuites:     test.suites,
+				CurvePreferences: test.curves,
+			})
+			if !cmp.Equal(got.CipherSuites, test.wantSuites) {
+				t.Errorf("CipherSuites %x != %x", got.CipherSuites, test.wantSuites)
+			}
+			if !cmp.Equal(got.CurvePreferences, test.wantCurves) {
+				t.Errorf("CurvePreferences %v != %v", got.CurvePreferences, test.wantCurves)
+			}
+			if got.MinVersion != test.wantMin {
+				t.Errorf("MinVersion %x != %x", got.MinVersion, test.wantMin)
+			}
+		})
+	}
+}
+
+func TestTLSPolicyApplyNil(t *testing.T) {
+	got := IntermediateTLSPolicy().Apply(nil)
+	if got.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion %x != %x", got.MinVersion, tls.VersionTLS12)
+	}
+	if len(got.CipherSuites) == 0 {
+		t.Error("cipher suites not restricted")
+	}
+}
+
+func TestClientTLSPolicy(t *testing.T) {
+	policy := ModernTLSPolicy()
+	client := NewHTTPClientWithOptions(&tls.Config{MinVersion: tls.VersionTLS12}, "testUA", ClientOptions{
+		TLSPolicy: &policy,
+	})
+
+	config := client.transport.current.Load().(*http.Transport).TLSClientConfig
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion %x != %x", config.MinVersion, tls.VersionTLS13)
+	}
+}
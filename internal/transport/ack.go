@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// acknowledge reports to the server whether the message received on channel
+// with the given request ID was handled, failing with handleErr, if
+// AckMessages is set. A failure to acknowledge is only logged: the server
+// redelivers messages it has not heard back about.
+func (t *HTTP) acknowledge(ctx context.Context, channel string, id string, handleErr error) {
+	if !t.opts.AckMessages {
+		return
+	}
+	if id == "" {
+		t.opts.Logger.Debug("cannot acknowledge message without a request ID", "channel", channel)
+		return
+	}
+	direction := "ack"
+	if handleErr != nil {
+		direction = "nack"
+	}
+	if err := t.postAck(ctx, channel, direction, id); err != nil {
+		t.opts.Logger.Warn("cannot acknowledge message", "channel", channel, "request_id", id, "direction", direction, "error", err)
+		return
+	}
+	t.opts.Logger.Debug("acknowledged message", "channel", channel, "request_id", id, "direction", direction)
+}
+
+func (t *HTTP) postAck(ctx context.Context, channel string, direction string, id string) error {
+	reqURL, err := t.requestURL(direction, channel)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, nil)
+	if err != nil {
+		return permanentError(fmt.Errorf("cannot create HTTP request: %w", err))
+	}
+	t.setHeaders(req.Header)
+	req.Header.Set(RequestIDHeader, id)
+	injectTraceContext(ctx, req.Header)
+	start := time.Now()
+	res, err := t.do(t.client, req)
+	if err != nil {
+		t.observeRequest(channel, direction, 0, time.Since(start), 0, 0)
+		return requestError(fmt.Errorf("cannot do HTTP request: %w", err))
+	}
+	defer res.Body.Close()
+	t.observeRequest(channel, direction, res.StatusCode, time.Since(start), 0, 0)
+	if res.StatusCode >= 400 {
+		return HTTPStatusError{StatusCode: res.StatusCode, Header: res.Header}
+	}
+	return nil
+}
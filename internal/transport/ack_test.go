@@ -0,0 +1,147 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAckMessages(t *testing.T) {
+	tests := []struct {
+		description string
+		ack         bool
+		id          string
+		handler     DataReceiveHandlerFunc
+		want        []string
+	}{
+		{
+			description: "ack on success",
+			ack:         true,
+			id:          "1",
+			handler:     func([]byte, string) error { return nil },
+			want:        []string{"ack 1"},
+		},
+		{
+			description: "nack on error",
+			ack:         true,
+			id:          "2",
+			handler:     func([]byte, string) error { return errors.New("cannot process message") },
+			want:        []string{"nack 2"},
+		},
+		{
+			description: "nack on panic",
+			ack:         true,
+			id:          "3",
+			handler:     func([]byte, string) error { panic("boom") },
+			want:        []string{"nack 3"},
+		},
+		{
+			description: "no request ID",
+			ack:         true,
+			handler:     func([]byte, string) error { return nil },
+		},
+		{
+			description: "disabled",
+			id:          "4",
+			handler:     func([]byte, string) error { return errors.New("cannot process message") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			var got []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Method == http.MethodPost {
+					mu.Lock()
+					got = append(got, path.Base(req.URL.Path)+" "+req.Header.Get(RequestIDHeader))
+					mu.Unlock()
+					return
+				}
+				if test.id != "" {
+					w.Header().Set(RequestIDHeader, test.id)
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", test.handler, HTTPOptions{
+				AckMessages: test.ack,
+				Logger:      &captureLogger{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The handler's failure does not fail the poll.
+			if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%#v != %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAckMessagesLongPollReceiveWorkers(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			mu.Lock()
+			got = append(got, path.Base(req.URL.Path)+" "+req.Header.Get(RequestIDHeader))
+			mu.Unlock()
+			return
+		}
+		w.Header().Set(RequestIDHeader, "1")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		AckMessages:    true,
+		LongPoll:       true,
+		ReceiveWorkers: 1,
+		Logger:         &captureLogger{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transport.fetch(context.Background(), "data", true); err != nil {
+		t.Fatal(err)
+	}
+	// The worker handles the message only once the long poll has returned.
+	transport.receivePool.drain()
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"ack 1"}; !cmp.Equal(got, want) {
+		t.Errorf("%#v != %#v", got, want)
+	}
+}
+
+func TestNoErrorHandler(t *testing.T) {
+	var got string
+	handler := NoErrorHandler(func(data []byte, dest string) {
+		got = dest + " " + string(data)
+	})
+	if err := handler([]byte("message"), "data"); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	if got != "data message" {
+		t.Errorf("handler called with %q", got)
+	}
+}
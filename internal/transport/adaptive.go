@@ -0,0 +1,103 @@
+package transport
+
+import "time"
+
+const (
+	// DefaultAdaptiveMinInterval is the default floor of the adaptive
+	// polling interval.
+	DefaultAdaptiveMinInterval = time.Second
+
+	// DefaultAdaptiveMaxInterval is the default ceiling of the adaptive
+	// polling interval.
+	DefaultAdaptiveMaxInterval = 5 * time.Minute
+
+	// DefaultAdaptiveIncrease is the default step by which the adaptive
+	// polling interval grows after each empty poll.
+	DefaultAdaptiveIncrease = 5 * time.Second
+
+	// DefaultAdaptiveDecrease is the default factor by which the adaptive
+	// polling interval shrinks after each poll that receives a message.
+	DefaultAdaptiveDecrease = 0.5
+)
+
+// AdaptivePolling configures a polling interval that follows the rate of
+// inbound messages: it is multiplied by Decrease after each poll that
+// receives a message, and grows by Increase after each empty one, bounded by
+// MinInterval and MaxInterval (additive increase, multiplicative decrease),
+// so that busy channels are polled quickly and idle ones slowly. Each channel
+// starts from its configured polling interval. Zero fields take their
+// defaults.
+type AdaptivePolling struct {
+	// MinInterval is the floor of the interval. Defaults to
+	// DefaultAdaptiveMinInterval.
+	MinInterval time.Duration
+
+	// MaxInterval is the ceiling of the interval. Defaults to
+	// DefaultAdaptiveMaxInterval.
+	MaxInterval time.Duration
+
+	// Increase is added to the interval after each empty poll. Defaults to
+	// DefaultAdaptiveIncrease.
+	Increase time.Duration
+
+	// Decrease, between 0 and 1, multiplies the interval after each poll
+	// that receives a message. Defaults to DefaultAdaptiveDecrease.
+	Decrease float64
+}
+
+// withDefaults returns a copy of p with its zero fields set to their
+// defaults.
+func (p AdaptivePolling) withDefaults() AdaptivePolling {
+	if p.MinInterval == 0 {
+		p.MinInterval = DefaultAdaptiveMinInterval
+	}
+	if p.MaxInterval == 0 {
+		p.MaxInterval = DefaultAdaptiveMaxInterval
+	}
+	if p.MaxInterval < p.MinInterval {
+		p.MaxInterval = p.MinInterval
+	}
+	if p.Increase == 0 {
+		p.Increase = DefaultAdaptiveIncrease
+	}
+	if p.Decrease <= 0 || p.Decrease >= 1 {
+		p.Decrease = DefaultAdaptiveDecrease
+	}
+	return p
+}
+
+// adaptiveInterval is the adaptive polling interval of a channel.
+type adaptiveInterval struct {
+	policy   AdaptivePolling
+	interval time.Duration
+}
+
+// newAdaptiveInterval returns an interval adapting according to policy,
+// starting from initial.
+func newAdaptiveInterval(policy AdaptivePolling, initial time.Duration) *adaptiveInterval {
+	a := &adaptiveInterval{policy: policy.withDefaults(), interval: initial}
+	a.interval = a.clamp(a.interval)
+	return a
+}
+
+// next updates the interval after a poll, which received a message if
+// received is set, and returns it.
+func (a *adaptiveInterval) next(received bool) time.Duration {
+	if received {
+		a.interval = a.clamp(time.Duration(float64(a.interval) * a.policy.Decrease))
+	} else {
+		a.interval = a.clamp(a.interval + a.policy.Increase)
+	}
+	return a.interval
+}
+
+// clamp bounds d by the floor and ceiling of the interval.
+func (a *adaptiveInterval) clamp(d time.Duration) time.Duration {
+	if d < a.policy.MinInterval {
+		return a.policy.MinInterval
+	}
+	if d > a.policy.MaxInterval {
+		return a.policy.MaxInterval
+	}
+	return d
+}
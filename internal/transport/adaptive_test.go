@@ -0,0 +1,125 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+	policy := AdaptivePolling{
+		MinInterval: time.Second,
+		MaxInterval: 20 * time.Second,
+		Increase:    4 * time.Second,
+		Decrease:    0.5,
+	}
+
+	tests := []struct {
+		description string
+		initial     time.Duration
+		received    []bool
+		want        []time.Duration
+	}{
+		{
+			description: "burst",
+			initial:     10 * time.Second,
+			received:    []bool{true, true, true, true, true},
+			want:        []time.Duration{5 * time.Second, 2500 * time.Millisecond, 1250 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			description: "idle",
+			initial:     10 * time.Second,
+			received:    []bool{false, false, false, false},
+			want:        []time.Duration{14 * time.Second, 18 * time.Second, 20 * time.Second, 20 * time.Second},
+		},
+		{
+			description: "burst after idle",
+			initial:     20 * time.Second,
+			received:    []bool{true, true, false, true},
+			want:        []time.Duration{10 * time.Second, 5 * time.Second, 9 * time.Second, 4500 * time.Millisecond},
+		},
+		{
+			description: "initial out of bounds",
+			initial:     time.Hour,
+			received:    []bool{false},
+			want:        []time.Duration{20 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			a := newAdaptiveInterval(policy, test.initial)
+			for i, received := range test.received {
+				got := a.next(received)
+				if got != test.want[i] {
+					t.Errorf("poll %v: got %v, want %v", i+1, got, test.want[i])
+				}
+				if got < policy.MinInterval || got > policy.MaxInterval {
+					t.Errorf("poll %v: %v out of bounds", i+1, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAdaptiveIntervalDefaults(t *testing.T) {
+	a := newAdaptiveInterval(AdaptivePolling{Decrease: 2}, time.Minute)
+	if got, want := a.next(false), time.Minute+DefaultAdaptiveIncrease; got != want {
+		t.Errorf("got %v after an empty poll, want %v", got, want)
+	}
+	if got, want := a.next(true), time.Duration(float64(time.Minute+DefaultAdaptiveIncrease)*DefaultAdaptiveDecrease); got != want {
+		t.Errorf("got %v after a message, want %v", got, want)
+	}
+}
+
+func TestPollAdaptsToMessageRate(t *testing.T) {
+	// The server delivers a burst of two messages, then goes idle.
+	var mu sync.Mutex
+	pending := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if pending == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		pending--
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: 8 * time.Second,
+		AdaptivePolling: &AdaptivePolling{
+			MinInterval: 3 * time.Second,
+			MaxInterval: 12 * time.Second,
+			Increase:    5 * time.Second,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport.wg.Add(1)
+	go transport.poll(ctx, "data")
+
+	want := []time.Duration{4 * time.Second, 3 * time.Second, 8 * time.Second, 12 * time.Second, 12 * time.Second}
+	for i, w := range want {
+		if d := <-clock.sleeps; d != w {
+			t.Errorf("poll %v waited %v, want %v", i+1, d, w)
+		}
+	}
+	cancel()
+	close(clock.stop)
+	waitGroupTimeout(t, transport, time.Second)
+}
@@ -0,0 +1,347 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/Azure/go-amqp"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+// AMQPOptions holds optional settings for an AMQP transport. Zero-valued
+// fields are replaced with their defaults by NewAMQPTransportWithOptions.
+type AMQPOptions struct {
+	// InboundAddresses maps the "control" and "data" channels to the
+	// addresses of the receiver links messages are received from. Defaults
+	// to "<prefix>/<client ID>/<channel>/in", mirroring the MQTT topics.
+	InboundAddresses map[string]string
+
+	// OutboundAddresses maps the "control" and "data" channels to the
+	// addresses of the sender links messages are sent to. Defaults to
+	// "<prefix>/<client ID>/<channel>/out".
+	OutboundAddresses map[string]string
+
+	// Backoff computes the delay between reconnection attempts after the
+	// connection or a link fails. Defaults to an ExponentialBackoff from one
+	// second up to DefaultMaxBackoff.
+	Backoff BackoffStrategy
+}
+
+// AMQP is a Transporter that sends and receives data and control messages
+// over AMQP 1.0, through a sender and a receiver link per channel on a single
+// connection, reconnecting with backoff when the connection or a link fails.
+type AMQP struct {
+	clientID    string
+	server      string
+	dataHandler DataReceiveHandlerFunc
+	opts        AMQPOptions
+
+	// handling counts calls to the data handler in progress.
+	handling int32
+
+	// mu guards the fields below.
+	mu        sync.Mutex
+	tlsConfig *tls.Config
+	conn      *amqpConn
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// amqpConn is an AMQP connection with the links of both channels attached.
+type amqpConn struct {
+	client    *amqp.Client
+	senders   map[string]*amqp.Sender
+	receivers map[string]*amqp.Receiver
+}
+
+// NewAMQPTransport creates a transport that exchanges messages with the AMQP
+// 1.0 broker at server, given as host[:port], using the default options. A
+// nil tlsConfig creates a transport that connects without TLS.
+func NewAMQPTransport(clientID string, server string, tlsConfig *tls.Config, dataRecvFunc DataReceiveHandlerFunc) (*AMQP, error) {
+	return NewAMQPTransportWithOptions(clientID, server, tlsConfig, dataRecvFunc, AMQPOptions{})
+}
+
+// NewAMQPTransportWithOptions creates an AMQP transport configured by opts.
+func NewAMQPTransportWithOptions(clientID string, server string, tlsConfig *tls.Config, dataRecvFunc DataReceiveHandlerFunc, opts AMQPOptions) (*AMQP, error) {
+	inbound := make(map[string]string)
+	outbound := make(map[string]string)
+	for _, channel := range []string{"control", "data"} {
+		inbound[channel] = fmt.Sprintf("%v/%v/%v/in", yggdrasil.PathPrefix, clientID, channel)
+		outbound[channel] = fmt.Sprintf("%v/%v/%v/out", yggdrasil.PathPrefix, clientID, channel)
+	}
+	for channel, address := range opts.InboundAddresses {
+		inbound[channel] = address
+	}
+	for channel, address := range opts.OutboundAddresses {
+		outbound[channel] = address
+	}
+	opts.InboundAddresses = inbound
+	opts.OutboundAddresses = outbound
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff{
+			Min:        time.Second,
+			Max:        DefaultMaxBackoff,
+			Multiplier: DefaultBackoffMultiplier,
+		}
+	}
+
+	return &AMQP{
+		clientID:    clientID,
+		server:      server,
+		dataHandler: dataRecvFunc,
+		opts:        opts,
+		tlsConfig:   tlsConfig.Clone(),
+	}, nil
+}
+
+// Connect dials the broker, attaches the links and starts receiving
+// messages, returning an error if the first connection attempt fails.
+func (t *AMQP) Connect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+	if t.conn != nil {
+		t.conn.close()
+		t.conn = nil
+	}
+
+	conn, err := t.dial(t.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("cannot connect to broker: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.conn = conn
+	t.cancel = cancel
+
+	t.wg.Add(1)
+	go t.run(ctx, conn)
+
+	return nil
+}
+
+// run receives messages on conn until it fails, then reconnects, until ctx
+// is cancelled.
+func (t *AMQP) run(ctx context.Context, conn *amqpConn) {
+	defer t.wg.Done()
+
+	for {
+		if err := t.receive(ctx, conn); err != nil && ctx.Err() == nil {
+			log.Errorf("connection lost unexpectedly: %v", err)
+		}
+		conn.close()
+
+		conn = t.reconnect(ctx)
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials the broker, backing off between failed attempts, until it
+// succeeds or ctx is cancelled, in which case it returns nil.
+func (t *AMQP) reconnect(ctx context.Context) *amqpConn {
+	for attempt := 1; ; attempt++ {
+		delay := t.opts.Backoff.NextDelay(attempt)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		t.mu.Lock()
+		tlsConfig := t.tlsConfig
+		t.mu.Unlock()
+
+		conn, err := t.dial(tlsConfig)
+		if err != nil {
+			log.Debugf("cannot reconnect to broker, retrying: %v", err)
+			continue
+		}
+
+		t.mu.Lock()
+		if ctx.Err() != nil {
+			t.mu.Unlock()
+			conn.close()
+			return nil
+		}
+		t.conn = conn
+		t.mu.Unlock()
+
+		t.opts.Backoff.Reset()
+		log.Debugf("reconnected to broker after %v attempts", attempt)
+		return conn
+	}
+}
+
+// receive passes messages received on the links of conn to the data handler
+// until a link fails or ctx is cancelled. The failure of one link closes conn,
+// so the others stop as well.
+func (t *AMQP) receive(ctx context.Context, conn *amqpConn) error {
+	errs := make(chan error, len(conn.receivers))
+	for channel, receiver := range conn.receivers {
+		go func(channel string, receiver *amqp.Receiver) {
+			for {
+				msg, err := receiver.Receive(ctx)
+				if err != nil {
+					errs <- fmt.Errorf("cannot receive %v message: %w", channel, err)
+					return
+				}
+				if err := t.handle(msg.GetData(), channel); err != nil {
+					// Released messages are redelivered by the broker.
+					log.Errorf("cannot receive %v message: %v", channel, err)
+					if err := msg.Release(ctx); err != nil {
+						log.Errorf("cannot release %v message: %v", channel, err)
+					}
+					continue
+				}
+				if err := msg.Accept(ctx); err != nil {
+					log.Errorf("cannot accept %v message: %v", channel, err)
+				}
+			}
+		}(channel, receiver)
+	}
+
+	err := <-errs
+	conn.close()
+	for i := 1; i < len(conn.receivers); i++ {
+		<-errs
+	}
+	return err
+}
+
+// handle passes data received on channel to the data handler, counting the
+// call as in progress for Disconnect.
+func (t *AMQP) handle(data []byte, channel string) error {
+	atomic.AddInt32(&t.handling, 1)
+	defer atomic.AddInt32(&t.handling, -1)
+	return t.ReceiveData(data, channel)
+}
+
+// dial connects to the broker and attaches a sender and a receiver link for
+// each channel.
+func (t *AMQP) dial(tlsConfig *tls.Config) (*amqpConn, error) {
+	scheme := "amqp"
+	var opts []amqp.ConnOption
+	if tlsConfig != nil {
+		scheme = "amqps"
+		opts = append(opts, amqp.ConnTLSConfig(tlsConfig))
+	}
+	client, err := amqp.Dial(fmt.Sprintf("%v://%v", scheme, t.server), opts...)
+	if err != nil {
+		return nil, err
+	}
+	conn := &amqpConn{
+		client:    client,
+		senders:   make(map[string]*amqp.Sender),
+		receivers: make(map[string]*amqp.Receiver),
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		conn.close()
+		return nil, fmt.Errorf("cannot begin session: %w", err)
+	}
+	for channel, address := range t.opts.OutboundAddresses {
+		sender, err := session.NewSender(amqp.LinkTargetAddress(address))
+		if err != nil {
+			conn.close()
+			return nil, fmt.Errorf("cannot attach sender link to %v: %w", address, err)
+		}
+		conn.senders[channel] = sender
+	}
+	for channel, address := range t.opts.InboundAddresses {
+		receiver, err := session.NewReceiver(amqp.LinkSourceAddress(address))
+		if err != nil {
+			conn.close()
+			return nil, fmt.Errorf("cannot attach receiver link to %v: %w", address, err)
+		}
+		conn.receivers[channel] = receiver
+	}
+	log.Tracef("connected to broker: %v://%v", scheme, t.server)
+
+	return conn, nil
+}
+
+// close closes the connection, detaching its links.
+func (c *amqpConn) close() {
+	if err := c.client.Close(); err != nil {
+		log.Debugf("cannot close AMQP connection: %v", err)
+	}
+}
+
+// ReloadTLSConfig replaces the TLS config used to connect to the broker and
+// drops the current connection, which is then re-established using the new
+// config.
+func (t *AMQP) ReloadTLSConfig(tlsConfig *tls.Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tlsConfig = tlsConfig.Clone()
+	if t.conn != nil {
+		t.conn.close()
+	}
+	return nil
+}
+
+// Disconnect waits quiesce milliseconds, then closes the connection and stops
+// reconnecting. It waits for the receiving goroutines to stop unless a
+// message is being handled, since it may be called from the data handler,
+// which they wait for.
+func (t *AMQP) Disconnect(quiesce uint) {
+	time.Sleep(time.Millisecond * time.Duration(quiesce))
+
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	if t.conn != nil {
+		t.conn.close()
+		t.conn = nil
+	}
+	t.mu.Unlock()
+
+	if atomic.LoadInt32(&t.handling) == 0 {
+		t.wg.Wait()
+	}
+}
+
+// SendData sends data to the outbound address of the dest channel.
+func (t *AMQP) SendData(data []byte, dest string) ([]byte, error) {
+	return t.SendDataWithContext(context.Background(), data, dest)
+}
+
+// SendDataWithContext sends data like SendData, waiting until the broker
+// settles the message or ctx is done.
+func (t *AMQP) SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error) {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn == nil {
+		return nil, fmt.Errorf("cannot send data: not connected")
+	}
+	sender, ok := conn.senders[dest]
+	if !ok {
+		return nil, fmt.Errorf("cannot send data: no address for %v channel", dest)
+	}
+
+	if err := sender.Send(ctx, amqp.NewMessage(data)); err != nil {
+		return nil, fmt.Errorf("cannot send data: %w", err)
+	}
+	log.Debugf("sent message to %v channel", dest)
+	log.Tracef("message: %v", string(data))
+
+	return nil, nil
+}
+
+func (t *AMQP) ReceiveData(data []byte, dest string) error {
+	return t.dataHandler(data, dest)
+}
@@ -0,0 +1,81 @@
+//go:build go1.16 && integration
+// +build go1.16,integration
+
+package transport
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// amqpBroker returns the address of the AMQP 1.0 broker to test against,
+// skipping the test if AMQP_BROKER is not set.
+func amqpBroker(t *testing.T) string {
+	t.Helper()
+	broker, ok := os.LookupEnv("AMQP_BROKER")
+	if !ok {
+		t.Skip("AMQP_BROKER not set")
+	}
+	return broker
+}
+
+func TestAMQPIntegration(t *testing.T) {
+	// Route outbound messages straight back to the inbound links, so the
+	// broker echoes every message sent.
+	addresses := map[string]string{
+		"control": "yggdrasil-test/control",
+		"data":    "yggdrasil-test/data",
+	}
+	received := make(chan string, 2)
+	transport, err := NewAMQPTransportWithOptions("test", amqpBroker(t), nil, func(data []byte, dest string) error {
+		received <- dest + ":" + string(data)
+		return nil
+	}, AMQPOptions{
+		InboundAddresses:  addresses,
+		OutboundAddresses: addresses,
+		Backoff:           ConstantBackoff{Delay: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("received %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v not received", want)
+		}
+	}
+
+	if _, err := transport.SendData([]byte(`{"n":1}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	expect(`data:{"n":1}`)
+
+	// Reloading the TLS config drops the connection, which is then
+	// re-established.
+	if err := transport.ReloadTLSConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := transport.SendData([]byte(`{"n":2}`), "control")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cannot send after reconnecting: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	expect(`control:{"n":2}`)
+}
@@ -0,0 +1,90 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+func TestAMQPImplementsTransporter(t *testing.T) {
+	var _ Transporter = &AMQP{}
+}
+
+func TestAMQPAddresses(t *testing.T) {
+	transport, err := NewAMQPTransportWithOptions("test", "localhost", nil, func([]byte, string) error { return nil }, AMQPOptions{
+		OutboundAddresses: map[string]string{"data": "custom/data"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantInbound := map[string]string{
+		"control": yggdrasil.PathPrefix + "/test/control/in",
+		"data":    yggdrasil.PathPrefix + "/test/data/in",
+	}
+	if !cmp.Equal(transport.opts.InboundAddresses, wantInbound) {
+		t.Errorf("inbound addresses %v != %v", transport.opts.InboundAddresses, wantInbound)
+	}
+	wantOutbound := map[string]string{
+		"control": yggdrasil.PathPrefix + "/test/control/out",
+		"data":    "custom/data",
+	}
+	if !cmp.Equal(transport.opts.OutboundAddresses, wantOutbound) {
+		t.Errorf("outbound addresses %v != %v", transport.opts.OutboundAddresses, wantOutbound)
+	}
+}
+
+func TestAMQPConnectError(t *testing.T) {
+	// Find a port nothing is listening on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	transport, err := NewAMQPTransport("test", addr, nil, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err == nil {
+		transport.Disconnect(0)
+		t.Fatal("expected an error connecting to a closed port")
+	}
+	if _, err := transport.SendData([]byte("{}"), "data"); err == nil {
+		t.Error("expected an error sending while not connected")
+	}
+}
+
+func TestAMQPDisconnectFromHandler(t *testing.T) {
+	disconnected := make(chan struct{})
+	var transport *AMQP
+	transport, err := NewAMQPTransport("test", "localhost", nil, func([]byte, string) error {
+		// yggd does this on a disconnect command.
+		transport.Disconnect(0)
+		close(disconnected)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand in for a receiving goroutine, which Disconnect waits for.
+	transport.wg.Add(1)
+	go func() {
+		defer transport.wg.Done()
+		transport.handle([]byte("{}"), "control")
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("Disconnect called from the data handler did not return")
+	}
+	transport.wg.Wait()
+}
@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// randomMu guards random, which is shared by all polling goroutines.
+	randomMu sync.Mutex
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// BackoffStrategy computes how long to wait before retrying after consecutive
+// failures. A transport shares a single strategy between its polling loops
+// and outbound sends, so implementations must be safe for concurrent use.
+type BackoffStrategy interface {
+	// NextDelay returns the delay to wait after the given consecutive failed
+	// attempt, counting from 1.
+	NextDelay(attempt int) time.Duration
+
+	// Reset is called after a successful attempt.
+	Reset()
+}
+
+// ConstantBackoff waits the same delay after every failure.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+func (b ConstantBackoff) NextDelay(attempt int) time.Duration {
+	return b.Delay
+}
+
+func (b ConstantBackoff) Reset() {}
+
+// ExponentialBackoff waits Min after the first failure and multiplies the
+// delay by Multiplier after each further failure, up to Max. Unless
+// DisableJitter is set, the actual delay is drawn from [0, delay) ("full
+// jitter") so that many clients failing at once do not retry in lockstep.
+type ExponentialBackoff struct {
+	Min           time.Duration
+	Max           time.Duration
+	Multiplier    float64
+	DisableJitter bool
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	delay := float64(b.Min)
+	for i := 1; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	if b.DisableJitter {
+		return time.Duration(delay)
+	}
+	return fullJitter(time.Duration(delay))
+}
+
+func (b ExponentialBackoff) Reset() {}
+
+// fullJitter returns a random duration in [0, d).
+func fullJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return time.Duration(random.Int63n(int64(d)))
+}
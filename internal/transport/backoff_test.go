@@ -0,0 +1,264 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeClock is a clock whose After method reports each requested delay on
+// sleeps and then fires immediately.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps chan time.Duration
+	stop   chan struct{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Unix(0, 0),
+		sleeps: make(chan time.Duration),
+		stop:   make(chan struct{}),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// advance moves the clock forward by d without sleeping.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	select {
+	case c.sleeps <- d:
+		ch <- now
+	case <-c.stop:
+	}
+	return ch
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		description string
+		statuses    []int
+		want        []time.Duration
+	}{
+		{
+			description: "success polls at interval",
+			statuses:    []int{200, 200},
+			want:        []time.Duration{time.Second, time.Second},
+		},
+		{
+			description: "failures double the delay",
+			statuses:    []int{500, 500, 500},
+			want:        []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			description: "success resets the delay",
+			statuses:    []int{500, 500, 200, 500},
+			want:        []time.Duration{time.Second, 2 * time.Second, time.Second, time.Second},
+		},
+		{
+			description: "delay is capped",
+			statuses:    []int{503, 503, 503, 503, 503},
+			want:        []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				status := http.StatusOK
+				if requests < len(test.statuses) {
+					status = test.statuses[requests]
+				}
+				requests++
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+				PollingInterval: time.Second,
+				Backoff: ExponentialBackoff{
+					Min:           time.Second,
+					Max:           5 * time.Second,
+					Multiplier:    2,
+					DisableJitter: true,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := newFakeClock()
+			transport.clock = clock
+
+			ctx, cancel := context.WithCancel(context.Background())
+			transport.wg.Add(1)
+			go transport.poll(ctx, "data")
+
+			var got []time.Duration
+			for range test.want {
+				got = append(got, <-clock.sleeps)
+			}
+			cancel()
+			close(clock.stop)
+			waitGroupTimeout(t, transport, time.Second)
+
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConstantBackoff(t *testing.T) {
+	b := ConstantBackoff{Delay: 3 * time.Second}
+	for attempt := 1; attempt < 5; attempt++ {
+		if got := b.NextDelay(attempt); got != 3*time.Second {
+			t.Errorf("attempt %v: %v != %v", attempt, got, 3*time.Second)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	tests := []struct {
+		description string
+		backoff     ExponentialBackoff
+		want        []time.Duration
+	}{
+		{
+			description: "doubling",
+			backoff:     ExponentialBackoff{Min: time.Second, Max: time.Minute, Multiplier: 2, DisableJitter: true},
+			want:        []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			description: "capped",
+			backoff:     ExponentialBackoff{Min: time.Second, Max: 3 * time.Second, Multiplier: 2, DisableJitter: true},
+			want:        []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			description: "tripling",
+			backoff:     ExponentialBackoff{Min: 100 * time.Millisecond, Max: time.Minute, Multiplier: 3, DisableJitter: true},
+			want:        []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got []time.Duration
+			for attempt := 1; attempt <= len(test.want); attempt++ {
+				got = append(got, test.backoff.NextDelay(attempt))
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+
+	jittered := ExponentialBackoff{Min: time.Second, Max: time.Minute, Multiplier: 2}
+	for attempt := 1; attempt < 5; attempt++ {
+		max := ExponentialBackoff{Min: time.Second, Max: time.Minute, Multiplier: 2, DisableJitter: true}.NextDelay(attempt)
+		if got := jittered.NextDelay(attempt); got < 0 || got >= max {
+			t.Errorf("attempt %v: jittered delay %v out of range [0, %v)", attempt, got, max)
+		}
+	}
+}
+
+// resetCountingBackoff is a ConstantBackoff that counts the calls to Reset.
+type resetCountingBackoff struct {
+	ConstantBackoff
+	resets int32
+}
+
+func (b *resetCountingBackoff) Reset() {
+	atomic.AddInt32(&b.resets, 1)
+}
+
+func TestSendRetries(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	backoff := &resetCountingBackoff{ConstantBackoff: ConstantBackoff{Delay: 7 * time.Second}}
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		Backoff:         backoff,
+		SendRetries:     3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	defer close(clock.stop)
+	transport.clock = clock
+
+	got := make(chan time.Duration, 4)
+	go func() {
+		for d := range clock.sleeps {
+			got <- d
+		}
+	}()
+	if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("server received %v requests, want 3", requests)
+	}
+	if got := atomic.LoadInt32(&backoff.resets); got != 1 {
+		t.Errorf("backoff reset %v times, want once after the successful send", got)
+	}
+	for i := 0; i < 2; i++ {
+		if d := <-got; d != 7*time.Second {
+			t.Errorf("retry waited %v, want %v", d, 7*time.Second)
+		}
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := fullJitter(time.Second)
+		if d < 0 || d >= time.Second {
+			t.Fatalf("jittered delay %v out of range", d)
+		}
+	}
+	if d := fullJitter(0); d != 0 {
+		t.Errorf("jittered delay %v != 0", d)
+	}
+}
@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Redacted replaces the values masked by RedactFields.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactedFields are the JSON fields masked in logged bodies unless
+// HTTPOptions.RedactBody is set.
+var DefaultRedactedFields = []string{"password", "token", "authorization", "secret"}
+
+// A RedactFunc returns a copy of a message body that is safe to log, with
+// any secrets it contains masked. It must not modify body.
+type RedactFunc func(body []byte) []byte
+
+// RedactFields returns a RedactFunc that replaces the value of every field of
+// a JSON body, at any depth, whose name matches one of fields, ignoring case,
+// with Redacted. Bodies that are not JSON are replaced with Redacted whole,
+// since what they contain cannot be told.
+func RedactFields(fields ...string) RedactFunc {
+	names := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		names[strings.ToLower(field)] = true
+	}
+	return func(body []byte) []byte {
+		if len(body) == 0 {
+			return body
+		}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil || decoder.More() {
+			return []byte(Redacted)
+		}
+		data, err := json.Marshal(redactValue(value, names))
+		if err != nil {
+			return []byte(Redacted)
+		}
+		return data
+	}
+}
+
+// redactValue masks the fields of value named in names.
+func redactValue(value interface{}, names map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if names[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = redactValue(field, names)
+			}
+		}
+	case []interface{}:
+		for i, element := range v {
+			v[i] = redactValue(element, names)
+		}
+	}
+	return value
+}
+
+// logBody logs body, sent or received on channel as described by msg, at
+// debug level after redacting it, if LogBodies is set.
+func (t *HTTP) logBody(msg string, channel string, requestID string, body []byte) {
+	if !t.opts.LogBodies || len(body) == 0 {
+		return
+	}
+	t.opts.Logger.Debug(msg, "channel", channel, "request_id", requestID, "body", string(t.opts.RedactBody(body)))
+}
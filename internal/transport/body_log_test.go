@@ -0,0 +1,141 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactFields(t *testing.T) {
+	tests := []struct {
+		description string
+		body        string
+		want        string
+	}{
+		{
+			description: "top-level fields",
+			body:        `{"user":"alice","password":"hunter2","Token":"abc"}`,
+			want:        `{"Token":"[REDACTED]","password":"[REDACTED]","user":"alice"}`,
+		},
+		{
+			description: "nested fields",
+			body:        `{"content":{"headers":[{"Authorization":"Bearer abc"}],"n":1.50}}`,
+			want:        `{"content":{"headers":[{"Authorization":"[REDACTED]"}],"n":1.50}}`,
+		},
+		{
+			description: "object value",
+			body:        `{"secret":{"key":"value"}}`,
+			want:        `{"secret":"[REDACTED]"}`,
+		},
+		{
+			description: "no secrets",
+			body:        `["a",1,true,null]`,
+			want:        `["a",1,true,null]`,
+		},
+		{
+			description: "not JSON",
+			body:        `password=hunter2`,
+			want:        Redacted,
+		},
+		{
+			description: "trailing data",
+			body:        `{} password=hunter2`,
+			want:        Redacted,
+		},
+	}
+
+	redact := RedactFields(DefaultRedactedFields...)
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			body := []byte(test.body)
+			if got := string(redact(body)); got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+			if string(body) != test.body {
+				t.Errorf("body modified to %s", body)
+			}
+		})
+	}
+}
+
+func TestLogBodies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.Method == http.MethodPost {
+			w.Write([]byte(`{"status":"ok","token":"response-secret"}`))
+			return
+		}
+		w.Write([]byte(`{"directive":"run","password":"received-secret"}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		description string
+		logBodies   bool
+		redact      RedactFunc
+		want        []string
+	}{
+		{
+			description: "disabled",
+		},
+		{
+			description: "default redaction",
+			logBodies:   true,
+			want: []string{
+				`{"password":"[REDACTED]","user":"alice"}`,
+				`{"status":"ok","token":"[REDACTED]"}`,
+				`{"directive":"run","password":"[REDACTED]"}`,
+			},
+		},
+		{
+			description: "custom redaction",
+			logBodies:   true,
+			redact:      func([]byte) []byte { return []byte("masked") },
+			want:        []string{"masked", "masked", "masked"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logger := &captureLogger{}
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+				PollingInterval: time.Hour,
+				Logger:          logger,
+				LogBodies:       test.logBodies,
+				RedactBody:      test.redact,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := transport.SendData([]byte(`{"user":"alice","password":"sent-secret"}`), "data"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			logger.mu.Lock()
+			for _, entry := range logger.entries {
+				for _, value := range entry.fields {
+					if s, ok := value.(string); ok && strings.Contains(s, "secret") {
+						t.Errorf("secret logged in %q: %v", entry.msg, s)
+					}
+				}
+				if body, ok := entry.fields["body"]; ok {
+					got = append(got, body.(string))
+				}
+			}
+			logger.mu.Unlock()
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("logged bodies\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
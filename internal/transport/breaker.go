@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBreakerCooldown is the default time the circuit breaker stays open
+// before probing the server again.
+const DefaultBreakerCooldown = time.Minute
+
+// BreakerState is the state of the circuit breaker guarding the polls of an
+// HTTP transport.
+type BreakerState int
+
+const (
+	// BreakerClosed lets polls through. It is the state of a transport
+	// without a circuit breaker.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen pauses polling until the cooldown has elapsed.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single probing poll through, which closes the
+	// breaker if it succeeds and opens it again if it fails.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker counts consecutive failed polls across all channels and
+// pauses polling once threshold is reached. A nil *circuitBreaker never
+// opens.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	log       Logger
+
+	// mu guards the fields below.
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, logger Logger) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		log:       logger,
+	}
+}
+
+// allow reports whether a poll may be sent at now. If not, it returns how
+// long to wait before asking again: the rest of the cooldown while the
+// breaker is open, or interval while another channel is probing.
+func (b *circuitBreaker) allow(now time.Time, interval time.Duration) (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if remaining := b.openedAt.Add(b.cooldown).Sub(now); remaining > 0 {
+			return false, remaining
+		}
+		b.log.Info("circuit breaker half-open, probing server")
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true, 0
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, interval
+		}
+		b.probing = true
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+// record records the outcome of a poll allowed at now.
+func (b *circuitBreaker) record(now time.Time, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.state != BreakerClosed {
+			b.log.Info("circuit breaker closed, resuming polling")
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		b.failures++
+		if b.failures < b.threshold {
+			return
+		}
+		b.log.Warn("too many consecutive failed polls, pausing polling", "failures", b.failures, "cooldown", b.cooldown, "error", err)
+	case BreakerHalfOpen:
+		b.log.Warn("circuit breaker probe failed, pausing polling", "cooldown", b.cooldown, "error", err)
+	default:
+		return
+	}
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.probing = false
+}
+
+// reset closes the breaker.
+func (b *circuitBreaker) reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// current returns the state of the breaker.
+func (b *circuitBreaker) current() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,80 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy, requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval:  5 * time.Second,
+		Backoff:          ConstantBackoff{Delay: time.Second},
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+		Logger:           &captureLogger{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport.wg.Add(1)
+	go transport.poll(ctx, "data")
+	defer func() {
+		cancel()
+		close(clock.stop)
+		waitGroupTimeout(t, transport, time.Second)
+	}()
+
+	expectSleep := func(want time.Duration, state BreakerState) {
+		t.Helper()
+		if got := <-clock.sleeps; got != want {
+			t.Errorf("slept %v, want %v", got, want)
+		}
+		if got := transport.State().Breaker; got != state {
+			t.Errorf("breaker %v, want %v", got, state)
+		}
+	}
+
+	// Two failures open the breaker, which then waits out the cooldown
+	// instead of polling.
+	expectSleep(time.Second, BreakerClosed)
+	expectSleep(time.Second, BreakerOpen)
+	expectSleep(59*time.Second, BreakerOpen)
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("%v requests sent while the breaker was open, want 2", got)
+	}
+
+	// A failed probe opens it again.
+	expectSleep(time.Second, BreakerOpen)
+	expectSleep(59*time.Second, BreakerOpen)
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Errorf("%v requests sent, want a single probe", got)
+	}
+
+	// A successful probe closes it and polling resumes.
+	atomic.StoreInt32(&healthy, 1)
+	expectSleep(5*time.Second, BreakerClosed)
+	expectSleep(5*time.Second, BreakerClosed)
+}
@@ -0,0 +1,174 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/rjeczalik/notify"
+)
+
+// DefaultCABundlePollInterval is the default interval at which a
+// CABundleWatcher checks its bundle for changes, in addition to reacting to
+// file system events.
+const DefaultCABundlePollInterval = time.Minute
+
+// LoadCABundle reads a PEM-encoded CA bundle from path. It fails unless the
+// file contains at least one certificate and nothing but complete PEM blocks,
+// so a partially written bundle is rejected rather than loaded truncated.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+	}
+	return parseCABundle(data)
+}
+
+func parseCABundle(data []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	count := 0
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CA bundle certificate: %w", err)
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("cannot parse CA bundle: trailing data")
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("cannot parse CA bundle: no certificates")
+	}
+	return pool, nil
+}
+
+// CABundleWatcher reloads the root CAs of a transport when a CA bundle file
+// changes. Changes are picked up from file system events when available, and
+// by polling the file otherwise. A bundle that fails to parse is ignored
+// until it is rewritten, so the transport keeps its previous CAs.
+type CABundleWatcher struct {
+	path        string
+	base        *tls.Config
+	transporter Transporter
+	interval    time.Duration
+
+	sum    [sha256.Size]byte
+	events chan notify.EventInfo
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// WatchCABundle starts watching the CA bundle at path, checking it at least
+// every interval, or DefaultCABundlePollInterval if interval is zero. On
+// each change, it calls transporter.ReloadTLSConfig with a clone of base in
+// which RootCAs is replaced by the new bundle. The bundle at path must be
+// valid when the watcher starts; it is expected to be in use already.
+func WatchCABundle(path string, base *tls.Config, transporter Transporter, interval time.Duration) (*CABundleWatcher, error) {
+	if interval == 0 {
+		interval = DefaultCABundlePollInterval
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+	}
+	if _, err := parseCABundle(data); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &CABundleWatcher{
+		path:        path,
+		base:        base,
+		transporter: transporter,
+		interval:    interval,
+		sum:         sha256.Sum256(data),
+		events:      make(chan notify.EventInfo, 1),
+		cancel:      cancel,
+	}
+
+	// Watch the directory rather than the file, so that bundles replaced by
+	// renaming a new file over the old one are noticed.
+	if err := notify.Watch(filepath.Dir(path), w.events, notify.InCloseWrite, notify.InMovedTo); err != nil {
+		log.Warnf("cannot watch CA bundle %v, polling for changes: %v", path, err)
+	}
+
+	w.wg.Add(1)
+	go w.run(ctx)
+
+	return w, nil
+}
+
+// Stop stops watching the CA bundle.
+func (w *CABundleWatcher) Stop() {
+	notify.Stop(w.events)
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *CABundleWatcher) run(ctx context.Context) {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.events:
+			if filepath.Base(e.Path()) != filepath.Base(w.path) {
+				continue
+			}
+		case <-time.After(w.interval):
+		}
+		w.check()
+	}
+}
+
+// check reloads the transport's root CAs if the bundle has changed and is
+// valid.
+func (w *CABundleWatcher) check() {
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		log.Errorf("cannot read CA bundle: %v", err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	if sum == w.sum {
+		return
+	}
+	pool, err := parseCABundle(data)
+	if err != nil {
+		log.Errorf("ignoring invalid CA bundle %v: %v", w.path, err)
+		return
+	}
+
+	config := &tls.Config{}
+	if w.base != nil {
+		config = w.base.Clone()
+	}
+	config.RootCAs = pool
+	if err := w.transporter.ReloadTLSConfig(config); err != nil {
+		log.Errorf("cannot reload TLS config: %v", err)
+		return
+	}
+	w.sum = sum
+	log.Infof("reloaded CA bundle %v", w.path)
+}
@@ -0,0 +1,123 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCA creates a self-signed CA certificate and returns it with its PEM
+// encoding.
+func newCA(t *testing.T, commonName string) (*x509.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseCABundle(t *testing.T) {
+	_, caPEM := newCA(t, "test CA")
+
+	tests := []struct {
+		description string
+		input       []byte
+		wantErr     bool
+	}{
+		{description: "valid", input: caPEM},
+		{description: "two certificates", input: append(append([]byte{}, caPEM...), caPEM...)},
+		{description: "empty", input: nil, wantErr: true},
+		{description: "truncated", input: caPEM[:len(caPEM)/2], wantErr: true},
+		{description: "truncated second certificate", input: append(append([]byte{}, caPEM...), caPEM[:40]...), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := parseCABundle(test.input)
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWatchCABundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.pem")
+
+	_, oldPEM := newCA(t, "old CA")
+	newCert, newPEM := newCA(t, "new CA")
+	if err := ioutil.WriteFile(path, oldPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &stubTransport{tlsConfigs: make(chan *tls.Config, 1)}
+	base := &tls.Config{ServerName: "example.com"}
+	watcher, err := WatchCABundle(path, base, stub, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+
+	// A partially written bundle must not be loaded.
+	if err := ioutil.WriteFile(path, newPEM[:len(newPEM)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stub.tlsConfigs:
+		t.Fatal("reloaded a partially written bundle")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := ioutil.WriteFile(path, newPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case config := <-stub.tlsConfigs:
+		want := x509.NewCertPool()
+		want.AddCert(newCert)
+		if !config.RootCAs.Equal(want) {
+			t.Error("reloaded config does not contain the new CA")
+		}
+		if config.ServerName != "example.com" {
+			t.Error("reloaded config does not keep the base settings")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CA bundle not reloaded")
+	}
+}
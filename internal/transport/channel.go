@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Channel names a logical channel that messages are exchanged on, and is
+// passed as the dest of SendData and ReceiveData. Every transport has the
+// control and data channels; an HTTP transport may be configured with more.
+type Channel string
+
+const (
+	// ChannelControl carries control messages between the client and the
+	// server.
+	ChannelControl Channel = "control"
+
+	// ChannelData carries data messages between workers and the server.
+	ChannelData Channel = "data"
+)
+
+// ErrUnknownChannel is returned when a message is sent to, or received on, a
+// channel the transport is not configured with.
+var ErrUnknownChannel = errors.New("unknown channel")
+
+// newChannels returns the control and data channels followed by extra,
+// checking that each of extra is a distinct, non-empty path segment other than
+// "." and "..", which would resolve to a different path.
+func newChannels(extra []Channel) ([]Channel, error) {
+	channels := []Channel{ChannelControl, ChannelData}
+	for _, channel := range extra {
+		if channel == "" || channel == "." || channel == ".." || url.PathEscape(string(channel)) != string(channel) {
+			return nil, fmt.Errorf("cannot use channel %q: not a valid URL path segment", channel)
+		}
+		for _, c := range channels {
+			if c == channel {
+				return nil, fmt.Errorf("cannot use channel %q: duplicate channel", channel)
+			}
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// checkChannel returns an error matching ErrUnknownChannel unless dest is one
+// of the channels of the transport.
+func (t *HTTP) checkChannel(dest string) error {
+	for _, channel := range t.channels {
+		if string(channel) == dest {
+			return nil
+		}
+	}
+	return permanentError(fmt.Errorf("%w: %q", ErrUnknownChannel, dest))
+}
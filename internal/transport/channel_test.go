@@ -0,0 +1,100 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCustomChannel(t *testing.T) {
+	paths := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/events/") {
+			paths <- req.Method + " " + req.URL.Path
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	received := make(chan string, 1)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+		received <- dest
+		return nil
+	}, HTTPOptions{
+		PollingInterval: time.Hour,
+		PathPrefix:      "yggdrasil",
+		Channels:        []Channel{"events"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transport.SendData([]byte("{}"), "events"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-paths, "POST /yggdrasil/events/test/out"; got != want {
+		t.Errorf("sent to %v, want %v", got, want)
+	}
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer waitGroupTimeout(t, transport, time.Second)
+	defer transport.Disconnect(0)
+	select {
+	case got := <-paths:
+		if want := "GET /yggdrasil/events/test/in"; got != want {
+			t.Errorf("polled %v, want %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("custom channel not polled")
+	}
+
+	if err := transport.ReceiveData([]byte("{}"), "events"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "events" {
+		t.Errorf("received on %v, want events", got)
+	}
+}
+
+func TestUnknownChannel(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+		t.Error("data handler called for an unknown channel")
+		return nil
+	}, HTTPOptions{PollingInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.SendData([]byte("{}"), "../data")
+	if !errors.Is(err, ErrUnknownChannel) || !errors.Is(err, ErrPermanentTransport) {
+		t.Errorf("send error %v, want a permanent ErrUnknownChannel", err)
+	}
+	if requests != 0 {
+		t.Errorf("%v requests sent to an unknown channel", requests)
+	}
+	if err := transport.ReceiveData([]byte("{}"), "events"); !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("receive error %v, want ErrUnknownChannel", err)
+	}
+}
+
+func TestInvalidChannels(t *testing.T) {
+	for _, channels := range [][]Channel{{""}, {"."}, {".."}, {"a/b"}, {"data"}, {"events", "events"}} {
+		if _, err := NewHTTPTransportWithOptions("test", "localhost:8080", nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{Channels: channels}); err == nil {
+			t.Errorf("expected an error for channels %q", channels)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// checkClientID returns an error unless id is a non-empty path segment made
+// only of the unreserved URL characters: letters, digits, '-', '.', '_' and
+// '~'. The client ID is embedded in request paths, where anything else could
+// add path segments or need escaping the server may not expect.
+func checkClientID(id string) error {
+	if id == "" {
+		return fmt.Errorf("cannot use client ID: empty")
+	}
+	if id == "." || id == ".." {
+		return fmt.Errorf("cannot use client ID %q: not a valid URL path segment", id)
+	}
+	for _, r := range id {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '-', r == '.', r == '_', r == '~':
+		default:
+			return fmt.Errorf("cannot use client ID %q: invalid character %q", id, r)
+		}
+	}
+	return nil
+}
+
+// ForClient creates a transport that sends and receives on behalf of
+// clientID, calling dataRecvFunc with its inbound messages, for a gateway
+// relaying the messages of several devices. The new transport has the server
+// and options of t, and shares its HTTP clients, and with them their TLS
+// configuration and connection pools: reloading the TLS configuration of
+// either transport reloads it for both. Everything else, including polling,
+// backoff, signing and encryption keys, is its own, and it is connected,
+// disconnected and closed separately. If QueueDir is set, its outbound
+// messages are queued in a subdirectory named after clientID.
+func (t *HTTP) ForClient(clientID string, dataRecvFunc DataReceiveHandlerFunc) (*HTTP, error) {
+	opts := t.opts
+	if opts.QueueDir != "" {
+		if err := checkClientID(clientID); err != nil {
+			return nil, err
+		}
+		opts.QueueDir = filepath.Join(opts.QueueDir, clientID)
+	}
+	c, err := NewHTTPTransportWithOptions(clientID, joinServer(t.host, t.port), nil, t.userAgent, dataRecvFunc, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.client = t.client
+	c.writeClient = t.writeClient
+	c.isTLS = t.isTLS
+	c.tlsConfigured = t.tlsConfigured
+	c.clock = t.clock
+	return c, nil
+}
+
+// ClientID returns the client ID the transport sends and receives on behalf
+// of.
+func (t *HTTP) ClientID() string {
+	return t.clientID
+}
@@ -0,0 +1,117 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestForClient(t *testing.T) {
+	// The server echoes the path of each poll back as the message, and
+	// records the path and body of each message sent.
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(req.Body)
+			mu.Lock()
+			sent = append(sent, req.URL.Path+" "+string(body))
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(req.URL.Path))
+	}))
+	defer srv.Close()
+
+	received := map[string][]string{}
+	handler := func(clientID string) DataReceiveHandlerFunc {
+		return func(data []byte, dest string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received[clientID] = append(received[clientID], dest+" "+string(data))
+			return nil
+		}
+	}
+	first, err := NewHTTPTransportWithOptions("first", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", handler("first"), HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := first.ForClient("second", handler("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ClientID() != "second" {
+		t.Errorf("got client ID %v, want second", second.ClientID())
+	}
+	if second.client != first.client {
+		t.Error("HTTP client not shared")
+	}
+
+	for _, transport := range []*HTTP{first, second} {
+		if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transport.SendData([]byte(transport.ClientID()), "control"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantReceived := map[string][]string{
+		"first":  {"data /yggdrasil/data/first/in"},
+		"second": {"data /yggdrasil/data/second/in"},
+	}
+	if !cmp.Equal(received, wantReceived) {
+		t.Errorf("received %v, want %v", received, wantReceived)
+	}
+	wantSent := []string{
+		"/yggdrasil/control/first/out first",
+		"/yggdrasil/control/second/out second",
+	}
+	if !cmp.Equal(sent, wantSent) {
+		t.Errorf("sent %v, want %v", sent, wantSent)
+	}
+
+	// Switching to TLS applies to the shared client, and so to both.
+	if err := first.ReloadTLSConfig(&tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := second.getUrl("in", "data"); !strings.HasPrefix(got, "https://") {
+		t.Errorf("got URL %v after reloading the TLS config, want https", got)
+	}
+}
+
+func TestForClientInvalidID(t *testing.T) {
+	transport, err := NewHTTPTransportWithOptions("first", "localhost", nil, "testUA", nil, HTTPOptions{QueueDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", "..", "a/b"} {
+		if _, err := transport.ForClient(id, nil); err == nil {
+			t.Errorf("ForClient(%q) succeeded, want an error", id)
+		}
+	}
+}
+
+func TestJoinServer(t *testing.T) {
+	for _, server := range []string{"localhost", "localhost:8080", "127.0.0.1", "::1", "[::1]:8080"} {
+		host, port, err := splitServer(server, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotHost, gotPort, err := splitServer(joinServer(host, port), false)
+		if err != nil || gotHost != host || gotPort != port {
+			t.Errorf("%v round-tripped to %v, %v, %v", server, gotHost, gotPort, err)
+		}
+	}
+}
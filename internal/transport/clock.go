@@ -0,0 +1,21 @@
+package transport
+
+import "time"
+
+// clock abstracts the passage of time so that polling schedules can be
+// exercised in tests without actually sleeping.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is a clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"net/http"
+	"time"
+)
+
+// checkDate learns the offset of the server's clock from the local clock from
+// the Date header of res, if MaxClockSkew is set.
+func (t *HTTP) checkDate(res *http.Response) {
+	if t.opts.MaxClockSkew <= 0 {
+		return
+	}
+	date, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	// The date is truncated to the second, so the server's clock was on
+	// average half a second past it.
+	offset := date.Add(500 * time.Millisecond).Sub(t.clock.Now())
+	if offset > t.opts.MaxClockSkew {
+		offset = t.opts.MaxClockSkew
+	} else if offset < -t.opts.MaxClockSkew {
+		offset = -t.opts.MaxClockSkew
+	}
+
+	t.clockOffsetMu.Lock()
+	defer t.clockOffsetMu.Unlock()
+	if d := offset - t.clockOffset; d >= time.Second || d <= -time.Second {
+		t.opts.Logger.Debug("adjusting for server clock offset", "offset", offset)
+	}
+	t.clockOffset = offset
+}
+
+// serverNow returns the current time by the server's clock, as far as it has
+// been learned, for signature timestamps.
+func (t *HTTP) serverNow() time.Time {
+	t.clockOffsetMu.Lock()
+	defer t.clockOffsetMu.Unlock()
+	return t.clock.Now().Add(t.clockOffset)
+}
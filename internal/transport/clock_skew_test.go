@@ -0,0 +1,99 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClockSkew(t *testing.T) {
+	public, private := newSigningKey(t)
+	message := []byte(`{}`)
+	// The server's clock is an hour ahead, and it signs its messages by it.
+	const skew = time.Hour
+
+	tests := []struct {
+		description  string
+		maxClockSkew time.Duration
+		want         bool
+	}{
+		{description: "disabled"},
+		{description: "corrected", maxClockSkew: 2 * time.Hour, want: true},
+		{description: "beyond bound", maxClockSkew: 10 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			var timestamp string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				now := time.Now().Add(skew)
+				w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+				if req.Method == http.MethodPost {
+					mu.Lock()
+					timestamp = req.Header.Get(SignatureTimestampHeader)
+					mu.Unlock()
+					return
+				}
+				copyHeader(w.Header(), signedHeader(t, private, message, now))
+				w.Write(message)
+			}))
+			defer srv.Close()
+
+			received := make(chan []byte, 1)
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+				received <- data
+				return nil
+			}, HTTPOptions{
+				SigningKey:   private,
+				VerifyKeys:   []ed25519.PublicKey{public},
+				MaxClockSkew: test.maxClockSkew,
+				Logger:       &captureLogger{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The offset is learned from the response carrying the
+			// message, before it is checked.
+			if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+				t.Fatal(err)
+			}
+			var got bool
+			select {
+			case <-received:
+				got = true
+			default:
+			}
+			if got != test.want {
+				t.Errorf("accepted %v, want %v", got, test.want)
+			}
+
+			if _, err := transport.SendData(message, "data"); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset := time.Unix(signedAt, 0).Sub(time.Now())
+			want := skew
+			if test.maxClockSkew < want {
+				want = test.maxClockSkew
+			}
+			if offset < want-2*time.Second || offset > want+2*time.Second {
+				t.Errorf("signed %v from now, want %v", offset, want)
+			}
+		})
+	}
+}
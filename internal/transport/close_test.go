@@ -0,0 +1,72 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	var mu sync.Mutex
+	states := make(map[net.Conn]http.ConnState)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		states[conn] = state
+	}
+	srv.Start()
+	defer srv.Close()
+	count := func(state http.ConnState) int {
+		mu.Lock()
+		defer mu.Unlock()
+		var n int
+		for _, s := range states {
+			if s == state {
+				n++
+			}
+		}
+		return n
+	}
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, time.Second, func() bool { return count(http.StateIdle) > 0 })
+
+	if err := transport.Close(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, time.Second, func() bool {
+		return count(http.StateIdle) == 0 && count(http.StateActive) == 0
+	})
+
+	if err := transport.Connect(); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("got %v connecting after Close, want ErrTransportClosed", err)
+	}
+	if err := transport.Reconnect(); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("got %v reconnecting after Close, want ErrTransportClosed", err)
+	}
+	if _, err := transport.SendData([]byte(`{}`), "data"); !errors.Is(err, ErrTransportDisconnected) {
+		t.Errorf("got %v sending after Close, want ErrTransportDisconnected", err)
+	}
+}
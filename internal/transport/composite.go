@@ -0,0 +1,303 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+)
+
+// DefaultMaxFailures is the default number of consecutive failed sends after
+// which a CompositeTransport fails over to the next transport.
+const DefaultMaxFailures = 3
+
+// DefaultProbeInterval is the default interval at which a CompositeTransport
+// tries to fail back to a preferred transport.
+const DefaultProbeInterval = time.Minute
+
+// CompositeTransport is a Transporter that wraps an ordered list of
+// transports, most preferred first. It sends through a single active
+// transport, failing over to the next one that connects when the active
+// transport cannot connect or fails repeatedly, and periodically tries to
+// fail back to a more preferred transport. Inbound messages are received by
+// whichever transport is connected.
+type CompositeTransport struct {
+	transports    []Transporter
+	maxFailures   int
+	probeInterval time.Duration
+
+	// switchMu serializes connecting transports to switch to, which is
+	// done without holding mu.
+	switchMu sync.Mutex
+
+	// mu guards the fields below.
+	mu       sync.Mutex
+	active   int
+	failures int
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	// switching is set while a failover is under way, so that failing
+	// sends do not start another.
+	switching bool
+}
+
+// NewCompositeTransport creates a transport that fails over between
+// transports, in order of preference. The active transport is replaced after
+// maxFailures consecutive failed sends, and more preferred transports are
+// probed every probeInterval. Zero values select DefaultMaxFailures and
+// DefaultProbeInterval.
+func NewCompositeTransport(transports []Transporter, maxFailures int, probeInterval time.Duration) (*CompositeTransport, error) {
+	if len(transports) == 0 {
+		return nil, fmt.Errorf("cannot create composite transport: no transports")
+	}
+	if maxFailures == 0 {
+		maxFailures = DefaultMaxFailures
+	}
+	if probeInterval == 0 {
+		probeInterval = DefaultProbeInterval
+	}
+	return &CompositeTransport{
+		transports:    transports,
+		maxFailures:   maxFailures,
+		probeInterval: probeInterval,
+	}, nil
+}
+
+// Active returns the index of the transport currently used to send messages.
+func (t *CompositeTransport) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// Connect connects the most preferred transport that can connect and makes
+// it active, disconnecting the transport that was active before, if another
+// one connects.
+func (t *CompositeTransport) Connect() error {
+	t.switchMu.Lock()
+	defer t.switchMu.Unlock()
+
+	t.mu.Lock()
+	connected := t.cancel != nil
+	if connected {
+		t.cancel()
+		t.cancel = nil
+	}
+	previous := t.active
+	t.mu.Unlock()
+
+	order := make([]int, len(t.transports))
+	for i := range order {
+		order[i] = i
+	}
+	i, err := t.connectFirst(order)
+	if err != nil {
+		return fmt.Errorf("cannot connect any transport: %w", err)
+	}
+	if connected && previous != i {
+		t.transports[previous].Disconnect(0)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.setActive(ctx, i)
+	return nil
+}
+
+// connectFirst connects the first of the transports in order that can
+// connect and returns its index. Transports that fail to connect are
+// disconnected, as some keep part of their work running however Connect
+// fails. t.switchMu must be held, but not t.mu, since connecting may take
+// as long as dialing the server.
+func (t *CompositeTransport) connectFirst(order []int) (int, error) {
+	var err error
+	for _, i := range order {
+		if err = t.transports[i].Connect(); err != nil {
+			log.Debugf("cannot connect transport %v: %v", i, err)
+			t.transports[i].Disconnect(0)
+			continue
+		}
+		return i, nil
+	}
+	return 0, err
+}
+
+// setActive makes transport i active and resets the failure count, starting
+// a fail-back probe if i is not the most preferred transport. t.mu must be
+// held.
+func (t *CompositeTransport) setActive(ctx context.Context, i int) {
+	if i != t.active {
+		log.Infof("switching from transport %v to transport %v", t.active, i)
+	}
+	t.active = i
+	t.failures = 0
+	if i > 0 {
+		t.wg.Add(1)
+		go t.probe(ctx, i)
+	}
+}
+
+// failover connects the next transport after failed, in order of preference
+// and wrapping around, that can connect, and makes it active in place of
+// failed, which it disconnects. It gives up if ctx is cancelled or another
+// switch has happened meanwhile. Neither t.mu nor t.switchMu may be held.
+func (t *CompositeTransport) failover(ctx context.Context, failed int) {
+	t.switchMu.Lock()
+	defer t.switchMu.Unlock()
+
+	order := make([]int, 0, len(t.transports)-1)
+	for n := 1; n < len(t.transports); n++ {
+		order = append(order, (failed+n)%len(t.transports))
+	}
+	i, err := t.connectFirst(order)
+
+	t.mu.Lock()
+	t.switching = false
+	if err != nil || len(order) == 0 {
+		log.Errorf("cannot fail over from transport %v: no other transport connected", failed)
+		t.failures = 0
+		t.mu.Unlock()
+		return
+	}
+	if ctx.Err() != nil || t.active != failed {
+		t.mu.Unlock()
+		t.transports[i].Disconnect(0)
+		return
+	}
+	t.setActive(ctx, i)
+	t.mu.Unlock()
+	t.transports[failed].Disconnect(0)
+}
+
+// probe periodically tries to connect each transport more preferred than
+// active, switching to the first one that connects. It returns once it has
+// switched, or when ctx is cancelled or another switch has happened.
+func (t *CompositeTransport) probe(ctx context.Context, active int) {
+	defer t.wg.Done()
+
+	order := make([]int, active)
+	for i := range order {
+		order[i] = i
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t.probeInterval):
+		}
+
+		if t.failBack(ctx, active, order) {
+			return
+		}
+	}
+}
+
+// failBack tries to connect the transports in order and make the first one
+// that connects active in place of active. It reports whether probing is
+// over: because it has switched, or because ctx is cancelled or another
+// switch has happened.
+func (t *CompositeTransport) failBack(ctx context.Context, active int, order []int) bool {
+	t.switchMu.Lock()
+	defer t.switchMu.Unlock()
+	if ctx.Err() != nil {
+		return true
+	}
+
+	i, err := t.connectFirst(order)
+	if err != nil {
+		log.Debugf("cannot fail back from transport %v: %v", active, err)
+		return false
+	}
+
+	t.mu.Lock()
+	if ctx.Err() != nil || t.active != active {
+		t.mu.Unlock()
+		t.transports[i].Disconnect(0)
+		return true
+	}
+	t.setActive(ctx, i)
+	t.mu.Unlock()
+	t.transports[active].Disconnect(0)
+	return true
+}
+
+// Disconnect stops probing and disconnects the active transport.
+func (t *CompositeTransport) Disconnect(quiesce uint) {
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	active := t.transports[t.active]
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	active.Disconnect(quiesce)
+}
+
+// SendData sends data through the active transport. After maxFailures
+// consecutive failures, the transport fails over.
+func (t *CompositeTransport) SendData(data []byte, dest string) ([]byte, error) {
+	return t.SendDataWithContext(context.Background(), data, dest)
+}
+
+// SendDataWithContext sends data through the active transport like SendData,
+// passing ctx on to it.
+func (t *CompositeTransport) SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error) {
+	t.mu.Lock()
+	i := t.active
+	t.mu.Unlock()
+
+	res, err := t.transports[i].SendDataWithContext(ctx, data, dest)
+
+	t.mu.Lock()
+	// A send abandoned by the caller says nothing about the transport.
+	if i != t.active || ctx.Err() != nil {
+		t.mu.Unlock()
+		return res, err
+	}
+	if err == nil {
+		t.failures = 0
+		t.mu.Unlock()
+		return res, nil
+	}
+	t.failures++
+	if t.failures < t.maxFailures || t.cancel == nil || t.switching {
+		t.mu.Unlock()
+		return res, err
+	}
+	log.Warnf("transport %v failed %v consecutive times, failing over", i, t.failures)
+	t.switching = true
+	t.cancel()
+	probeCtx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.mu.Unlock()
+
+	t.failover(probeCtx, i)
+	return res, err
+}
+
+// ReceiveData passes data to the active transport.
+func (t *CompositeTransport) ReceiveData(data []byte, dest string) error {
+	t.mu.Lock()
+	active := t.transports[t.active]
+	t.mu.Unlock()
+
+	return active.ReceiveData(data, dest)
+}
+
+// ReloadTLSConfig reloads the TLS config of every transport.
+func (t *CompositeTransport) ReloadTLSConfig(tlsConfig *tls.Config) error {
+	for i, transport := range t.transports {
+		if err := transport.ReloadTLSConfig(tlsConfig); err != nil {
+			return fmt.Errorf("cannot reload TLS config of transport %v: %w", i, err)
+		}
+	}
+	return nil
+}
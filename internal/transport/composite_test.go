@@ -0,0 +1,281 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubTransport is a Transporter whose Connect and SendData results can be
+// changed while it is in use. If tlsConfigs is set, reloaded TLS configs are
+// sent to it. If connecting is set, Connect blocks until it is closed.
+type stubTransport struct {
+	tlsConfigs chan *tls.Config
+	connecting chan struct{}
+
+	mu          sync.Mutex
+	connectErr  error
+	sendErr     error
+	connected   bool
+	disconnects int
+	sent        int
+}
+
+func (s *stubTransport) set(connectErr, sendErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connectErr = connectErr
+	s.sendErr = sendErr
+}
+
+func (s *stubTransport) isConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+func (s *stubTransport) disconnectCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disconnects
+}
+
+func (s *stubTransport) Connect() error {
+	if s.connecting != nil {
+		<-s.connecting
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connectErr != nil {
+		return s.connectErr
+	}
+	s.connected = true
+	return nil
+}
+
+func (s *stubTransport) Disconnect(quiesce uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	s.disconnects++
+}
+
+func (s *stubTransport) SendData(data []byte, dest string) ([]byte, error) {
+	return s.SendDataWithContext(context.Background(), data, dest)
+}
+
+func (s *stubTransport) SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent++
+	return nil, s.sendErr
+}
+
+func (s *stubTransport) ReceiveData(data []byte, dest string) error {
+	return nil
+}
+
+func (s *stubTransport) ReloadTLSConfig(tlsConfig *tls.Config) error {
+	if s.tlsConfigs != nil {
+		s.tlsConfigs <- tlsConfig
+	}
+	return nil
+}
+
+func TestCompositeConnectSkipsFailingTransport(t *testing.T) {
+	primary := &stubTransport{connectErr: errors.New("unreachable")}
+	secondary := &stubTransport{}
+
+	transport, err := NewCompositeTransport([]Transporter{primary, secondary}, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	if got := transport.Active(); got != 1 {
+		t.Errorf("active transport %v, want 1", got)
+	}
+	if !secondary.isConnected() {
+		t.Error("secondary transport not connected")
+	}
+	if primary.disconnectCount() != 1 {
+		t.Error("transport that failed to connect not disconnected")
+	}
+}
+
+func TestCompositeReconnect(t *testing.T) {
+	primary := &stubTransport{connectErr: errors.New("unreachable")}
+	secondary := &stubTransport{}
+
+	transport, err := NewCompositeTransport([]Transporter{primary, secondary}, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	primary.set(nil, nil)
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if got := transport.Active(); got != 0 {
+		t.Errorf("active transport %v, want 0", got)
+	}
+	if secondary.isConnected() {
+		t.Error("previously active transport still connected")
+	}
+}
+
+func TestCompositeConnectDoesNotBlock(t *testing.T) {
+	primary := &stubTransport{connecting: make(chan struct{})}
+
+	transport, err := NewCompositeTransport([]Transporter{primary, &stubTransport{}}, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan error)
+	go func() { connected <- transport.Connect() }()
+	defer transport.Disconnect(0)
+
+	// Sends and receives go on while a transport is connecting.
+	done := make(chan struct{})
+	go func() {
+		transport.Active()
+		transport.SendData([]byte("{}"), "data")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("send blocked while connecting")
+	}
+
+	close(primary.connecting)
+	if err := <-connected; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompositeConnectSkipsFailingHTTPTransport(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	primary, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary := &stubTransport{}
+
+	transport, err := NewCompositeTransport([]Transporter{primary, secondary}, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	if got := transport.Active(); got != 1 {
+		t.Errorf("active transport %v, want 1", got)
+	}
+	if primary.State().Connected {
+		t.Error("HTTP transport connected after its probe failed")
+	}
+	// Only the probe reaches the server: the HTTP transport does not poll.
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&polls); got != 1 {
+		t.Errorf("server polled %v times, want 1", got)
+	}
+	waitGroupTimeout(t, primary, time.Second)
+}
+
+func TestCompositeConnectFails(t *testing.T) {
+	transport, err := NewCompositeTransport([]Transporter{
+		&stubTransport{connectErr: errors.New("unreachable")},
+		&stubTransport{connectErr: errors.New("unreachable")},
+	}, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err == nil {
+		t.Error("expected an error when no transport connects")
+	}
+}
+
+func TestCompositeFailoverAndFailback(t *testing.T) {
+	primary := &stubTransport{}
+	secondary := &stubTransport{}
+
+	transport, err := NewCompositeTransport([]Transporter{primary, secondary}, 2, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	// Break the primary; it must not be probed back in until it recovers.
+	primary.set(errors.New("unreachable"), errors.New("send failed"))
+
+	if _, err := transport.SendData([]byte("{}"), "data"); err == nil {
+		t.Fatal("expected the first send to fail")
+	}
+	if got := transport.Active(); got != 0 {
+		t.Fatalf("failed over after a single failure")
+	}
+	if _, err := transport.SendData([]byte("{}"), "data"); err == nil {
+		t.Fatal("expected the second send to fail")
+	}
+	if got := transport.Active(); got != 1 {
+		t.Fatalf("active transport %v after repeated failures, want 1", got)
+	}
+	if primary.isConnected() {
+		t.Error("failed transport still connected")
+	}
+
+	if _, err := transport.SendData([]byte("{}"), "data"); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.sent != 1 {
+		t.Errorf("secondary transport sent %v messages, want 1", secondary.sent)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := transport.Active(); got != 1 {
+		t.Fatalf("failed back to a transport that cannot connect")
+	}
+
+	primary.set(nil, nil)
+	eventually(t, time.Second, func() bool { return transport.Active() == 0 })
+	if secondary.isConnected() {
+		t.Error("secondary transport still connected after fail-back")
+	}
+}
+
+func TestNewCompositeTransportEmpty(t *testing.T) {
+	if _, err := NewCompositeTransport(nil, 0, 0); err == nil {
+		t.Error("expected an error for an empty transport list")
+	}
+}
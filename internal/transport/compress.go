@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// DefaultCompressThreshold is the default size, in bytes, above which
+// outbound request bodies are gzip-compressed when compression is enabled.
+const DefaultCompressThreshold = 1024
+
+// DefaultMaxResponseSize is the default maximum size, in bytes, of a response
+// body read by the HTTP transport.
+const DefaultMaxResponseSize = 4 << 20
+
+// ErrResponseTooLarge is returned, wrapped, when a response body exceeds the
+// maximum size allowed by HTTPOptions.MaxResponseSize.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ErrTruncatedBody is returned, wrapped, when the connection ends or is reset
+// before a response body has been read in full.
+var ErrTruncatedBody = errors.New("response body truncated")
+
+// bodyReadError classifies err, returned reading a response body, as
+// transient, wrapping ErrTruncatedBody if the body was cut short.
+func bodyReadError(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		err = fmt.Errorf("%w: %v", ErrTruncatedBody, err)
+	}
+	return transientError(err)
+}
+
+// gzipBytes returns data compressed with gzip.
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodedBody returns a reader over the body of res, decompressing it if the
+// server sent it gzip-encoded unprompted.
+func decodedBody(res *http.Response) (io.ReadCloser, error) {
+	if res.Uncompressed || !strings.EqualFold(strings.TrimSpace(res.Header.Get("Content-Encoding")), "gzip") {
+		return ioutil.NopCloser(res.Body), nil
+	}
+	gr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return nil, transientError(fmt.Errorf("cannot decompress response body: %w", err))
+	}
+	return gr, nil
+}
+
+// readBody reads the body of res, decompressing it if the server sent it
+// gzip-encoded. The standard transport already decompresses responses that it
+// requested compressed itself; this covers servers that compress responses
+// unprompted. Reading stops with a permanent error wrapping
+// ErrResponseTooLarge once more than limit bytes have been decoded; other
+// failures are transient, and wrap ErrTruncatedBody if the body was cut
+// short. Partially read bodies are never returned.
+func readBody(res *http.Response, limit int64) ([]byte, error) {
+	r, err := decodedBody(res)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, bodyReadError(err)
+	}
+	if int64(len(data)) > limit {
+		return nil, permanentError(fmt.Errorf("%w: more than %v bytes", ErrResponseTooLarge, limit))
+	}
+	return data, nil
+}
@@ -0,0 +1,153 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadBody(t *testing.T) {
+	compressed, err := gzipBytes([]byte(`{"status":"OK"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		encoding    string
+		body        []byte
+		want        string
+		err         bool
+	}{
+		{description: "identity", encoding: "", body: []byte(`{"status":"OK"}`), want: `{"status":"OK"}`},
+		{description: "gzip", encoding: "gzip", body: compressed, want: `{"status":"OK"}`},
+		{description: "corrupt gzip", encoding: "gzip", body: []byte("not gzip"), err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			res := &http.Response{
+				Header: http.Header{"Content-Encoding": []string{test.encoding}},
+				Body:   ioutil.NopCloser(bytes.NewReader(test.body)),
+			}
+			got, err := readBody(res, DefaultMaxResponseSize)
+			if test.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("%s != %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReceiveGzipResponse(t *testing.T) {
+	compressed, err := gzipBytes([]byte(`{"status":"OK"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(compressed)
+	}))
+	defer srv.Close()
+
+	received := make(chan string, 1)
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func(data []byte, dest string) error {
+		received <- string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != `{"status":"OK"}` {
+		t.Errorf("%s != %s", got, `{"status":"OK"}`)
+	}
+}
+
+func TestReadBodyLimit(t *testing.T) {
+	bomb, err := gzipBytes(bytes.Repeat([]byte("a"), 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		encoding    string
+		body        []byte
+		err         bool
+	}{
+		{description: "at the limit", body: bytes.Repeat([]byte("a"), 1024)},
+		{description: "over the limit", body: bytes.Repeat([]byte("a"), 1025), err: true},
+		{description: "decompressed over the limit", encoding: "gzip", body: bomb, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			res := &http.Response{
+				Header: http.Header{"Content-Encoding": []string{test.encoding}},
+				Body:   ioutil.NopCloser(bytes.NewReader(test.body)),
+			}
+			_, err := readBody(res, 1024)
+			if got := errors.Is(err, ErrResponseTooLarge); got != test.err {
+				t.Errorf("too large %v != %v: %v", got, test.err, err)
+			}
+		})
+	}
+}
+
+func TestResponseTooLarge(t *testing.T) {
+	large := bytes.Repeat([]byte("a"), 2048)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(large)
+	}))
+	defer srv.Close()
+
+	received := make(chan struct{}, 1)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+		received <- struct{}{}
+		return nil
+	}, HTTPOptions{
+		PollingInterval: time.Second,
+		MaxResponseSize: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.SendData([]byte(`{}`), "data")
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("expected a too large error from send, got %v", err)
+	}
+	if retryable(err) {
+		t.Error("a too large response should not be retried")
+	}
+
+	for _, channel := range []string{"control", "data"} {
+		_, err := transport.fetch(context.Background(), channel, false)
+		if !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("expected a too large error from %v poll, got %v", channel, err)
+		}
+	}
+	select {
+	case <-received:
+		t.Error("a too large response was passed to the data handler")
+	default:
+	}
+}
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"mime"
+)
+
+// DefaultContentType is the content type messages are sent with unless the
+// context they are sent with carries another, set with WithContentType.
+const DefaultContentType = "application/json"
+
+type contentTypeKey struct{}
+
+// WithContentType returns a copy of ctx that makes SendDataWithContext send
+// the message as contentType, such as "application/octet-stream" for a raw
+// binary payload, instead of as DefaultContentType. The content type is kept
+// with the message if it is queued. Encrypted messages are always sent as
+// "application/octet-stream".
+func WithContentType(ctx context.Context, contentType string) context.Context {
+	return context.WithValue(ctx, contentTypeKey{}, contentType)
+}
+
+// contentType returns the content type set on ctx, or DefaultContentType.
+func contentType(ctx context.Context) string {
+	if contentType, ok := ctx.Value(contentTypeKey{}).(string); ok && contentType != "" {
+		return contentType
+	}
+	return DefaultContentType
+}
+
+// checkContentType returns a permanent error if the content type set on ctx
+// is not a valid media type.
+func checkContentType(ctx context.Context) error {
+	if _, _, err := mime.ParseMediaType(contentType(ctx)); err != nil {
+		return permanentError(fmt.Errorf("cannot use content type %q: %w", contentType(ctx), err))
+	}
+	return nil
+}
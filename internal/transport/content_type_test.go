@@ -0,0 +1,142 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendContentType(t *testing.T) {
+	tests := []struct {
+		description string
+		contentType string
+		body        []byte
+		want        string
+		wantError   error
+	}{
+		{
+			description: "default",
+			body:        []byte(`{}`),
+			want:        "application/json",
+		},
+		{
+			description: "octet stream",
+			contentType: "application/octet-stream",
+			body:        []byte{0x00, 0xff, 0x10},
+			want:        "application/octet-stream",
+		},
+		{
+			description: "protobuf",
+			contentType: "application/x-protobuf",
+			body:        []byte{0x08, 0x01},
+			want:        "application/x-protobuf",
+		},
+		{
+			description: "invalid",
+			contentType: "not a media type",
+			body:        []byte(`{}`),
+			wantError:   ErrPermanentTransport,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var gotType string
+			var gotBody []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				gotType = req.Header.Get("Content-Type")
+				gotBody, _ = ioutil.ReadAll(req.Body)
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte("ok"))
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			if test.contentType != "" {
+				ctx = WithContentType(ctx, test.contentType)
+			}
+			res, err := transport.SendDataWithContext(ctx, test.body, "data")
+			if test.wantError != nil {
+				if !errors.Is(err, test.wantError) {
+					t.Errorf("got %v, want %v", err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotType != test.want {
+				t.Errorf("got Content-Type %q, want %q", gotType, test.want)
+			}
+			if string(gotBody) != string(test.body) {
+				t.Errorf("got body %q, want %q", gotBody, test.body)
+			}
+			// The response is still decoded by its own content type.
+			if !strings.Contains(string(res), `"Body":"ok"`) {
+				t.Errorf("got response %s, want the text body", res)
+			}
+		})
+	}
+}
+
+func TestQueueContentType(t *testing.T) {
+	dir := t.TempDir()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(down.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Hour,
+		QueueDir:        dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithContentType(context.Background(), "application/octet-stream")
+	// The message is queued for later rather than failing the send.
+	if _, err := transport.SendDataWithContext(ctx, []byte{0x01, 0x02}, "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			received <- req.Header.Get("Content-Type")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	transport, err = NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Hour,
+		QueueDir:        dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		if got != "application/octet-stream" {
+			t.Errorf("got Content-Type %q, want application/octet-stream", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued message was not replayed")
+	}
+	transport.Disconnect(1000)
+	waitGroupTimeout(t, transport, time.Second)
+}
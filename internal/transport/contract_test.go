@@ -0,0 +1,205 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// contractMessage is a message exchanged between a transport and its peer.
+type contractMessage struct {
+	channel string
+	data    []byte
+}
+
+// contractPeer is the server side of a transport under contract test.
+type contractPeer interface {
+	// deliver queues message for the transport to receive on channel.
+	deliver(channel string, message []byte)
+
+	// sent returns the messages the peer has received from the transport.
+	sent() []contractMessage
+
+	// reject makes the peer refuse further messages sent by the transport,
+	// with a permanent error if permanent is set and a transient one
+	// otherwise.
+	reject(permanent bool)
+}
+
+// contractFactory creates a transport that passes the messages it receives to
+// handler, along with a peer it is set up to talk to. The transport is not
+// yet connected. The factory registers any cleanup with t.
+type contractFactory func(t *testing.T, handler DataReceiveHandlerFunc) (Transporter, contractPeer)
+
+// testTransporterContract runs the assertions every Transporter is expected to
+// satisfy against the transports created by factory.
+func testTransporterContract(t *testing.T, factory contractFactory) {
+	connect := func(t *testing.T, handler DataReceiveHandlerFunc) (Transporter, contractPeer) {
+		t.Helper()
+		transport, peer := factory(t, handler)
+		if err := transport.Connect(); err != nil {
+			t.Fatalf("cannot connect: %v", err)
+		}
+		t.Cleanup(func() { transport.Disconnect(0) })
+		return transport, peer
+	}
+
+	t.Run("send", func(t *testing.T) {
+		transport, peer := connect(t, func([]byte, string) error { return nil })
+		if _, err := transport.SendData([]byte(`{"n":1}`), "data"); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, time.Second, func() bool { return len(peer.sent()) == 1 })
+		got := peer.sent()[0]
+		if got.channel != "data" || string(got.data) != `{"n":1}` {
+			t.Errorf("peer received %q on %v, want {\"n\":1} on data", got.data, got.channel)
+		}
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		received := make(chan contractMessage, 4)
+		_, peer := connect(t, func(data []byte, dest string) error {
+			received <- contractMessage{channel: dest, data: data}
+			return nil
+		})
+		peer.deliver("data", []byte(`{"n":2}`))
+		select {
+		case got := <-received:
+			if got.channel != "data" || string(got.data) != `{"n":2}` {
+				t.Errorf("handler received %q on %v, want {\"n\":2} on data", got.data, got.channel)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received")
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		var mu sync.Mutex
+		var received int
+		transport, peer := connect(t, func([]byte, string) error {
+			mu.Lock()
+			received++
+			mu.Unlock()
+			return nil
+		})
+		transport.Disconnect(0)
+
+		if _, err := transport.SendData([]byte(`{}`), "data"); err == nil {
+			t.Error("send after disconnecting succeeded")
+		}
+		peer.deliver("data", []byte(`{}`))
+		time.Sleep(100 * time.Millisecond)
+		if n := len(peer.sent()); n != 0 {
+			t.Errorf("peer received %v messages after disconnecting, want 0", n)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if received != 0 {
+			t.Errorf("handler received %v messages after disconnecting, want 0", received)
+		}
+	})
+
+	errorTests := []struct {
+		description string
+		permanent   bool
+		want        error
+	}{
+		{description: "permanent error", permanent: true, want: ErrPermanentTransport},
+		{description: "transient error", want: ErrTransientTransport},
+	}
+	for _, test := range errorTests {
+		t.Run(test.description, func(t *testing.T) {
+			transport, peer := connect(t, func([]byte, string) error { return nil })
+			peer.reject(test.permanent)
+			if _, err := transport.SendData([]byte(`{}`), "data"); !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// httpPeer is a contractPeer serving the HTTP transport's channels.
+type httpPeer struct {
+	mu       sync.Mutex
+	inbound  map[string][][]byte
+	outbound []contractMessage
+	status   int
+}
+
+func (p *httpPeer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Paths are /yggdrasil/<channel>/<client ID>/<direction>.
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 4 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	channel := parts[1]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch req.Method {
+	case http.MethodGet:
+		if len(p.inbound[channel]) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		message := p.inbound[channel][0]
+		p.inbound[channel] = p.inbound[channel][1:]
+		w.Write(message)
+	case http.MethodPost:
+		if p.status != 0 {
+			w.WriteHeader(p.status)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		p.outbound = append(p.outbound, contractMessage{channel: channel, data: data})
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (p *httpPeer) deliver(channel string, message []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inbound[channel] = append(p.inbound[channel], append([]byte(nil), message...))
+}
+
+func (p *httpPeer) sent() []contractMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]contractMessage(nil), p.outbound...)
+}
+
+func (p *httpPeer) reject(permanent bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if permanent {
+		p.status = http.StatusBadRequest
+	} else {
+		p.status = http.StatusServiceUnavailable
+	}
+}
+
+func TestHTTPContract(t *testing.T) {
+	testTransporterContract(t, func(t *testing.T, handler DataReceiveHandlerFunc) (Transporter, contractPeer) {
+		peer := &httpPeer{inbound: make(map[string][][]byte)}
+		srv := httptest.NewServer(peer)
+		t.Cleanup(srv.Close)
+
+		transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", handler, HTTPOptions{
+			PollingInterval: 10 * time.Millisecond,
+			Backoff:         ConstantBackoff{Delay: 10 * time.Millisecond},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transport, peer
+	})
+}
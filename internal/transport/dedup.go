@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultDedupTTL is the default time for which a message ID is remembered
+// when inbound deduplication is enabled.
+const DefaultDedupTTL = 10 * time.Minute
+
+// dedupEntry is a message ID and the time it was first seen.
+type dedupEntry struct {
+	id   string
+	seen time.Time
+}
+
+// dedupCache remembers up to size recently seen message IDs for ttl each,
+// evicting the least recently seen ID when full.
+type dedupCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+func newDedupCache(size int, ttl time.Duration) *dedupCache {
+	return &dedupCache{
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+	}
+}
+
+// seen records id as seen at now and reports whether it had already been
+// seen within the last ttl.
+func (c *dedupCache) seen(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.index[id]; ok {
+		entry := elem.Value.(*dedupEntry)
+		if now.Sub(entry.seen) < c.ttl {
+			return true
+		}
+		entry.seen = now
+		c.entries.MoveToFront(elem)
+		return false
+	}
+
+	c.index[id] = c.entries.PushFront(&dedupEntry{id: id, seen: now})
+	for c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, oldest.Value.(*dedupEntry).id)
+	}
+	return false
+}
+
+// messageID returns the message_id field of an inbound message, or an empty
+// string if it has none.
+func messageID(data []byte) string {
+	var message struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return ""
+	}
+	return message.MessageID
+}
@@ -0,0 +1,70 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCache(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	cache := newDedupCache(2, time.Minute)
+
+	if cache.seen("a", now) {
+		t.Error("a reported seen before it was received")
+	}
+	if !cache.seen("a", now.Add(time.Second)) {
+		t.Error("a not reported seen within the TTL")
+	}
+	if cache.seen("a", now.Add(2*time.Minute)) {
+		t.Error("a reported seen after the TTL expired")
+	}
+
+	// Filling the cache evicts the least recently seen ID.
+	cache.seen("b", now.Add(2*time.Minute))
+	cache.seen("c", now.Add(2*time.Minute))
+	if cache.seen("a", now.Add(2*time.Minute)) {
+		t.Error("a reported seen after it was evicted")
+	}
+}
+
+func TestReceiveDataDedup(t *testing.T) {
+	tests := []struct {
+		description string
+		window      int
+		messages    []string
+		want        int
+	}{
+		{description: "disabled", messages: []string{`{"message_id":"1"}`, `{"message_id":"1"}`}, want: 2},
+		{description: "duplicate", window: 10, messages: []string{`{"message_id":"1"}`, `{"message_id":"1"}`}, want: 1},
+		{description: "distinct", window: 10, messages: []string{`{"message_id":"1"}`, `{"message_id":"2"}`}, want: 2},
+		{description: "no message ID", window: 10, messages: []string{`{}`, `{}`}, want: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var calls int
+			transport, err := NewHTTPTransportWithOptions("test", "localhost", nil, "testUA", func([]byte, string) error {
+				calls++
+				return nil
+			}, HTTPOptions{
+				PollingInterval: time.Second,
+				DedupWindow:     test.window,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, message := range test.messages {
+				if err := transport.ReceiveData([]byte(message), "data"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if calls != test.want {
+				t.Errorf("handler called %v times, want %v", calls, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// dryRun logs the request that would send message to the outbound URL of
+// channel and returns a synthetic successful response instead of sending it.
+func (t *HTTP) dryRun(ctx context.Context, message []byte, channel string) ([]byte, error) {
+	id := requestID(message)
+	header := http.Header{}
+	t.setHeaders(header)
+	header.Set("Content-Type", contentType(ctx))
+	header.Set("User-Agent", t.userAgent)
+	header.Set(RequestIDHeader, id)
+	if key := idempotencyKeyFrom(ctx); key != "" {
+		header.Set(IdempotencyKeyHeader, key)
+	}
+	if err := t.signingKeys.Load().(*signingKeys).sign(header, signatureScope(http.MethodPost, channel, t.clientID), message, t.serverNow()); err != nil {
+		return nil, permanentError(fmt.Errorf("cannot sign message: %w", err))
+	}
+	injectTraceContext(ctx, header)
+	t.opts.Logger.Info("dry run, not sending message", "channel", channel, "url", t.getUrl("out", channel), "header", header, "body", string(message))
+
+	response := HTTPResponse{
+		StatusCode: http.StatusOK,
+		Metadata:   map[string]string{RequestIDHeader: id},
+	}
+	if t.opts.ResponseHeader {
+		response.Header = http.Header{RequestIDHeader: {id}}
+	}
+	return t.marshalResponse(response)
+}
@@ -0,0 +1,75 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRoundTripper is an http.RoundTripper that counts the requests sent
+// through it and fails them.
+type countingRoundTripper struct {
+	requests int32
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return nil, errors.New("unexpected request")
+}
+
+func TestDryRun(t *testing.T) {
+	rt := &countingRoundTripper{}
+	logger := &captureLogger{}
+	transport, err := NewHTTPTransportWithOptions("test", "localhost:1", nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Millisecond,
+		RoundTripper:    rt,
+		Logger:          logger,
+		DryRun:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	message := `{"metadata":{"X-Request-ID":"dry-run-id"}}`
+	data, err := transport.SendData([]byte(message), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response HTTPResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status code %v != %v", response.StatusCode, http.StatusOK)
+	}
+	if got := response.Metadata[RequestIDHeader]; got != "dry-run-id" {
+		t.Errorf("request ID %v != dry-run-id", got)
+	}
+
+	entry, ok := logger.find("dry run, not sending message")
+	if !ok {
+		t.Fatal("dry-run request not logged")
+	}
+	if got := entry.fields["body"]; got != message {
+		t.Errorf("body field %v != %v", got, message)
+	}
+	if got := entry.fields["url"]; got != transport.getUrl("out", "data") {
+		t.Errorf("url field %v is not the outbound URL", got)
+	}
+
+	// Give the polling loops, had they been started, time to poll.
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&rt.requests); n != 0 {
+		t.Errorf("%v requests sent in dry-run mode", n)
+	}
+}
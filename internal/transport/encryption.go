@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// EncryptionHeader marks an encrypted message body. Its value names the
+	// cipher, which is always EncryptionAESGCM.
+	EncryptionHeader = "X-Encryption"
+
+	// EncryptionKeyIDHeader identifies the key an encrypted message body was
+	// encrypted with.
+	EncryptionKeyIDHeader = "X-Encryption-Key-ID"
+
+	// EncryptionAESGCM is the value of EncryptionHeader for bodies encrypted
+	// with AES in GCM mode. The body is the random nonce followed by the
+	// sealed message, authenticated together with the key ID.
+	EncryptionAESGCM = "AES-GCM"
+)
+
+// ErrDecryption is returned when an inbound message cannot be decrypted,
+// because its key is unknown or its ciphertext was tampered with.
+var ErrDecryption = errors.New("cannot decrypt message")
+
+// encryptionKeys holds the AES-GCM ciphers of the configured keys, by key ID,
+// and the ID of the key outbound messages are encrypted with. An empty ID
+// disables encryption.
+type encryptionKeys struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+func newEncryptionKeys(current string, keys map[string][]byte) (*encryptionKeys, error) {
+	k := &encryptionKeys{
+		current: current,
+		aeads:   make(map[string]cipher.AEAD),
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use encryption key %v: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use encryption key %v: %w", id, err)
+		}
+		k.aeads[id] = aead
+	}
+	if _, ok := k.aeads[current]; current != "" && !ok {
+		return nil, fmt.Errorf("cannot use encryption key %v: no such key", current)
+	}
+	return k, nil
+}
+
+// encrypt encrypts message with the current key, if any, setting the
+// encryption headers on header. It returns message unchanged if encryption
+// is disabled.
+func (k *encryptionKeys) encrypt(header http.Header, message []byte) ([]byte, error) {
+	if k.current == "" {
+		return message, nil
+	}
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(message)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header.Set(EncryptionHeader, EncryptionAESGCM)
+	header.Set(EncryptionKeyIDHeader, k.current)
+	return aead.Seal(nonce, nonce, message, []byte(k.current)), nil
+}
+
+// decrypt decrypts body if header marks it as encrypted, and returns it
+// unchanged otherwise.
+func (k *encryptionKeys) decrypt(header http.Header, body []byte) ([]byte, error) {
+	switch header.Get(EncryptionHeader) {
+	case "":
+		return body, nil
+	case EncryptionAESGCM:
+	default:
+		return nil, fmt.Errorf("%w: unsupported cipher %v", ErrDecryption, header.Get(EncryptionHeader))
+	}
+	id := header.Get(EncryptionKeyIDHeader)
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %v", ErrDecryption, id)
+	}
+	if len(body) < aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+	message, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return message, nil
+}
+
+// ReloadEncryptionKeys replaces the encryption keys, by key ID, and the ID of
+// the key outbound data messages are encrypted with. Keep retired keys in
+// keys for as long as messages encrypted with them may still arrive.
+func (t *HTTP) ReloadEncryptionKeys(current string, keys map[string][]byte) error {
+	k, err := newEncryptionKeys(current, keys)
+	if err != nil {
+		return err
+	}
+	t.encryptionKeys.Store(k)
+	return nil
+}
@@ -0,0 +1,175 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoServer stores the body and headers of the last message posted to it,
+// and returns them in response to polls.
+type echoServer struct {
+	mu     sync.Mutex
+	body   []byte
+	header http.Header
+}
+
+func (s *echoServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Method == http.MethodPost {
+		s.body, _ = ioutil.ReadAll(req.Body)
+		s.header = req.Header.Clone()
+		return
+	}
+	for _, k := range []string{EncryptionHeader, EncryptionKeyIDHeader} {
+		if v := s.header.Get(k); v != "" {
+			w.Header().Set(k, v)
+		}
+	}
+	_, _ = w.Write(s.body)
+}
+
+func (s *echoServer) tamper() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body[len(s.body)-1] ^= 0xff
+}
+
+func newEncryptingTransport(t *testing.T, server string, opts HTTPOptions, received chan<- []byte) *HTTP {
+	t.Helper()
+	opts.PollingInterval = time.Second
+	opts.Logger = &captureLogger{}
+	transport, err := NewHTTPTransportWithOptions("test", server, nil, "testUA", func(data []byte, dest string) error {
+		received <- data
+		return nil
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport
+}
+
+func TestEncryption(t *testing.T) {
+	echo := &echoServer{}
+	srv := httptest.NewServer(echo)
+	defer srv.Close()
+	server := strings.TrimPrefix(srv.URL, "http://")
+
+	keys := map[string][]byte{
+		"old": bytes.Repeat([]byte{1}, 32),
+		"new": bytes.Repeat([]byte{2}, 16),
+	}
+	received := make(chan []byte, 1)
+	transport := newEncryptingTransport(t, server, HTTPOptions{EncryptionKeys: keys, EncryptionKeyID: "old"}, received)
+
+	message := []byte(`{"content":"secret"}`)
+	expectRoundTrip := func(keyID string) {
+		t.Helper()
+		if _, err := transport.SendData(message, "data"); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(echo.body, []byte("secret")) {
+			t.Error("message sent in the clear")
+		}
+		if got := echo.header.Get(EncryptionKeyIDHeader); got != keyID {
+			t.Errorf("key ID %v != %v", got, keyID)
+		}
+		if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-received:
+			if !bytes.Equal(got, message) {
+				t.Errorf("decrypted %s != %s", got, message)
+			}
+		default:
+			t.Fatal("encrypted message dropped")
+		}
+	}
+	expectRoundTrip("old")
+
+	// A message encrypted with the old key still decrypts after rotation.
+	if err := transport.ReloadEncryptionKeys("new", keys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !bytes.Equal(got, message) {
+		t.Errorf("decrypted %s != %s after rotation", got, message)
+	}
+	expectRoundTrip("new")
+
+	// A tampered ciphertext is dropped.
+	echo.tamper()
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 0 {
+		t.Error("tampered message passed to the data handler")
+	}
+
+	// A message encrypted with a retired key is dropped.
+	if err := transport.ReloadEncryptionKeys("new", map[string][]byte{"new": keys["new"]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData(message, "data"); err != nil {
+		t.Fatal(err)
+	}
+	other := newEncryptingTransport(t, server, HTTPOptions{EncryptionKeys: map[string][]byte{"old": keys["old"]}}, received)
+	if _, err := other.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 0 {
+		t.Error("message encrypted with an unknown key passed to the data handler")
+	}
+}
+
+func TestEncryptionControlChannel(t *testing.T) {
+	echo := &echoServer{}
+	srv := httptest.NewServer(echo)
+	defer srv.Close()
+
+	transport := newEncryptingTransport(t, strings.TrimPrefix(srv.URL, "http://"), HTTPOptions{
+		EncryptionKeys:  map[string][]byte{"key": bytes.Repeat([]byte{1}, 32)},
+		EncryptionKeyID: "key",
+	}, make(chan []byte, 1))
+	if _, err := transport.SendData([]byte(`{"command":"ping"}`), "control"); err != nil {
+		t.Fatal(err)
+	}
+	if string(echo.body) != `{"command":"ping"}` || echo.header.Get(EncryptionHeader) != "" {
+		t.Errorf("control message encrypted: %s", echo.body)
+	}
+}
+
+func TestEncryptionInvalidKeys(t *testing.T) {
+	tests := []struct {
+		description string
+		keys        map[string][]byte
+		keyID       string
+	}{
+		{description: "short key", keys: map[string][]byte{"key": []byte("short")}},
+		{description: "missing current key", keys: map[string][]byte{"key": bytes.Repeat([]byte{1}, 32)}, keyID: "other"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := NewHTTPTransportWithOptions("test", "localhost", nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+				EncryptionKeys:  test.keys,
+				EncryptionKeyID: test.keyID,
+			})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+)
+
+// DefaultEndpointFailureThreshold is the default number of consecutive failed
+// requests after which an endpoint is taken out of rotation.
+const DefaultEndpointFailureThreshold = 3
+
+// DefaultEndpointProbeInterval is the default time an endpoint stays out of
+// rotation before a request probes it again.
+const DefaultEndpointProbeInterval = 30 * time.Second
+
+// Endpoint is a server, equivalent to the one an HTTP transport is created
+// with, that requests may be sent to.
+type Endpoint struct {
+	// Server is the server, given as host[:port].
+	Server string
+
+	// Weight is the share of requests sent to the server, relative to the
+	// weights of the other endpoints. The server the transport was created
+	// with has a weight of 1. Defaults to 1.
+	Weight int
+}
+
+// endpoint is an endpoint in the rotation of an endpointPool.
+type endpoint struct {
+	host   string
+	port   string
+	weight int
+
+	// current is the running weight of smooth weighted round-robin.
+	current   int
+	failures  int
+	ejected   bool
+	ejectedAt time.Time
+}
+
+// endpointPool spreads requests across equivalent servers by smooth weighted
+// round-robin, taking a server out of rotation after threshold consecutive
+// failed requests and letting a single request probe it again every
+// probeInterval until one succeeds. A nil *endpointPool has no endpoints.
+type endpointPool struct {
+	threshold     int
+	probeInterval time.Duration
+	log           Logger
+
+	// mu guards the fields below.
+	mu        sync.Mutex
+	endpoints []*endpoint
+}
+
+// newEndpointPool creates a pool of the server the transport was created
+// with, given by host and port, and endpoints, which are reached with TLS if
+// useTLS is set.
+func newEndpointPool(host, port string, useTLS bool, endpoints []Endpoint, threshold int, probeInterval time.Duration, logger Logger) (*endpointPool, error) {
+	p := &endpointPool{
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		log:           logger,
+		endpoints:     []*endpoint{{host: host, port: port, weight: 1}},
+	}
+	for _, e := range endpoints {
+		host, port, err := splitServer(e.Server, useTLS)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use endpoint: %w", err)
+		}
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("cannot use endpoint %v: negative weight %v", e.Server, e.Weight)
+		}
+		weight := e.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		p.endpoints = append(p.endpoints, &endpoint{host: host, port: port, weight: weight})
+	}
+	return p, nil
+}
+
+// next returns the host and port of the endpoint a request made at now is
+// sent to, so it must only be called for a request about to be sent. An
+// endpoint out of rotation whose probe interval has elapsed is returned once
+// to probe it. If every endpoint is out of rotation, the one taken out
+// longest ago is returned regardless.
+func (p *endpointPool) next(now time.Time) (host, port string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range p.endpoints {
+		if e.ejected && !now.Before(e.ejectedAt.Add(p.probeInterval)) {
+			// Restart the interval, so that other requests are not sent
+			// to the endpoint while the probe is in flight.
+			e.ejectedAt = now
+			p.log.Debug("probing endpoint", "server", joinServer(e.host, e.port))
+			return e.host, e.port
+		}
+	}
+
+	var best, oldest *endpoint
+	total := 0
+	for _, e := range p.endpoints {
+		if e.ejected {
+			if oldest == nil || e.ejectedAt.Before(oldest.ejectedAt) {
+				oldest = e
+			}
+			continue
+		}
+		e.current += e.weight
+		total += e.weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	if best == nil {
+		return oldest.host, oldest.port
+	}
+	best.current -= total
+	return best.host, best.port
+}
+
+// record records the outcome of a request made at now to hostport, as found
+// in a request URL whose port is defaultPort unless it names one.
+func (p *endpointPool) record(hostport, defaultPort string, now time.Time, failed bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.lookup(hostport, defaultPort)
+	if e == nil {
+		return
+	}
+	if !failed {
+		if e.ejected {
+			p.log.Info("endpoint probe succeeded, returning endpoint to rotation", "server", joinServer(e.host, e.port))
+			// Start the endpoint afresh rather than with the running
+			// weight it had when it was taken out.
+			e.current = 0
+		}
+		e.ejected = false
+		e.failures = 0
+		return
+	}
+
+	e.failures++
+	switch {
+	case e.ejected:
+		e.ejectedAt = now
+		p.log.Debug("endpoint probe failed", "server", joinServer(e.host, e.port))
+	case e.failures >= p.threshold:
+		e.ejected = true
+		e.ejectedAt = now
+		p.log.Warn("too many consecutive failed requests, taking endpoint out of rotation", "server", joinServer(e.host, e.port), "failures", e.failures, "probe_interval", p.probeInterval)
+	}
+}
+
+// lookup returns the endpoint of hostport, or nil if it is not in the pool.
+func (p *endpointPool) lookup(hostport, defaultPort string) *endpoint {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, defaultPort
+	}
+	for _, e := range p.endpoints {
+		ePort := e.port
+		if ePort == "" {
+			ePort = defaultPort
+		}
+		if e.host == host && ePort == port {
+			return e
+		}
+	}
+	return nil
+}
+
+// do sends req with client. If Endpoints is set and req is addressed to the
+// server the transport was created with, as the URLs built by getUrl are
+// unless they are those of WriteServer, do first readdresses it to the
+// endpoint next in rotation, and records its outcome for that endpoint once
+// it is sent. Requests that fail or are answered with a server error count
+// as failed; cancelled requests are not recorded.
+func (t *HTTP) do(client *internalhttp.Client, req *http.Request) (*http.Response, error) {
+	if t.endpoints != nil && req.URL.Host == hostPort(t.host, t.port, req.URL.Scheme) {
+		host, port := t.endpoints.next(t.clock.Now())
+		req.URL.Host = hostPort(host, port, req.URL.Scheme)
+		req.Host = req.URL.Host
+	}
+	resp, err := client.Do(req)
+	if t.endpoints != nil && req.Context().Err() == nil {
+		failed := err != nil || resp.StatusCode >= 500
+		t.endpoints.record(req.URL.Host, defaultPorts[req.URL.Scheme], t.clock.Now(), failed)
+	}
+	return resp, err
+}
+
+// hostPort returns the host and port of a URL with scheme, naming the default
+// port of scheme if port is empty, as getUrl does.
+func hostPort(host, port, scheme string) string {
+	if port == "" {
+		port = defaultPorts[scheme]
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,221 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEndpointPoolDistribution(t *testing.T) {
+	pool, err := newEndpointPool("a", "", false, []Endpoint{{Server: "b:8080", Weight: 3}, {Server: "c"}}, 3, time.Minute, goLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i := 0; i < 5; i++ {
+		host, _ := pool.next(time.Unix(0, 0))
+		got = append(got, host)
+	}
+	// The heavier endpoint's requests are interleaved with the others rather
+	// than sent in a burst.
+	want := []string{"b", "a", "b", "c", "b"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+}
+
+func TestEndpointPoolInvalid(t *testing.T) {
+	tests := []struct {
+		description string
+		endpoint    Endpoint
+	}{
+		{description: "path", endpoint: Endpoint{Server: "b/api"}},
+		{description: "negative weight", endpoint: Endpoint{Server: "b", Weight: -1}},
+		{description: "https scheme without TLS", endpoint: Endpoint{Server: "https://b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := NewHTTPTransportWithOptions("test", "a", nil, "testUA", nil, HTTPOptions{
+				Endpoints: []Endpoint{test.endpoint},
+			})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestEndpointPoolEjection(t *testing.T) {
+	pool, err := newEndpointPool("a", "", false, []Endpoint{{Server: "b"}}, 2, time.Minute, goLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	hosts := func(n int) []string {
+		var got []string
+		for i := 0; i < n; i++ {
+			host, _ := pool.next(now)
+			got = append(got, host)
+		}
+		return got
+	}
+
+	// A single failure leaves b in rotation.
+	pool.record("b:80", "80", now, true)
+	if got, want := hosts(2), []string{"a", "b"}; !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+	pool.record("b:80", "80", now, true)
+	if got, want := hosts(3), []string{"a", "a", "a"}; !cmp.Equal(got, want) {
+		t.Errorf("after ejection: %v != %v", got, want)
+	}
+
+	// Once the probe interval has elapsed, a single request probes b.
+	now = now.Add(time.Minute)
+	if got, want := hosts(3), []string{"b", "a", "a"}; !cmp.Equal(got, want) {
+		t.Errorf("probing: %v != %v", got, want)
+	}
+	pool.record("b:80", "80", now, true)
+	now = now.Add(30 * time.Second)
+	if got, want := hosts(2), []string{"a", "a"}; !cmp.Equal(got, want) {
+		t.Errorf("after failed probe: %v != %v", got, want)
+	}
+
+	now = now.Add(30 * time.Second)
+	if got, want := hosts(1), []string{"b"}; !cmp.Equal(got, want) {
+		t.Errorf("probing again: %v != %v", got, want)
+	}
+	pool.record("b:80", "80", now, false)
+	if got, want := hosts(4), []string{"a", "b", "a", "b"}; !cmp.Equal(got, want) {
+		t.Errorf("after reinstatement: %v != %v", got, want)
+	}
+}
+
+func TestEndpointPoolAllEjected(t *testing.T) {
+	pool, err := newEndpointPool("a", "", false, []Endpoint{{Server: "b"}}, 1, time.Minute, goLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	pool.record("b:80", "80", now, true)
+	pool.record("a:80", "80", now.Add(time.Second), true)
+
+	if host, _ := pool.next(now.Add(2 * time.Second)); host != "b" {
+		t.Errorf("got %v, want the endpoint ejected first", host)
+	}
+}
+
+func TestEndpointsChosenWhenSent(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			got = append(got, name)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	primary := httptest.NewServer(handler("primary"))
+	defer primary.Close()
+	backup := httptest.NewServer(handler("backup"))
+	defer backup.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(primary.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		Endpoints: []Endpoint{{Server: strings.TrimPrefix(backup.URL, "http://")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Building URLs sends nothing, so it leaves the rotation alone.
+	want := "http://" + strings.TrimPrefix(primary.URL, "http://") + "/yggdrasil/data/test/in"
+	for i := 0; i < 3; i++ {
+		if url := transport.getUrl("in", "data"); url != want {
+			t.Errorf("%v != %v", url, want)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"primary", "backup"}; !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	var mu sync.Mutex
+	counts := map[string]int{}
+	var failing int32
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			counts[name]++
+			mu.Unlock()
+			if name == "backup" && atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	primary := httptest.NewServer(handler("primary"))
+	defer primary.Close()
+	backup := httptest.NewServer(handler("backup"))
+	defer backup.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(primary.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		Endpoints:             []Endpoint{{Server: strings.TrimPrefix(backup.URL, "http://"), Weight: 2}},
+		EndpointProbeInterval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	fetch := func(n int) map[string]int {
+		mu.Lock()
+		counts = map[string]int{}
+		mu.Unlock()
+		for i := 0; i < n; i++ {
+			transport.fetch(context.Background(), "data", false)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return counts
+	}
+
+	if got, want := fetch(6), map[string]int{"primary": 2, "backup": 4}; !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+
+	// The backup is taken out of rotation after three server errors.
+	atomic.StoreInt32(&failing, 1)
+	if got, want := fetch(10), map[string]int{"primary": 7, "backup": 3}; !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+	if got, want := fetch(3), map[string]int{"primary": 3}; !cmp.Equal(got, want) {
+		t.Errorf("after ejection: %v != %v", got, want)
+	}
+
+	// It returns once a probe succeeds.
+	atomic.StoreInt32(&failing, 0)
+	clock.advance(time.Minute)
+	if got, want := fetch(4), map[string]int{"primary": 1, "backup": 3}; !cmp.Equal(got, want) {
+		t.Errorf("after reinstatement: %v != %v", got, want)
+	}
+}
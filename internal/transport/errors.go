@@ -0,0 +1,110 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+)
+
+// Errors returned by transports are classified as either transient or
+// permanent, and match one of these with errors.Is. A transient error, such
+// as a network failure, a server error or a 408 or 429 response, may go away
+// if the request is retried. A permanent error, such as any other 4xx
+// response, will not.
+var (
+	ErrTransientTransport = errors.New("transient transport error")
+	ErrPermanentTransport = errors.New("permanent transport error")
+)
+
+// ErrTransportDisconnected is returned when a message is sent while the
+// transport is disconnected, and is dropped rather than sent. It is
+// transient: the caller can queue the message, or retry it once the
+// transport is connected again.
+var ErrTransportDisconnected = errors.New("transport disconnected")
+
+// ErrTransportClosed is returned when a transport is connected after it was
+// closed. It is permanent.
+var ErrTransportClosed = errors.New("transport closed")
+
+// An HTTPStatusError is returned when the server responds to a request with
+// an HTTP status code of 400 or greater. It carries the response body and
+// headers so callers can inspect what the server said.
+type HTTPStatusError struct {
+	StatusCode int
+	Body       []byte
+	Header     http.Header
+}
+
+func (e HTTPStatusError) Error() string {
+	return fmt.Sprintf("%v %v", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Is classifies the error as transient when the status code is 408, 429 or
+// 5xx, and as permanent otherwise.
+func (e HTTPStatusError) Is(target error) bool {
+	switch target {
+	case ErrTransientTransport:
+		return e.transient()
+	case ErrPermanentTransport:
+		return !e.transient()
+	default:
+		return false
+	}
+}
+
+func (e HTTPStatusError) transient() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	default:
+		return e.StatusCode >= 500
+	}
+}
+
+// classifiedError wraps err so that it matches class with errors.Is.
+type classifiedError struct {
+	err   error
+	class error
+}
+
+func (e classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// requestError classifies err, returned sending a request, as permanent if the
+// server sent oversized response headers or if err is already permanent, as
+// the error of a request interceptor aborting the request may be, and as
+// transient otherwise.
+func requestError(err error) error {
+	if errors.Is(err, internalhttp.ErrResponseHeaderTooLarge) || errors.Is(err, ErrPermanentTransport) {
+		return permanentError(err)
+	}
+	return transientError(err)
+}
+
+// transientError marks err as transient.
+func transientError(err error) error {
+	return classifiedError{err: err, class: ErrTransientTransport}
+}
+
+// permanentError marks err as permanent.
+func permanentError(err error) error {
+	return classifiedError{err: err, class: ErrPermanentTransport}
+}
+
+// retryable reports whether err is a failure that resending the same request
+// may fix. A nil error and permanent errors are not retryable; errors that
+// are not classified are assumed to be transient.
+func retryable(err error) bool {
+	return err != nil && !errors.Is(err, ErrPermanentTransport)
+}
@@ -0,0 +1,192 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+)
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		description string
+		err         error
+		transient   bool
+		permanent   bool
+	}{
+		{description: "400", err: HTTPStatusError{StatusCode: http.StatusBadRequest}, permanent: true},
+		{description: "401", err: HTTPStatusError{StatusCode: http.StatusUnauthorized}, permanent: true},
+		{description: "404", err: HTTPStatusError{StatusCode: http.StatusNotFound}, permanent: true},
+		{description: "408", err: HTTPStatusError{StatusCode: http.StatusRequestTimeout}, transient: true},
+		{description: "413", err: HTTPStatusError{StatusCode: http.StatusRequestEntityTooLarge}, permanent: true},
+		{description: "429", err: HTTPStatusError{StatusCode: http.StatusTooManyRequests}, transient: true},
+		{description: "500", err: HTTPStatusError{StatusCode: http.StatusInternalServerError}, transient: true},
+		{description: "502", err: HTTPStatusError{StatusCode: http.StatusBadGateway}, transient: true},
+		{description: "503", err: HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, transient: true},
+		{description: "wrapped 404", err: fmt.Errorf("cannot send: %w", HTTPStatusError{StatusCode: http.StatusNotFound}), permanent: true},
+		{description: "network", err: transientError(&net.OpError{Op: "dial", Err: errors.New("refused")}), transient: true},
+		{description: "local", err: permanentError(errors.New("cannot marshal")), permanent: true},
+		{description: "unclassified", err: errors.New("unknown")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := errors.Is(test.err, ErrTransientTransport); got != test.transient {
+				t.Errorf("transient %v != %v", got, test.transient)
+			}
+			if got := errors.Is(test.err, ErrPermanentTransport); got != test.permanent {
+				t.Errorf("permanent %v != %v", got, test.permanent)
+			}
+			if got := retryable(test.err); got != !test.permanent {
+				t.Errorf("retryable %v != %v", got, !test.permanent)
+			}
+		})
+	}
+}
+
+func TestSendNetworkErrorIsTransient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	server := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	transport, err := NewHTTPTransport("test", server, nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = transport.SendData([]byte(`{}`), "data")
+	if !errors.Is(err, ErrTransientTransport) {
+		t.Errorf("expected a transient error, got %v", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("expected the underlying network error to be preserved, got %v", err)
+	}
+}
+
+func TestOversizedResponseHeadersArePermanent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Padding", strings.Repeat("a", 4096))
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		ClientOptions: internalhttp.ClientOptions{MaxResponseHeaderBytes: 1024},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = transport.SendData([]byte(`{}`), "data")
+	if !errors.Is(err, ErrPermanentTransport) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if !errors.Is(err, internalhttp.ErrResponseHeaderTooLarge) {
+		t.Errorf("expected ErrResponseHeaderTooLarge, got %v", err)
+	}
+}
+
+func TestRequestInterceptors(t *testing.T) {
+	var sent, denied int
+	var headers []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sent++
+		headers = append(headers, req.Method+" "+req.Header.Get("X-Signature"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	errDenied := fmt.Errorf("%w: denied", ErrPermanentTransport)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		SendRetries: 3,
+		ClientOptions: internalhttp.ClientOptions{
+			RequestInterceptors: []internalhttp.RequestInterceptor{
+				func(req *http.Request) (*http.Request, error) {
+					req.Header.Set("X-Signature", "signed")
+					return req, nil
+				},
+				func(req *http.Request) (*http.Request, error) {
+					if req.URL.Path == "/yggdrasil/control/test/out" {
+						denied++
+						return nil, errDenied
+					}
+					return req, nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"GET signed", "POST signed"}; strings.Join(headers, ",") != strings.Join(want, ",") {
+		t.Errorf("server got %v, want %v", headers, want)
+	}
+
+	_, err = transport.SendData([]byte(`{}`), "control")
+	if !errors.Is(err, errDenied) || !errors.Is(err, ErrPermanentTransport) {
+		t.Errorf("got %v, want the permanent interceptor error", err)
+	}
+	if sent != 2 {
+		t.Errorf("server got %v requests, want the aborted send never to reach it", sent)
+	}
+	if denied != 1 {
+		t.Errorf("send aborted %v times, want it not to be retried", denied)
+	}
+}
+
+func TestResponseInterceptors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		ClientOptions: internalhttp.ClientOptions{
+			ResponseInterceptors: []internalhttp.ResponseInterceptor{
+				func(res *http.Response) (*http.Response, error) {
+					if res.StatusCode == http.StatusTeapot {
+						res.StatusCode = http.StatusAccepted
+					}
+					return res, nil
+				},
+				func(res *http.Response) (*http.Response, error) {
+					res.Header.Set("X-Route", "gateway")
+					return res, nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := transport.SendData([]byte(`{}`), "data")
+	if err != nil {
+		t.Fatalf("got %v, want the 418 treated as success", err)
+	}
+	response, err := transport.UnmarshalResponse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Errorf("status %v != %v", response.StatusCode, http.StatusAccepted)
+	}
+	if got := response.Metadata["X-Route"]; got != "gateway" {
+		t.Errorf("X-Route metadata %q != %q", got, "gateway")
+	}
+}
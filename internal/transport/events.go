@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies a change in the lifecycle of a transport.
+type EventType int
+
+const (
+	// EventConnected is emitted when the transport connects, and when it
+	// recovers after EventReconnecting.
+	EventConnected EventType = iota
+
+	// EventDisconnected is emitted when the transport is disconnected.
+	EventDisconnected
+
+	// EventReconnecting is emitted when a connected transport starts
+	// failing to reach the server, and carries the error.
+	EventReconnecting
+
+	// EventTLSReloaded is emitted when the TLS configuration of the
+	// transport is reloaded.
+	EventTLSReloaded
+
+	// EventHandlerPanic is emitted when the data handler panics, and
+	// carries a *HandlerPanicError.
+	EventHandlerPanic
+
+	// EventTLSDowngrade is emitted when HTTPOptions.StrictTLS stops the
+	// transport from falling back to plain HTTP, and carries the error.
+	EventTLSDowngrade
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventReconnecting:
+		return "reconnecting"
+	case EventTLSReloaded:
+		return "TLS reloaded"
+	case EventHandlerPanic:
+		return "handler panic"
+	case EventTLSDowngrade:
+		return "TLS downgrade"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change in the lifecycle of a transport.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Err is the error that caused the event, if any.
+	Err error
+}
+
+// EventHandlerFunc is called with each lifecycle event of a transport.
+type EventHandlerFunc func(Event)
+
+// eventDispatcher calls an EventHandlerFunc with events, in the order they
+// were emitted, on a goroutine of its own, so that a slow handler never
+// blocks the transport. A nil *eventDispatcher drops all events.
+type eventDispatcher struct {
+	handler EventHandlerFunc
+
+	// mu guards the fields below.
+	mu      sync.Mutex
+	pending []Event
+	running bool
+}
+
+func newEventDispatcher(handler EventHandlerFunc) *eventDispatcher {
+	return &eventDispatcher{handler: handler}
+}
+
+// emit queues event for the handler without waiting for it to be handled.
+func (d *eventDispatcher) emit(event Event) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = append(d.pending, event)
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+}
+
+// run passes queued events to the handler until the queue is empty.
+func (d *eventDispatcher) run() {
+	for {
+		d.mu.Lock()
+		if len(d.pending) == 0 {
+			d.running = false
+			d.mu.Unlock()
+			return
+		}
+		event := d.pending[0]
+		d.pending = d.pending[1:]
+		d.mu.Unlock()
+
+		d.handler(event)
+	}
+}
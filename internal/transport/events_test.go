@@ -0,0 +1,108 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLifecycleEvents(t *testing.T) {
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	events := make(chan Event, 10)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: 10 * time.Millisecond,
+		Backoff:         ConstantBackoff{Delay: 10 * time.Millisecond},
+		OnEvent:         func(event Event) { events <- event },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(want EventType) Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Fatalf("event %v, want %v", event.Type, want)
+			}
+			if event.Time.IsZero() {
+				t.Errorf("%v event has no time", event.Type)
+			}
+			return event
+		case <-time.After(time.Second):
+			t.Fatalf("no %v event", want)
+			return Event{}
+		}
+	}
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventConnected)
+
+	atomic.StoreInt32(&failing, 1)
+	if event := expect(EventReconnecting); event.Err == nil {
+		t.Error("reconnecting event has no error")
+	}
+	atomic.StoreInt32(&failing, 0)
+	expect(EventConnected)
+
+	if err := transport.ReloadTLSConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTLSReloaded)
+
+	transport.Disconnect(0)
+	expect(EventDisconnected)
+	waitGroupTimeout(t, transport, time.Second)
+
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %v event after disconnecting", event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLifecycleEventsDoNotBlock(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		OnEvent:         func(Event) { <-release },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			_ = transport.Connect()
+			transport.Disconnect(0)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a blocked event handler held up the transport")
+	}
+}
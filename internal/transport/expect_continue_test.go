@@ -0,0 +1,121 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+)
+
+// rejection is what a rejectingServer saw of a request.
+type rejection struct {
+	expect string
+	sent   int64
+}
+
+// rejectingServer answers a request with 413 as soon as it has read the
+// request headers, without sending 100 Continue, and reports the Expect
+// header of the request and the number of body bytes the client sent anyway.
+func rejectingServer(t *testing.T) (string, <-chan rejection) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	results := make(chan rejection, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 413 Request Entity Too Large\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		// Count whatever body the client sends before it gives up.
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _ := io.Copy(ioutil.Discard, req.Body)
+		results <- rejection{expect: req.Header.Get("Expect"), sent: n}
+	}()
+	return l.Addr().String(), results
+}
+
+func TestExpectContinueRejected(t *testing.T) {
+	addr, results := rejectingServer(t)
+	transport, err := NewHTTPTransportWithOptions("test", addr, nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval:         time.Second,
+		ExpectContinue:          true,
+		ExpectContinueThreshold: 1024,
+		ClientOptions:           internalhttp.ClientOptions{ExpectContinueTimeout: 10 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := bytes.Repeat([]byte("a"), 512*1024)
+	_, err = transport.SendData(body, "data")
+	var statusErr HTTPStatusError
+	if err != nil && !errors.As(err, &statusErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := <-results
+	if result.expect != "100-continue" {
+		t.Errorf("Expect header %q, want 100-continue", result.expect)
+	}
+	if result.sent != 0 {
+		t.Errorf("%v body bytes sent after rejection, want none of %v", result.sent, len(body))
+	}
+}
+
+func TestExpectContinueThreshold(t *testing.T) {
+	expects := make(chan string, 1)
+	bodies := make(chan int, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		expects <- req.Header.Get("Expect")
+		data, _ := ioutil.ReadAll(req.Body)
+		bodies <- len(data)
+	}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		size int
+		want string
+	}{
+		{size: 1024, want: ""},
+		{size: 1025, want: "100-continue"},
+	} {
+		transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+			PollingInterval:         time.Second,
+			ExpectContinue:          true,
+			ExpectContinueThreshold: 1024,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transport.SendData(bytes.Repeat([]byte("a"), test.size), "data"); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-expects; got != test.want {
+			t.Errorf("%v-byte body sent with Expect %q, want %q", test.size, got, test.want)
+		}
+		if got := <-bodies; got != test.size {
+			t.Errorf("server received %v bytes, want %v", got, test.size)
+		}
+	}
+}
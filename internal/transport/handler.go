@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// HandlerPanicError is returned by ReceiveData when the data handler panics.
+type HandlerPanicError struct {
+	// Value is the value the handler panicked with.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *HandlerPanicError) Error() string {
+	return fmt.Sprintf("data handler panicked: %v", e.Value)
+}
+
+// callHandler calls handler, recovering from a panic, which is returned as a
+// *HandlerPanicError.
+func callHandler(handler func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &HandlerPanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handler()
+}
+
+// handle calls handler to handle a message received on dest. A panic in the
+// handler is recovered, logged with its stack trace and reported as an
+// EventHandlerPanic, and returned as a *HandlerPanicError.
+func (t *HTTP) handle(dest string, handler func() error) error {
+	err := callHandler(handler)
+	if panicErr, ok := err.(*HandlerPanicError); ok {
+		t.opts.Logger.Error("data handler panicked", "channel", dest, "panic", panicErr.Value, "stack", string(panicErr.Stack))
+		t.events.emit(Event{Type: EventHandlerPanic, Time: t.clock.Now(), Err: err})
+	} else if err != nil {
+		t.opts.Logger.Warn("data handler failed", "channel", dest, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDataHandlerPanic(t *testing.T) {
+	var mu sync.Mutex
+	messages := []string{"first", "second"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.Contains(req.URL.Path, "/data/") || len(messages) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(messages[0]))
+		messages = messages[1:]
+	}))
+	defer srv.Close()
+
+	received := make(chan string, 1)
+	events := make(chan Event, 10)
+	logger := &captureLogger{}
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+		if string(data) == "first" {
+			panic("boom")
+		}
+		received <- string(data)
+		return nil
+	}, HTTPOptions{
+		PollingInterval: 10 * time.Millisecond,
+		Backoff:         ConstantBackoff{Delay: time.Hour},
+		Logger:          logger,
+		OnEvent:         func(event Event) { events <- event },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer waitGroupTimeout(t, transport, time.Second)
+	defer transport.Disconnect(0)
+
+	select {
+	case data := <-received:
+		if data != "second" {
+			t.Errorf("received %q, want %q", data, "second")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message after panic not received")
+	}
+
+	entry, ok := logger.find("data handler panicked")
+	if !ok {
+		t.Fatal("panic not logged")
+	}
+	if entry.fields["panic"] != "boom" {
+		t.Errorf("logged panic %v, want boom", entry.fields["panic"])
+	}
+	if stack, _ := entry.fields["stack"].(string); !strings.Contains(stack, "TestDataHandlerPanic") {
+		t.Errorf("logged stack does not include the handler:\n%v", stack)
+	}
+
+	for event := (Event{}); event.Type != EventHandlerPanic; {
+		select {
+		case event = <-events:
+		case <-time.After(time.Second):
+			t.Fatal("no handler panic event")
+		}
+		var panicErr *HandlerPanicError
+		if event.Type == EventHandlerPanic && (!errors.As(event.Err, &panicErr) || panicErr.Value != "boom") {
+			t.Errorf("handler panic event error %v, want *HandlerPanicError", event.Err)
+		}
+	}
+	if state := transport.State(); state.BackoffDelay != 0 {
+		t.Errorf("backing off %v after a handler panic", state.BackoffDelay)
+	}
+}
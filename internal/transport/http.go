@@ -1,179 +1,1661 @@
 package transport
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"mime"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"git.sr.ht/~spc/go-log"
 	"github.com/redhatinsights/yggdrasil"
 	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// MoreAvailableHeader is the response header with which the server signals,
+// with the value "true", that more inbound messages are waiting than it
+// returned. The transport then polls again immediately instead of waiting for
+// the polling interval, until the backlog is drained.
+const MoreAvailableHeader = "X-More-Available"
+
 // HTTPResponse is a data structure representing an HTTP response received from
-// an HTTP request sent through the transport.
+// an HTTP request sent through the transport, returned from sends encoded by
+// HTTPOptions.Serializer. A JSON response body is stored as-is. Any other body
+// is stored as a JSON string: verbatim for text/* content types and
+// base64-encoded otherwise. An empty response body is omitted. Metadata holds
+// the response headers and any trailers, trailers taking precedence, with the
+// values of repeated fields joined with commas, or with newlines for
+// Set-Cookie. Header holds them too, keeping every value of repeated fields,
+// if HTTPOptions.ResponseHeader is set. Values looks up either.
 type HTTPResponse struct {
 	StatusCode int
-	Body       json.RawMessage
+	Body       json.RawMessage `json:",omitempty"`
 	Metadata   map[string]string
+	Header     http.Header `json:",omitempty"`
+}
+
+const (
+	// DefaultMaxBackoff is the default upper bound on the delay between polls
+	// after consecutive failures.
+	DefaultMaxBackoff = 5 * time.Minute
+
+	// DefaultBackoffMultiplier is the default factor by which the delay
+	// between polls grows after each consecutive failure.
+	DefaultBackoffMultiplier = 2.0
+
+	// DefaultPollRetryDelay is the default delay before the first quick
+	// retry of a failed poll.
+	DefaultPollRetryDelay = 250 * time.Millisecond
+
+	// DefaultTruncatedBodyRetries is the default number of times a poll
+	// whose response body was cut short is repeated right away.
+	DefaultTruncatedBodyRetries = 2
+
+	// DefaultLongPollTimeout is the default time the server is asked to hold
+	// a long-poll request open while waiting for data.
+	DefaultLongPollTimeout = 30 * time.Second
+
+	// DefaultExpectContinueThreshold is the default size, in bytes, above
+	// which outbound requests are sent with "Expect: 100-continue" when
+	// ExpectContinue is enabled.
+	DefaultExpectContinueThreshold = 64 * 1024
+)
+
+const (
+	// longPollGrace is added to the long-poll timeout to give the server
+	// time to respond once its wait has elapsed before the client gives up.
+	longPollGrace = 10 * time.Second
+
+	// longPollInterval is the delay between consecutive long-poll requests,
+	// which keeps a server that does not hold requests open from being
+	// flooded.
+	longPollInterval = 100 * time.Millisecond
+)
+
+// HTTPOptions holds optional settings for an HTTP transport. Zero-valued
+// fields are replaced with their defaults by NewHTTPTransportWithOptions.
+type HTTPOptions struct {
+	// PollingInterval is the delay between inbound polls while the server is
+	// responding successfully.
+	PollingInterval time.Duration
+
+	// ControlPollingInterval, if set, replaces PollingInterval for the
+	// control channel, which carries latency-sensitive commands.
+	ControlPollingInterval time.Duration
+
+	// DataPollingInterval, if set, replaces PollingInterval for the data
+	// channel.
+	DataPollingInterval time.Duration
+
+	// Backoff computes the delay between retries after consecutive failed
+	// polls or sends. Defaults to an ExponentialBackoff configured by
+	// MinBackoff, MaxBackoff and BackoffMultiplier, which starts at the
+	// polling interval and backs off with jitter as polling always has,
+	// rather than to a ConstantBackoff that would retry a failing server at
+	// the polling interval indefinitely. Set ConstantBackoff{Delay:
+	// PollingInterval} for that.
+	Backoff BackoffStrategy
+
+	// MinBackoff is the delay after the first failed poll. Defaults to
+	// PollingInterval.
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the delay between polls after consecutive failures.
+	// Defaults to DefaultMaxBackoff.
+	MaxBackoff time.Duration
+
+	// BackoffMultiplier is the factor by which the delay grows after each
+	// consecutive failure. Defaults to DefaultBackoffMultiplier.
+	BackoffMultiplier float64
+
+	// AdaptivePolling, if set, adapts the interval between polls of each
+	// channel to the rate at which it receives messages, in place of the
+	// fixed polling intervals. It has no effect with LongPoll set.
+	AdaptivePolling *AdaptivePolling
+
+	// BreakerThreshold enables the circuit breaker. After BreakerThreshold
+	// consecutive failed polls, on any channel, polling is paused for
+	// BreakerCooldown, after which a single poll probes the server. Polling
+	// resumes if the probe succeeds, and is paused again if it fails. Sends
+	// are not affected. Zero disables the circuit breaker.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long polling is paused once the circuit
+	// breaker opens. Defaults to DefaultBreakerCooldown.
+	BreakerCooldown time.Duration
+
+	// SendRetries is the number of times an outbound message is resent after
+	// a failure that a retry may fix, such as a network error or a 5xx
+	// response. Defaults to no retries, since a message whose response was
+	// lost may be delivered twice; set it only for idempotent messages.
+	SendRetries int
+
+	// PollRetries is the number of times a failed poll is retried right
+	// away, PollRetryDelay apart and doubling the delay each time, while
+	// the failure is one that a retry may fix, before the channel backs
+	// off. Polls are idempotent, so retrying them is always safe. Retries
+	// stop if the server asks to hold off with Retry-After. Defaults to no
+	// retries.
+	PollRetries int
+
+	// PollRetryDelay is the delay before the first retry of a failed poll.
+	// Defaults to DefaultPollRetryDelay.
+	PollRetryDelay time.Duration
+
+	// TruncatedBodyRetries is the number of times a poll whose response
+	// body was cut short by the connection ending or being reset is
+	// repeated right away, without waiting for PollRetryDelay or counting
+	// against PollRetries. Truncated messages are never passed to the data
+	// handler; a StreamHandler may have read part of one by the time the
+	// cut is found, and is then told so by a read error. Defaults to DefaultTruncatedBodyRetries; a negative value disables
+	// these retries.
+	TruncatedBodyRetries int
+
+	// LongPoll enables long polling. Inbound requests carry a
+	// "Prefer: wait=<seconds>" header, allowing the server to hold the request
+	// open until data is available, and are re-issued as soon as they
+	// complete.
+	LongPoll bool
+
+	// LongPollTimeout is how long the server is asked to wait for data before
+	// responding to a long-poll request. Defaults to DefaultLongPollTimeout.
+	LongPollTimeout time.Duration
+
+	// ServerSentEvents receives inbound messages from a server-sent event
+	// stream per channel, opened with a GET of the channel's "events" URL,
+	// instead of by polling. The data of each event is passed to the data
+	// handler. When a stream drops, it is reopened after the reconnection
+	// time the server last set, if any, with LastEventIDHeader set to the
+	// ID of the last event received; failing to open it is backed off like
+	// a failed poll. Events cannot be signed or encrypted, so with
+	// VerifyKeys set they are dropped. Unless set, ClientOptions.Timeout is
+	// disabled, since it would cut the streams short; streams are instead
+	// reopened if they send nothing, not even a comment, for
+	// EventStreamIdleTimeout. Outbound messages are sent as usual.
+	ServerSentEvents bool
+
+	// EventStreamIdleTimeout is how long an event stream may be idle before
+	// it is reopened. Defaults to DefaultEventStreamIdleTimeout; a negative
+	// value disables the timeout.
+	EventStreamIdleTimeout time.Duration
+
+	// MinNextPoll and MaxNextPoll bound the delay before the next poll that
+	// the server may hint with NextPollHeader. They default to
+	// DefaultMinNextPoll and DefaultMaxNextPoll.
+	MinNextPoll time.Duration
+	MaxNextPoll time.Duration
+
+	// Compress enables gzip compression of outbound request bodies larger
+	// than CompressThreshold bytes.
+	Compress bool
+
+	// CompressThreshold is the body size, in bytes, above which outbound
+	// requests are compressed. Defaults to DefaultCompressThreshold.
+	CompressThreshold int
+
+	// ExpectContinue sends outbound requests whose body, once compressed or
+	// encrypted, is larger than ExpectContinueThreshold bytes with an
+	// "Expect: 100-continue" header. The body is then only transmitted once
+	// the server accepts the request, so a rejection, such as a 401 or 413
+	// response, does not waste the bandwidth of sending it. The wait for
+	// the server is bounded by ClientOptions.ExpectContinueTimeout.
+	ExpectContinue bool
+
+	// ExpectContinueThreshold is the body size, in bytes, above which
+	// outbound requests wait for the server to accept them. Defaults to
+	// DefaultExpectContinueThreshold.
+	ExpectContinueThreshold int
+
+	// MaxMessageSize is the maximum size, in bytes, of a message passed to
+	// SendData. Larger messages are rejected with an error wrapping
+	// ErrMessageTooLarge before they are queued or sent. Defaults to
+	// DefaultMaxMessageSize; a negative value removes the limit.
+	MaxMessageSize int
+
+	// MaxResponseSize is the maximum size, in bytes, of a response body,
+	// after decompression. Larger responses to polls and sends are
+	// discarded with an error wrapping ErrResponseTooLarge. Defaults to
+	// DefaultMaxResponseSize.
+	MaxResponseSize int64
+
+	// QueueDir enables the persistent outbound queue. Each outbound message
+	// is written to QueueDir before it is sent and removed once the server
+	// acknowledges it with a 2xx response, or rejects it with a 4xx response
+	// that a retry cannot fix. Messages left over from a previous run, or that could
+	// not be delivered, are resent in order every polling interval while the
+	// transport is connected. A send that fails in a way a retry may fix
+	// then returns no response and no error, since the message is left in
+	// the queue rather than lost, and sending it again would duplicate it.
+	QueueDir string
+
+	// StrictTLS stops the transport from ever sending a plain HTTP request
+	// once it has been configured for TLS, whether by the TLS config given
+	// to the constructor or by ReloadTLSConfig, so that a misconfiguration
+	// or an attacker cannot silently downgrade it. Such requests fail with a
+	// permanent error wrapping ErrTLSDowngrade, logged as an error and
+	// reported as an EventTLSDowngrade.
+	StrictTLS bool
+
+	// AckMessages makes the transport report the outcome of handling each
+	// inbound message to the server, so that the server can redeliver the
+	// messages the data handler fails on. Once the handler returns, the
+	// transport posts to the "ack" URL of the channel the message was
+	// received on if the handler succeeded, and to its "nack" URL if it
+	// returned an error or panicked, identifying the message by the
+	// RequestIDHeader the server sent it with, or by their ID for
+	// server-sent events. Messages without one are not acknowledged.
+	AckMessages bool
+
+	// ClientOptions configures the underlying HTTP client, including its
+	// request timeouts. In long-polling mode the overall and response header
+	// timeouts are raised, if necessary, to outlast the long-poll wait. Its
+	// RequestInterceptors apply to every request the transport sends; an
+	// interceptor aborting a request with an error wrapping
+	// ErrPermanentTransport fails it without retries.
+	ClientOptions internalhttp.ClientOptions
+
+	// MaxConcurrentSends limits the number of outbound requests in flight
+	// at once, including those sending queued messages. Further sends wait
+	// for a request to complete, or fail with ErrSendLimitReached if
+	// FailFastSends is set. Polls are not counted. Zero means no limit.
+	MaxConcurrentSends int
+
+	// FailFastSends makes sends over the MaxConcurrentSends limit fail
+	// instead of waiting.
+	FailFastSends bool
+
+	// IdempotencyKeys sends outbound messages with an Idempotency-Key
+	// header, so that the server can discard the duplicates a retry may
+	// create. The key is taken from the IdempotencyKeyHeader field of the
+	// message metadata, if set, and is otherwise generated for the message.
+	// It is the same for every attempt to send the message, including
+	// retries, and deliveries from the outbound queue after a restart.
+	IdempotencyKeys bool
+
+	// OrderedSends sends the messages to each channel one at a time, in the
+	// order SendData was called, so that concurrent sends cannot reach the
+	// server out of order. A send, including its retries, waits for the
+	// previous send to the channel to complete. Sends to different channels
+	// remain concurrent.
+	OrderedSends bool
+
+	// DedupWindow enables deduplication of inbound messages. The IDs of the
+	// last DedupWindow messages received are remembered, and a message whose
+	// message_id was seen within DedupTTL is dropped instead of being passed
+	// to the data handler. Zero disables deduplication.
+	DedupWindow int
+
+	// DedupTTL is how long a message ID is remembered. Defaults to
+	// DefaultDedupTTL.
+	DedupTTL time.Duration
+
+	// StreamHandler, if set, is passed inbound messages instead of the data
+	// handler given to the constructor, as a reader over the response body,
+	// so that large messages need not be held in memory. Messages that
+	// must be read whole first, because they are encrypted, their signature
+	// is verified or DedupWindow or Validate is set, are read into memory
+	// and passed as a reader over the buffered body. Streamed messages are
+	// handled on the polling goroutine, whatever ReceiveWorkers is set to,
+	// since the body must be read before the next poll. Reading fails with
+	// an error wrapping ErrResponseTooLarge past MaxResponseSize bytes, and
+	// with one wrapping ErrTruncatedBody if the body is cut short, in which
+	// case the handler must discard what it has read, since the message is
+	// fetched again. A message that could not be read in full is nacked
+	// with AckMessages set, even if the handler returns nil. The reader must
+	// not be used once the handler returns.
+	StreamHandler DataReceiveStreamHandlerFunc
+
+	// ContextHandler, if set, is passed inbound messages instead of the data
+	// handler given to the constructor, with a context carrying the request
+	// ID the server sent with each, as returned by RequestIDFromContext, and
+	// the span of its handling. StreamHandler takes precedence over it.
+	ContextHandler DataReceiveContextHandlerFunc
+
+	// Validate, if set, checks each inbound message, for example against a
+	// JSON schema, before it is passed to the data handler. Messages it
+	// rejects are logged, counted and dropped, ReceiveData returning an
+	// error wrapping ErrInvalidMessage. Off by default.
+	Validate ValidateFunc
+
+	// ReceiveWorkers is the number of goroutines inbound messages are
+	// passed to the data handler on, so that a slow handler does not hold up
+	// polling. Messages may then be handled out of order. Zero handles each
+	// message on the polling goroutine of its channel.
+	ReceiveWorkers int
+
+	// ReceiveQueueSize is the number of inbound messages that may wait for
+	// a free worker. Polling pauses while the queue is full, rather than
+	// dropping messages. Defaults to ReceiveWorkers.
+	ReceiveQueueSize int
+
+	// RoundTripper, if set, sends the transport's HTTP requests instead of
+	// a transport built from the TLS config and ClientOptions, of which only
+	// the overall request timeout, redirect policy and credentials then
+	// apply. TLS settings and their reloading are left to RoundTripper.
+	RoundTripper http.RoundTripper
+
+	// WriteServer is the server, given as host[:port], that messages are
+	// sent to, for deployments that ingest messages separately from
+	// delivering them. Polls and acknowledgements still go to the server
+	// the transport was created with. Defaults to that server.
+	WriteServer string
+
+	// WriteTLSConfig, if set, is the TLS config of the connections to
+	// WriteServer, which are then made over HTTPS by a client of their own.
+	// ReloadTLSConfig does not apply to it. Without it, sends share the TLS
+	// config of the polls. It is ignored if RoundTripper is set.
+	WriteTLSConfig *tls.Config
+
+	// Endpoints are servers, equivalent to the one the transport is created
+	// with, that requests are spread across in proportion to their weights.
+	// After EndpointFailureThreshold consecutive requests to an endpoint
+	// fail or are answered with a server error, it is taken out of
+	// rotation, and a single request probes it every EndpointProbeInterval
+	// until one succeeds. Messages are still sent to WriteServer alone, if
+	// it is set to another server.
+	Endpoints []Endpoint
+
+	// EndpointFailureThreshold is the number of consecutive failed requests
+	// after which an endpoint is taken out of rotation. Defaults to
+	// DefaultEndpointFailureThreshold.
+	EndpointFailureThreshold int
+
+	// EndpointProbeInterval is how often an endpoint out of rotation is
+	// probed. Defaults to DefaultEndpointProbeInterval.
+	EndpointProbeInterval time.Duration
+
+	// Metrics, if set, records the activity of the transport.
+	Metrics *HTTPMetrics
+
+	// QueueSize is the maximum number of messages held in the outbound
+	// queue. The oldest message not being sent is dropped when the queue is
+	// full, and sends fail with ErrQueueFull if every message is. Defaults
+	// to DefaultQueueSize.
+	QueueSize int
+
+	// Logger receives the transport's log messages, with details such as
+	// the channel, URL and status code as structured fields. Defaults to a
+	// logger writing to the go-log package logger. Message bodies are only
+	// ever traced to the go-log logger, except in dry-run mode or if
+	// LogBodies is set.
+	Logger Logger
+
+	// LogBodies logs the bodies of the messages sent and received, and of
+	// the server's responses to sends, to Logger at debug level, after
+	// passing them through RedactBody.
+	LogBodies bool
+
+	// RedactBody masks secrets in the bodies logged when LogBodies is set.
+	// Defaults to RedactFields(DefaultRedactedFields...).
+	RedactBody RedactFunc
+
+	// Serializer encodes the HTTPResponse envelope returned from sends.
+	// Parse it with UnmarshalResponse to decode it consistently. Defaults
+	// to JSONSerializer; GobSerializer encodes it more compactly.
+	Serializer Serializer
+
+	// ResponseHeader fills in the Header of the HTTPResponse envelope,
+	// which, unlike Metadata, keeps every value of repeated header fields.
+	// Off by default, so the envelope stays as compact as consumers that
+	// read only Metadata expect.
+	ResponseHeader bool
+
+	// TracerProvider creates the OpenTelemetry spans recorded around
+	// connections, sends and polls. Trace context is propagated to the
+	// server in W3C traceparent headers. Defaults to the global tracer
+	// provider, which records nothing unless the application configures it.
+	TracerProvider trace.TracerProvider
+
+	// Channels lists the channels the server exposes besides the control
+	// and data channels. Each is polled every PollingInterval, and may be
+	// sent to. Sending to, or receiving on, any other channel fails with
+	// ErrUnknownChannel.
+	Channels []Channel
+
+	// PathPrefix is prepended to the path of every URL the transport
+	// requests. Defaults to yggdrasil.PathPrefix as set when the transport
+	// is created; set it to "/" for URLs without a prefix.
+	PathPrefix string
+
+	// Headers are added to every request sent to the server, polls and
+	// sends alike. They do not replace the Content-Type and User-Agent
+	// headers set by the transport, nor headers taken from the message,
+	// such as RequestIDHeader.
+	Headers map[string]string
+
+	// SigningKey, if set, signs the body of every outbound message, before
+	// it is encrypted. The signature is sent in SignatureHeader.
+	SigningKey ed25519.PrivateKey
+
+	// VerifyKeys, if set, are the public keys against which the signature
+	// in SignatureHeader of every inbound message is verified. Unsigned
+	// messages, and messages whose signature matches none of the keys, are
+	// dropped. Keys can be rotated with ReloadSigningKeys.
+	VerifyKeys []ed25519.PublicKey
+
+	// ReplayWindow bounds the difference between the signing time of a
+	// verified inbound message and the time it is received. Messages outside
+	// of the window, or whose nonce was already seen within it, are dropped
+	// as replays. It applies only while VerifyKeys are set. Defaults to
+	// DefaultReplayWindow; a negative value disables replay protection.
+	ReplayWindow time.Duration
+
+	// ReplayNonces is the number of inbound message nonces remembered to
+	// detect replays. Once full, the oldest nonce is forgotten, so it should
+	// exceed the number of messages received within twice the replay
+	// window. Defaults to DefaultReplayNonces.
+	ReplayNonces int
+
+	// MaxClockSkew enables compensating for a wrong local clock in signature
+	// timestamps. The offset of the server's clock is learned from the Date
+	// header of its responses, bounded by MaxClockSkew, and applied when
+	// signing outbound messages and when checking inbound ones against
+	// ReplayWindow. Disabled if zero.
+	MaxClockSkew time.Duration
+
+	// EncryptionKeys are the AES keys, 16, 24 or 32 bytes long and by key
+	// ID, with which message bodies are encrypted end to end. Inbound
+	// messages are decrypted with the key named in EncryptionKeyIDHeader;
+	// those that cannot be decrypted are dropped.
+	EncryptionKeys map[string][]byte
+
+	// EncryptionKeyID, if set, is the ID of the key in EncryptionKeys with
+	// which outbound data channel messages are encrypted. Rotate keys by
+	// adding a key and switching to it, with ReloadEncryptionKeys, while
+	// keeping the old key for decryption.
+	EncryptionKeyID string
+
+	// OnEvent, if set, is called with the lifecycle events of the
+	// transport: connecting and disconnecting, polls starting to fail and
+	// recovering, TLS reloads and data handler panics. It is called on a
+	// separate goroutine, one event at a time and in order, so it may block
+	// without holding up the transport.
+	OnEvent EventHandlerFunc
+
+	// DryRun stops the transport from talking to the server, for exercising
+	// an integration locally. Outbound messages are logged at info level
+	// with the URL, headers and body of the request that would have been
+	// sent, and answered with a synthetic 200 response. Connect does not
+	// start polling, and the outbound queue is not used.
+	DryRun bool
+
+	// ManualPoll stops Connect from starting the goroutines that poll each
+	// channel, leaving the embedder to receive messages by calling Poll.
+	ManualPoll bool
+
+	// SkipConnectProbe makes Connect start polling without first checking
+	// that the server can be reached, leaving failures to be retried in the
+	// background.
+	SkipConnectProbe bool
+
+	// MaxStartupJitter, if positive, makes Connect wait a random delay of up
+	// to MaxStartupJitter before its first request, so that a fleet of
+	// clients restarting at once spreads out its initial load on the
+	// server. Zero connects right away.
+	MaxStartupJitter time.Duration
 }
 
 // HTTP is a Transporter that sends and receives data and control
 // messages by sending HTTP requests to a URL.
 type HTTP struct {
-	clientID        string
-	client          *internalhttp.Client
-	server          string
-	dataHandler     DataReceiveHandlerFunc
-	pollingInterval time.Duration
-	disconnected    atomic.Value
-	userAgent       string
-	isTLS           atomic.Value
+	clientID     string
+	client       *internalhttp.Client
+	host         string
+	port         string
+	pathPrefix   string
+	channels     []Channel
+	dataHandler  DataReceiveHandlerFunc
+	opts         HTTPOptions
+	disconnected atomic.Value
+	userAgent    string
+	isTLS        *atomic.Value
+	// tlsConfigured is set once the transport has been configured for TLS,
+	// and never cleared. Like isTLS, it is shared with the transports
+	// created by ForClient.
+	tlsConfigured *atomic.Value
+	// closed is set by Close, after which the transport can no longer be
+	// connected.
+	closed atomic.Value
+	clock  clock
+	queue  *diskQueue
+	state  httpState
+	stats  httpStats
+	tracer trace.Tracer
+
+	// writeClient, writeHost and writePort are those of the server messages
+	// are sent to, the same as client, host and port unless WriteServer or
+	// WriteTLSConfig is set.
+	writeClient *internalhttp.Client
+	writeHost   string
+	writePort   string
+
+	// sendSlots holds a token for each outbound request in flight, if
+	// MaxConcurrentSends is set.
+	sendSlots chan struct{}
+
+	// order serializes the sends to each channel, if OrderedSends is set.
+	order *sendOrder
+
+	// inflight counts calls to SendData that have not returned yet.
+	inflight int32
+
+	// dedup remembers the IDs of recently received messages, if
+	// DedupWindow is set.
+	dedup *dedupCache
+
+	// breaker pauses polling while the server is failing, if
+	// BreakerThreshold is set.
+	breaker *circuitBreaker
+
+	// endpoints spreads requests across the server and Endpoints, if
+	// Endpoints is set.
+	endpoints *endpointPool
+
+	// events delivers lifecycle events to OnEvent, if set.
+	events *eventDispatcher
+
+	// receivePool passes inbound messages to the data handler, if
+	// ReceiveWorkers is set.
+	receivePool *receivePool
+
+	// replay rejects replayed inbound messages, unless ReplayWindow is
+	// negative.
+	replay *replayGuard
+
+	// signingKeys holds the current *signingKeys, replaced by
+	// ReloadSigningKeys.
+	signingKeys atomic.Value
+
+	// encryptionKeys holds the current *encryptionKeys, replaced by
+	// ReloadEncryptionKeys.
+	encryptionKeys atomic.Value
+
+	// holdOffMu guards holdOffUntil, the time before which the server asked
+	// not to receive requests through a Retry-After header.
+	holdOffMu    sync.Mutex
+	holdOffUntil time.Time
+
+	// clockOffsetMu guards clockOffset, the offset of the server's clock
+	// from the local clock, if MaxClockSkew is set.
+	clockOffsetMu sync.Mutex
+	clockOffset   time.Duration
+
+	// mu guards cancel, which stops the polling goroutines started by the
+	// most recent call to Connect, and polling, which counts them and the
+	// calls to Poll in progress.
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	polling *sync.WaitGroup
+	wg      sync.WaitGroup
 }
 
+// NewHTTPTransport creates a transport that polls server, given as
+// host[:port], every pollingInterval, using the default options for
+// everything else. Without a port, the default port of the scheme is used.
 func NewHTTPTransport(clientID string, server string, tlsConfig *tls.Config, userAgent string, pollingInterval time.Duration, dataRecvFunc DataReceiveHandlerFunc) (*HTTP, error) {
+	return NewHTTPTransportWithOptions(clientID, server, tlsConfig, userAgent, dataRecvFunc, HTTPOptions{
+		PollingInterval: pollingInterval,
+	})
+}
+
+// NewHTTPTransportWithOptions creates a transport configured by opts. A nil
+// tlsConfig creates a transport that sends plain HTTP requests. Unless
+// opts.MaxMessageSize says otherwise, outbound messages are limited to
+// DefaultMaxMessageSize, 1 MiB.
+func NewHTTPTransportWithOptions(clientID string, server string, tlsConfig *tls.Config, userAgent string, dataRecvFunc DataReceiveHandlerFunc, opts HTTPOptions) (*HTTP, error) {
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = opts.PollingInterval
+	}
+	if opts.ControlPollingInterval == 0 {
+		opts.ControlPollingInterval = opts.PollingInterval
+	}
+	if opts.DataPollingInterval == 0 {
+		opts.DataPollingInterval = opts.PollingInterval
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.PollRetryDelay == 0 {
+		opts.PollRetryDelay = DefaultPollRetryDelay
+	}
+	if opts.TruncatedBodyRetries == 0 {
+		opts.TruncatedBodyRetries = DefaultTruncatedBodyRetries
+	}
+	if opts.BackoffMultiplier == 0 {
+		opts.BackoffMultiplier = DefaultBackoffMultiplier
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff{
+			Min:        opts.MinBackoff,
+			Max:        opts.MaxBackoff,
+			Multiplier: opts.BackoffMultiplier,
+		}
+	}
+	if opts.BreakerCooldown == 0 {
+		opts.BreakerCooldown = DefaultBreakerCooldown
+	}
+	if opts.EndpointFailureThreshold <= 0 {
+		opts.EndpointFailureThreshold = DefaultEndpointFailureThreshold
+	}
+	if opts.EndpointProbeInterval <= 0 {
+		opts.EndpointProbeInterval = DefaultEndpointProbeInterval
+	}
+	if opts.LongPollTimeout == 0 {
+		opts.LongPollTimeout = DefaultLongPollTimeout
+	}
+	if opts.MinNextPoll == 0 {
+		opts.MinNextPoll = DefaultMinNextPoll
+	}
+	if opts.MaxNextPoll == 0 {
+		opts.MaxNextPoll = DefaultMaxNextPoll
+	}
+	if opts.CompressThreshold == 0 {
+		opts.CompressThreshold = DefaultCompressThreshold
+	}
+	if opts.ExpectContinueThreshold == 0 {
+		opts.ExpectContinueThreshold = DefaultExpectContinueThreshold
+	}
+	if opts.DedupTTL == 0 {
+		opts.DedupTTL = DefaultDedupTTL
+	}
+	if opts.PathPrefix == "" {
+		opts.PathPrefix = yggdrasil.PathPrefix
+	}
+	if opts.ReplayWindow == 0 {
+		opts.ReplayWindow = DefaultReplayWindow
+	}
+	if opts.ReplayNonces == 0 {
+		opts.ReplayNonces = DefaultReplayNonces
+	}
+	if opts.MaxMessageSize == 0 {
+		opts.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if opts.MaxResponseSize == 0 {
+		opts.MaxResponseSize = DefaultMaxResponseSize
+	}
+	if opts.QueueSize == 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Logger == nil {
+		opts.Logger = goLogger{}
+	}
+	if opts.RedactBody == nil {
+		opts.RedactBody = RedactFields(DefaultRedactedFields...)
+	}
+	if opts.Serializer == nil {
+		opts.Serializer = JSONSerializer{}
+	}
+	if opts.ServerSentEvents {
+		if opts.ClientOptions.Timeout == 0 {
+			opts.ClientOptions.Timeout = -1
+		}
+		if opts.EventStreamIdleTimeout == 0 {
+			opts.EventStreamIdleTimeout = DefaultEventStreamIdleTimeout
+		}
+	}
+	if opts.LongPoll {
+		wait := opts.LongPollTimeout + longPollGrace
+		opts.ClientOptions.Timeout = atLeast(opts.ClientOptions.Timeout, internalhttp.DefaultTimeout, wait)
+		opts.ClientOptions.ResponseHeaderTimeout = atLeast(opts.ClientOptions.ResponseHeaderTimeout, internalhttp.DefaultResponseHeaderTimeout, wait)
+	}
+
+	var client *internalhttp.Client
+	if opts.RoundTripper != nil {
+		client = internalhttp.NewHTTPClientWithTransport(opts.RoundTripper, userAgent, opts.ClientOptions)
+	} else {
+		client = internalhttp.NewHTTPClientWithOptions(tlsConfig, userAgent, opts.ClientOptions)
+	}
+
+	if err := checkClientID(clientID); err != nil {
+		return nil, err
+	}
+	host, port, err := splitServer(server, tlsConfig != nil)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints *endpointPool
+	if len(opts.Endpoints) > 0 {
+		endpoints, err = newEndpointPool(host, port, tlsConfig != nil, opts.Endpoints, opts.EndpointFailureThreshold, opts.EndpointProbeInterval, opts.Logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writeHost, writePort := host, port
+	if opts.WriteServer != "" {
+		writeHost, writePort, err = splitServer(opts.WriteServer, tlsConfig != nil || opts.WriteTLSConfig != nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writeClient := client
+	if opts.WriteTLSConfig != nil && opts.RoundTripper == nil {
+		writeClient = internalhttp.NewHTTPClientWithOptions(opts.WriteTLSConfig, userAgent, opts.ClientOptions)
+	}
+	channels, err := newChannels(opts.Channels)
+	if err != nil {
+		return nil, err
+	}
+
+	var sendSlots chan struct{}
+	if opts.MaxConcurrentSends > 0 {
+		sendSlots = make(chan struct{}, opts.MaxConcurrentSends)
+	}
+
+	var order *sendOrder
+	if opts.OrderedSends {
+		order = newSendOrder()
+	}
+
+	var dedup *dedupCache
+	if opts.DedupWindow > 0 {
+		dedup = newDedupCache(opts.DedupWindow, opts.DedupTTL)
+	}
+
+	var breaker *circuitBreaker
+	if opts.BreakerThreshold > 0 {
+		breaker = newCircuitBreaker(opts.BreakerThreshold, opts.BreakerCooldown, opts.Logger)
+	}
+
+	var events *eventDispatcher
+	if opts.OnEvent != nil {
+		events = newEventDispatcher(opts.OnEvent)
+	}
+
+	var receivePool *receivePool
+	if opts.ReceiveWorkers > 0 {
+		if opts.ReceiveQueueSize <= 0 {
+			opts.ReceiveQueueSize = opts.ReceiveWorkers
+		}
+		receivePool = newReceivePool(opts.ReceiveWorkers, opts.ReceiveQueueSize, opts.Logger)
+	}
+
+	var replay *replayGuard
+	if opts.ReplayWindow > 0 {
+		replay = newReplayGuard(opts.ReplayWindow, opts.ReplayNonces)
+	}
+
+	keys, err := newEncryptionKeys(opts.EncryptionKeyID, opts.EncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Opening the queue creates its directory and clears out partially
+	// written messages, so it is done only once everything else is known
+	// to be valid.
+	var queue *diskQueue
+	if opts.QueueDir != "" && !opts.DryRun {
+		queue, err = openDiskQueue(opts.QueueDir, opts.QueueSize, opts.Logger)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open outbound queue: %w", err)
+		}
+	}
+
 	disconnected := atomic.Value{}
 	disconnected.Store(false)
-	isTls := atomic.Value{}
+	closed := atomic.Value{}
+	closed.Store(false)
+	isTls := &atomic.Value{}
 	isTls.Store(tlsConfig != nil)
-	return &HTTP{
-		clientID:        clientID,
-		client:          internalhttp.NewHTTPClient(tlsConfig.Clone(), userAgent),
-		dataHandler:     dataRecvFunc,
-		pollingInterval: pollingInterval,
-		disconnected:    disconnected,
-		server:          server,
-		userAgent:       userAgent,
-		isTLS:           isTls,
-	}, nil
+	tlsConfigured := &atomic.Value{}
+	tlsConfigured.Store(tlsConfig != nil)
+	t := &HTTP{
+		clientID:      clientID,
+		client:        client,
+		writeClient:   writeClient,
+		dataHandler:   dataRecvFunc,
+		opts:          opts,
+		disconnected:  disconnected,
+		closed:        closed,
+		host:          host,
+		port:          port,
+		writeHost:     writeHost,
+		writePort:     writePort,
+		pathPrefix:    opts.PathPrefix,
+		channels:      channels,
+		userAgent:     userAgent,
+		isTLS:         isTls,
+		tlsConfigured: tlsConfigured,
+		clock:         realClock{},
+		queue:         queue,
+		tracer:        newTracer(opts.TracerProvider),
+		sendSlots:     sendSlots,
+		order:         order,
+		dedup:         dedup,
+		breaker:       breaker,
+		endpoints:     endpoints,
+		events:        events,
+		replay:        replay,
+		receivePool:   receivePool,
+	}
+	t.ReloadSigningKeys(opts.SigningKey, opts.VerifyKeys)
+	t.encryptionKeys.Store(keys)
+	return t, nil
+}
+
+// atLeast returns the client timeout d, whose zero value means def, raised to
+// min if it is shorter. A disabled (negative) timeout is left alone.
+func atLeast(d, def, min time.Duration) time.Duration {
+	if d == 0 {
+		d = def
+	}
+	if d > 0 && d < min {
+		return min
+	}
+	return d
 }
 
+// Connect starts polling the channels of the transport for inbound messages.
+// Each call stops any polling goroutines left over from a previous Connect
+// before starting new ones, so the transport can be reconnected after a
+// Disconnect.
+//
+// Unless SkipConnectProbe is set, Connect first polls the control channel
+// once, without long polling, and returns its error, if any, leaving the
+// transport disconnected. Whether the error is worth retrying Connect for,
+// as a DNS or TLS handshake failure is but the server rejecting the client's
+// credentials is not, is told by ErrTransientTransport and
+// ErrPermanentTransport. Any MaxStartupJitter delay is waited before the
+// probe.
 func (t *HTTP) Connect() error {
+	ctx, span := t.tracer.Start(context.Background(), "Connect")
+	err := t.connect(ctx)
+	endSpan(span, err)
+	return err
+}
+
+// Reconnect stops the polling goroutines, clears the backoff and circuit
+// breaker state built up by failures, and connects again as Connect does,
+// emitting EventDisconnected and then EventConnected. It restores polling
+// after a permanent failure, such as a revoked certificate that has since
+// been re-issued, without creating a new transport. It does not wait for the
+// old goroutines to exit, so it may be called from the data handler, and it
+// is safe to call concurrently with any other method.
+func (t *HTTP) Reconnect() error {
+	ctx, span := t.tracer.Start(context.Background(), "Reconnect")
+	t.stopPolling()
+	t.opts.Backoff.Reset()
+
+	err := t.connect(ctx)
+	endSpan(span, err)
+	return err
+}
+
+func (t *HTTP) connect(ctx context.Context) error {
+	if t.closed.Load().(bool) {
+		return permanentError(ErrTransportClosed)
+	}
+	if !t.opts.DryRun && t.opts.MaxStartupJitter > 0 {
+		delay := fullJitter(t.opts.MaxStartupJitter)
+		t.opts.Logger.Debug("delaying connection", "delay", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.clock.After(delay):
+		}
+	}
+
+	if !t.opts.DryRun && !t.opts.SkipConnectProbe {
+		if _, err := t.fetch(ctx, "control", false); err != nil {
+			err = fmt.Errorf("cannot connect to server: %w", err)
+			t.state.recordError(err)
+			t.stopPolling()
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+	pollCtx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
 	t.disconnected.Store(false)
-	go func() {
-		for {
-			if t.disconnected.Load().(bool) {
-				return
-			}
-			resp, err := t.client.Get(t.getUrl("in", "control"))
-			if err != nil {
-				log.Tracef("cannot get HTTP request: %v", err)
-			}
-			if resp != nil {
-				data, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					log.Errorf("cannot read response body: %v", err)
-					continue
+	t.state.reset()
+	t.breaker.reset()
+	t.events.emit(Event{Type: EventConnected, Time: t.clock.Now()})
+
+	if t.opts.DryRun {
+		return nil
+	}
+
+	polling := &sync.WaitGroup{}
+	t.polling = polling
+	if t.opts.ManualPoll {
+		// Hold the receive workers until disconnecting, however long it is
+		// between calls to Poll.
+		polling.Add(1)
+		go func() {
+			<-pollCtx.Done()
+			polling.Done()
+		}()
+	} else {
+		polling.Add(len(t.channels))
+		t.wg.Add(len(t.channels))
+		for _, channel := range t.channels {
+			go func(channel string) {
+				defer polling.Done()
+				if t.opts.ServerSentEvents {
+					t.subscribe(pollCtx, channel)
+				} else {
+					t.poll(pollCtx, channel)
 				}
-				_ = t.ReceiveData(data, "control")
-				resp.Body.Close()
-			}
-			time.Sleep(t.pollingInterval)
+			}(string(channel))
 		}
-	}()
+	}
+	t.receivePool.start(&t.wg, polling)
+	if t.queue != nil {
+		t.wg.Add(1)
+		go t.drain(pollCtx)
+	}
 
-	go func() {
-		for {
-			if t.disconnected.Load().(bool) {
+	return nil
+}
+
+// stopPolling stops the polling goroutines of a previous Connect, if any,
+// without waiting for them to exit.
+func (t *HTTP) stopPolling() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+		t.events.emit(Event{Type: EventDisconnected, Time: t.clock.Now()})
+	}
+}
+
+// poll repeatedly fetches messages from the inbound URL of channel until ctx
+// is cancelled, backing off while polls are failing and pausing while the
+// circuit breaker is open.
+func (t *HTTP) poll(ctx context.Context, channel string) {
+	defer t.wg.Done()
+
+	var adaptive *adaptiveInterval
+	if t.opts.AdaptivePolling != nil {
+		adaptive = newAdaptiveInterval(*t.opts.AdaptivePolling, t.pollingInterval(channel))
+	}
+	var failures int
+	for {
+		if ok, wait := t.breaker.allow(t.clock.Now(), t.pollingInterval(channel)); !ok {
+			select {
+			case <-ctx.Done():
 				return
+			case <-t.clock.After(wait):
 			}
-			resp, err := t.client.Get(t.getUrl("in", "data"))
-			if err != nil {
-				log.Tracef("cannot get HTTP request: %v", err)
-			}
+			continue
+		}
 
-			if resp != nil {
-				data, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					log.Errorf("cannot read response body: %v", err)
-					continue
-				}
-				_ = t.ReceiveData(data, "data")
-				resp.Body.Close()
+		var delay time.Duration
+		spanCtx, span := t.tracer.Start(ctx, "poll", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(channelKey.String(channel)))
+		result, err := t.fetchRetrying(spanCtx, channel)
+		endSpan(span, err)
+		if ctx.Err() != nil {
+			return
+		}
+		t.breaker.record(t.clock.Now(), err)
+		if err != nil {
+			failures++
+			delay = t.opts.Backoff.NextDelay(failures)
+			t.opts.Logger.Debug("cannot poll channel, backing off", "channel", channel, "attempt", failures, "delay", delay, "error", err)
+		} else {
+			failures = 0
+			t.opts.Backoff.Reset()
+			switch {
+			case result.hinted:
+				delay = t.clampNextPoll(result.nextPoll)
+				t.opts.Logger.Debug("server hinted next poll", "channel", channel, "hint", result.nextPoll, "delay", delay)
+			case result.more:
+				// Fetch the next page of a backlog right away.
+				delay = 0
+			case t.opts.LongPoll:
+				delay = longPollInterval
+			case adaptive != nil:
+				delay = adaptive.next(result.received)
+				t.opts.Logger.Debug("adapted polling interval", "channel", channel, "received", result.received, "delay", delay)
+			default:
+				delay = t.pollingInterval(channel)
 			}
-			time.Sleep(t.pollingInterval)
 		}
-	}()
+		if remaining := t.holdOffRemaining(); remaining > delay {
+			delay = remaining
+		}
+		t.reportPoll(channel, failures, err, delay)
 
-	return nil
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.clock.After(delay):
+		}
+	}
+}
+
+// fetchRetrying fetches channel for the polling loop, retrying up to
+// PollRetries times after a failure that a retry may fix, and up to
+// TruncatedBodyRetries times at once after a response body was cut short.
+func (t *HTTP) fetchRetrying(ctx context.Context, channel string) (pollResult, error) {
+	delay := t.opts.PollRetryDelay
+	var attempt, truncated int
+	for {
+		result, err := t.fetch(ctx, channel, t.opts.LongPoll)
+		if !retryable(err) || ctx.Err() != nil || t.holdOffRemaining() > 0 {
+			return result, err
+		}
+		// The message cut short is still waiting on the server, so there
+		// is no reason to wait before fetching it again.
+		if errors.Is(err, ErrTruncatedBody) && truncated < t.opts.TruncatedBodyRetries {
+			truncated++
+			t.opts.Logger.Debug("response body truncated, polling again", "channel", channel, "attempt", truncated, "error", err)
+			continue
+		}
+		attempt++
+		if attempt > t.opts.PollRetries {
+			return result, err
+		}
+		t.opts.Logger.Debug("cannot poll channel, retrying", "channel", channel, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-t.clock.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// reportPoll records the outcome of a poll of channel, after the given number
+// of consecutive failures and before waiting delay, and emits an event if it
+// changes whether the transport is reaching the server.
+func (t *HTTP) reportPoll(channel string, failures int, err error, delay time.Duration) {
+	t.observePoll(channel, failures)
+	if t.state.recordPoll(channel, t.clock.Now(), err, delay) {
+		if err != nil {
+			t.events.emit(Event{Type: EventReconnecting, Time: t.clock.Now(), Err: err})
+		} else {
+			t.events.emit(Event{Type: EventConnected, Time: t.clock.Now()})
+		}
+	}
+}
+
+// Poll fetches inbound messages from channel once and dispatches any it
+// receives, like a poll by the polling goroutines, but without waiting for a
+// long poll. It reports whether a message was received. The transport must be
+// connected; with ManualPoll set, calling Poll is the only way messages are
+// received.
+func (t *HTTP) Poll(ctx context.Context, channel string) (bool, error) {
+	if err := t.checkChannel(channel); err != nil {
+		return false, err
+	}
+	t.mu.Lock()
+	if t.cancel == nil || t.opts.DryRun {
+		t.mu.Unlock()
+		return false, fmt.Errorf("cannot poll %v channel: not connected", channel)
+	}
+	polling := t.polling
+	polling.Add(1)
+	t.mu.Unlock()
+	defer polling.Done()
+
+	ctx, span := t.tracer.Start(ctx, "poll", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(channelKey.String(channel)))
+	result, err := t.fetch(ctx, channel, false)
+	endSpan(span, err)
+	if err != nil {
+		t.state.recordError(err)
+		return false, err
+	}
+	t.state.recordPoll(channel, t.clock.Now(), nil, 0)
+	return result.received, nil
+}
+
+// pollingInterval returns the delay between successful polls of channel.
+func (t *HTTP) pollingInterval(channel string) time.Duration {
+	switch channel {
+	case "control":
+		return t.opts.ControlPollingInterval
+	case "data":
+		return t.opts.DataPollingInterval
+	default:
+		return t.opts.PollingInterval
+	}
 }
 
-// ReloadTLSConfig creates a new HTTP client with the provided TLS config.
+// fetch issues a single request to the inbound URL of channel and passes the
+// response body, if any, to the data handler, or queues it for a receive
+// worker if ReceiveWorkers is set, asking the server to hold the request
+// until a message arrives if longPoll is set. Its result reports, among other
+// things, whether the server has more messages waiting, as signalled by
+// MoreAvailableHeader.
+func (t *HTTP) fetch(ctx context.Context, channel string, longPoll bool) (pollResult, error) {
+	// A receive worker may handle the message after the long poll's timeout
+	// has been cancelled, so the acknowledgement is sent with the context of
+	// the poll instead.
+	pollCtx := ctx
+	if longPoll {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.opts.LongPollTimeout+longPollGrace)
+		defer cancel()
+	}
+	reqURL, err := t.requestURL("in", channel)
+	if err != nil {
+		return pollResult{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return pollResult{}, permanentError(fmt.Errorf("cannot create HTTP request: %w", err))
+	}
+	t.setHeaders(req.Header)
+	if longPoll {
+		req.Header.Set("Prefer", fmt.Sprintf("wait=%d", int(t.opts.LongPollTimeout.Seconds())))
+	}
+	injectTraceContext(ctx, req.Header)
+	start := time.Now()
+	resp, err := t.do(t.client, req)
+	if err != nil {
+		t.observeRequest(channel, "in", 0, time.Since(start), 0, 0)
+		return pollResult{}, requestError(fmt.Errorf("cannot get HTTP request: %w", err))
+	}
+	defer resp.Body.Close()
+	t.checkRetryAfter(resp)
+	t.checkDate(resp)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		t.state.recordSuccessfulPoll(t.clock.Now())
+	}
+	trace.SpanFromContext(ctx).SetAttributes(statusCodeKey.Int(resp.StatusCode))
+	t.opts.Logger.Debug("polled channel", "channel", channel, "url", req.URL, "status", resp.StatusCode, "request_id", resp.Header.Get(RequestIDHeader))
+
+	if resp.StatusCode < 400 && resp.StatusCode != http.StatusNoContent && t.streamable(resp.Header) {
+		return t.stream(ctx, resp, channel, start)
+	}
+	data, err := readBody(resp, t.opts.MaxResponseSize)
+	t.observeRequest(channel, "in", resp.StatusCode, time.Since(start), 0, len(data))
+	if err != nil {
+		return pollResult{}, fmt.Errorf("cannot read response body: %w", err)
+	}
+	t.logBody("received response body", channel, resp.Header.Get(RequestIDHeader), data)
+	if resp.StatusCode >= 400 {
+		return pollResult{}, HTTPStatusError{StatusCode: resp.StatusCode, Body: data, Header: resp.Header}
+	}
+	result := newPollResult(resp.Header)
+	if resp.StatusCode == http.StatusNoContent || len(data) == 0 {
+		return result, nil
+	}
+
+	data, err = t.encryptionKeys.Load().(*encryptionKeys).decrypt(resp.Header, data)
+	if err == nil {
+		keys := t.signingKeys.Load().(*signingKeys)
+		err = keys.verify(resp.Header, signatureScope(req.Method, channel, t.clientID), data)
+		if err == nil && len(keys.public) > 0 {
+			err = t.replay.check(resp.Header, t.serverNow())
+		}
+	}
+	if err != nil {
+		t.opts.Logger.Warn("dropping message", "channel", channel, "request_id", resp.Header.Get(RequestIDHeader), "error", err)
+		return result, nil
+	}
+
+	opts := receiveSpanOptions(resp.Header)
+	id := resp.Header.Get(RequestIDHeader)
+	t.receivePool.submit(channel, func() {
+		spanCtx, span := t.tracer.Start(pollCtx, "ReceiveData", opts...)
+		err := t.receiveData(withRequestID(spanCtx, id), data, channel)
+		endSpan(span, err)
+		t.acknowledge(spanCtx, channel, id, err)
+	})
+	// A handler that fails on a message says nothing about the server, so
+	// polling carries on without backing off.
+	result.received = true
+	return result, nil
+}
+
+// ReloadTLSConfig rotates the client certificates and root CAs to those of
+// tlsConfig. Requests in flight complete normally and the new configuration
+// is used from the next TLS handshake on.
+//
+// With StrictTLS set, a nil tlsConfig is refused with an error wrapping
+// ErrTLSDowngrade once the transport has been configured for TLS.
 func (t *HTTP) ReloadTLSConfig(tlsConfig *tls.Config) error {
-	*t.client = *internalhttp.NewHTTPClient(tlsConfig, t.userAgent)
+	if tlsConfig == nil {
+		if err := t.checkDowngrade("http"); err != nil {
+			return err
+		}
+	} else {
+		t.tlsConfigured.Store(true)
+	}
+	t.client.ReloadTLSConfig(tlsConfig)
 	t.isTLS.Store(tlsConfig != nil)
+	t.events.emit(Event{Type: EventTLSReloaded, Time: t.clock.Now()})
 	return nil
 }
 
+// Disconnect waits up to quiesce milliseconds for in-flight sends to complete
+// and queued messages to be delivered, then stops the polling goroutines,
+// aborting any inbound request that is still in flight. Receive workers
+// finish handling the inbound messages already queued before they stop.
 func (t *HTTP) Disconnect(quiesce uint) {
-	time.Sleep(time.Millisecond * time.Duration(quiesce))
+	t.quiesce(time.Millisecond * time.Duration(quiesce))
 	t.disconnected.Store(true)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+		t.events.emit(Event{Type: EventDisconnected, Time: t.clock.Now()})
+	}
+}
+
+// Close disconnects the transport without waiting for sends in flight, waits
+// for its goroutines to stop and closes its idle connections, releasing all
+// of its resources. Close is terminal: Connect and Reconnect then fail with
+// ErrTransportClosed and sends with ErrTransportDisconnected, so the
+// transport can only be discarded. To let sends complete first, call
+// Disconnect with a quiesce period before Close. Close must not be called
+// from the data handler, whose return it waits for.
+func (t *HTTP) Close() error {
+	t.closed.Store(true)
+	t.Disconnect(0)
+	t.wg.Wait()
+	t.client.CloseIdleConnections()
+	t.writeClient.CloseIdleConnections()
+	return nil
 }
 
 func (t *HTTP) SendData(data []byte, dest string) ([]byte, error) {
-	return t.send(data, dest)
+	return t.SendDataWithContext(context.Background(), data, dest)
 }
 
+// SendDataWithContext sends data like SendData. Cancelling ctx, or reaching
+// its deadline, aborts the send, including any wait for a retry.
+func (t *HTTP) SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error) {
+	atomic.AddInt32(&t.inflight, 1)
+	defer atomic.AddInt32(&t.inflight, -1)
+
+	ctx, span := t.tracer.Start(ctx, "SendData", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(channelKey.String(dest)))
+	res, err := t.send(ctx, data, dest)
+	endSpan(span, err)
+	return res, err
+}
+
+// ReceiveData passes data to the data handler, unless it is a duplicate or
+// dest is not a channel of the transport, and returns the handler's error. A
+// panic in the handler is recovered, logged with its stack trace and reported
+// as an EventHandlerPanic, and returned as a *HandlerPanicError.
 func (t *HTTP) ReceiveData(data []byte, dest string) error {
-	t.dataHandler(data, dest)
-	return nil
+	return t.receiveData(context.Background(), data, dest)
 }
 
-func (t *HTTP) send(message []byte, channel string) ([]byte, error) {
-	if t.disconnected.Load().(bool) {
-		return nil, nil
+// receiveData receives data like ReceiveData, passing ctx to the
+// ContextHandler.
+func (t *HTTP) receiveData(ctx context.Context, data []byte, dest string) error {
+	if err := t.checkChannel(dest); err != nil {
+		return err
 	}
-	url := t.getUrl("out", channel)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+	if err := t.validate(data, dest); err != nil {
+		return err
 	}
-	log.Tracef("posting HTTP request body: %s", string(message))
-	res, err := t.client.Post(url, headers, message)
-	if err != nil && res == nil {
-		return nil, fmt.Errorf("cannot do HTTP request: %w", err)
+	if t.dedup != nil {
+		if id := messageID(data); id != "" && t.dedup.seen(id, t.clock.Now()) {
+			t.opts.Logger.Debug("dropping duplicate message", "channel", dest, "message_id", id)
+			return nil
+		}
+	}
+	return t.handle(dest, func() error {
+		if t.opts.StreamHandler != nil {
+			return t.opts.StreamHandler(bytes.NewReader(data), dest)
+		}
+		if t.opts.ContextHandler != nil {
+			return t.opts.ContextHandler(ctx, data, dest)
+		}
+		return t.dataHandler(data, dest)
+	})
+}
+
+func (t *HTTP) send(ctx context.Context, message []byte, channel string) ([]byte, error) {
+	if err := t.checkChannel(channel); err != nil {
+		return nil, err
+	}
+	if err := t.checkMessageSize(message); err != nil {
+		return nil, err
+	}
+	if err := checkContentType(ctx); err != nil {
+		return nil, err
+	}
+	// The key is chosen once per message, so that every attempt to send
+	// it, including after it is queued, carries the same one.
+	if t.opts.IdempotencyKeys {
+		ctx = withIdempotencyKey(ctx, idempotencyKey(message))
+	}
+	if t.opts.DryRun {
+		return t.dryRun(ctx, message, channel)
+	}
+	release, err := t.order.acquire(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if t.queue != nil {
+		id, err := t.queue.push(channel, message, contentType(ctx), idempotencyKeyFrom(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("cannot queue message: %w", err)
+		}
+		if t.disconnected.Load().(bool) {
+			t.queue.release(id)
+			return nil, nil
+		}
+		// A message left queued is delivered by the queue, so the
+		// caller must not send it again.
+		data, err := t.sendQueued(ctx, id, message, channel)
+		if retryable(err) {
+			t.opts.Logger.Warn("cannot send message, leaving it queued", "channel", channel, "message", id, "error", err)
+			return nil, nil
+		}
+		return data, err
 	}
+	if t.disconnected.Load().(bool) {
+		t.opts.Logger.Warn("transport disconnected, dropping message", "channel", channel)
+		t.observeDropped(channel)
+		return nil, transientError(ErrTransportDisconnected)
+	}
+	return t.post(ctx, message, channel)
+}
 
-	var response HTTPResponse
-	response.StatusCode = res.StatusCode
-	response.Metadata = make(map[string]string)
-	for k, v := range res.Header {
-		response.Metadata[k] = strings.Join(v, ";")
+// post sends message to the outbound URL of channel, retrying up to
+// SendRetries times while the failure is one that a retry may fix.
+func (t *HTTP) post(ctx context.Context, message []byte, channel string) ([]byte, error) {
+	// Retries reuse the request ID, so the server can recognize them as the
+	// same message.
+	id := requestID(message)
+	for attempt := 1; ; attempt++ {
+		data, err := t.postOnce(ctx, message, channel, id)
+		if err == nil {
+			t.opts.Backoff.Reset()
+		} else {
+			t.state.recordError(err)
+		}
+		if !retryable(err) || attempt > t.opts.SendRetries {
+			return data, err
+		}
+		delay := t.opts.Backoff.NextDelay(attempt)
+		t.opts.Logger.Debug("cannot send message, retrying", "channel", channel, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, transientError(fmt.Errorf("cannot send message: %w", ctx.Err()))
+		case <-t.clock.After(delay):
+		}
 	}
-	body, err := ioutil.ReadAll(res.Body)
+}
+
+// postOnce sends message to the outbound URL of channel and returns the
+// response marshalled as an HTTPResponse.
+func (t *HTTP) postOnce(ctx context.Context, message []byte, channel string, requestID string) ([]byte, error) {
+	release, err := t.waitToSend(ctx, channel)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read HTTP response body: %w", err)
+		return nil, err
 	}
-	defer res.Body.Close()
+	defer release()
+	log.Tracef("posting HTTP request %v body: %s", requestID, string(message))
+	t.logBody("sending request body", channel, requestID, message)
+	body := message
+	encryption := http.Header{}
+	if channel == "data" {
+		body, err = t.encryptionKeys.Load().(*encryptionKeys).encrypt(encryption, message)
+		if err != nil {
+			return nil, permanentError(fmt.Errorf("cannot encrypt HTTP request body: %w", err))
+		}
+	}
+	encrypted := len(encryption) > 0
+	compressed := false
+	// Ciphertext does not compress, and compressing before encrypting
+	// would leak information about the message through its length.
+	if t.opts.Compress && !encrypted && len(message) > t.opts.CompressThreshold {
+		body, err = gzipBytes(message)
+		if err != nil {
+			return nil, permanentError(fmt.Errorf("cannot compress HTTP request body: %w", err))
+		}
+		compressed = true
+	}
+	reqURL, err := t.requestURL("out", channel)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, permanentError(fmt.Errorf("cannot create HTTP request: %w", err))
+	}
+	t.setHeaders(req.Header)
+	req.Header.Set("Content-Type", contentType(ctx))
+	if encrypted {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		for k, v := range encryption {
+			req.Header[k] = v
+		}
+	}
+	req.Header.Set(RequestIDHeader, requestID)
+	if key := idempotencyKeyFrom(ctx); key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	if err := t.signingKeys.Load().(*signingKeys).sign(req.Header, signatureScope(req.Method, channel, t.clientID), message, t.serverNow()); err != nil {
+		return nil, permanentError(fmt.Errorf("cannot sign HTTP request body: %w", err))
+	}
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if t.opts.ExpectContinue && len(body) > t.opts.ExpectContinueThreshold {
+		req.Header.Set("Expect", "100-continue")
+	}
+	return t.doPost(ctx, req, channel, requestID, func() int { return len(body) })
+}
+
+// waitToSend waits until the server is willing to receive requests again, if
+// it asked for a delay, and for a free send slot. The returned function
+// releases the slot.
+func (t *HTTP) waitToSend(ctx context.Context, channel string) (func(), error) {
+	if remaining := t.holdOffRemaining(); remaining > 0 {
+		t.opts.Logger.Debug("server requested a delay, waiting before sending", "channel", channel, "delay", remaining)
+		select {
+		case <-ctx.Done():
+			return nil, transientError(fmt.Errorf("cannot send message: %w", ctx.Err()))
+		case <-t.clock.After(remaining):
+		}
+	}
+	return t.acquireSendSlot(ctx)
+}
 
-	if err := json.Unmarshal(body, &response.Body); err != nil {
-		return nil, fmt.Errorf("cannot marshal HTTP response body: %w", err)
+// doPost sends req, an outbound request to channel, and returns the
+// response encoded as an HTTPResponse. sent returns the size of the request
+// body, once it has been sent.
+func (t *HTTP) doPost(ctx context.Context, req *http.Request, channel string, requestID string, sent func() int) ([]byte, error) {
+	injectTraceContext(ctx, req.Header)
+	start := time.Now()
+	res, err := t.do(t.writeClient, req)
+	if err != nil && res == nil {
+		t.observeRequest(channel, "out", 0, time.Since(start), sent(), 0)
+		return nil, requestError(fmt.Errorf("cannot do HTTP request: %w", err))
+	}
+	t.checkRetryAfter(res)
+	t.checkDate(res)
+	trace.SpanFromContext(ctx).SetAttributes(statusCodeKey.Int(res.StatusCode))
+	t.opts.Logger.Debug("sent message", "channel", channel, "url", req.URL, "status", res.StatusCode, "request_id", requestID)
+
+	defer res.Body.Close()
+	resBody, err := readBody(res, t.opts.MaxResponseSize)
+	t.observeRequest(channel, "out", res.StatusCode, time.Since(start), sent(), len(resBody))
+	if err != nil {
+		err = fmt.Errorf("cannot read HTTP response body: %w", err)
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			// The server has accepted the message, so resending it would
+			// deliver it twice.
+			return nil, permanentError(err)
+		}
+		return nil, err
 	}
+	t.logBody("received response body", channel, requestID, resBody)
 
-	data, err := json.Marshal(response)
+	// Trailers are only known once the body has been read.
+	response, err := parseResponse(res.StatusCode, res.Header, res.Trailer, resBody, requestID, t.opts.ResponseHeader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := t.marshalResponse(response)
 	if err != nil {
-		return nil, fmt.Errorf("cannot marshal HTTP response: %w", err)
+		return nil, err
 	}
 
 	var httpError error
 	if res.StatusCode >= 400 {
-		httpError = fmt.Errorf("%v", http.StatusText(res.StatusCode))
+		httpError = HTTPStatusError{StatusCode: res.StatusCode, Body: resBody, Header: res.Header}
 	}
 
 	return data, httpError
 }
 
+// parseResponse builds the HTTPResponse envelope of a response to the message
+// sent with requestID, from its status code, header, trailer and body, filling
+// in Header too if withHeader is set. Trailers that were sent take precedence
+// over the headers of the same name. Either header may be nil. A body that cannot be
+// stored in the envelope, such as malformed JSON, is a permanent error.
+func parseResponse(statusCode int, header, trailer http.Header, body []byte, requestID string, withHeader bool) (HTTPResponse, error) {
+	response := HTTPResponse{
+		StatusCode: statusCode,
+		Metadata:   make(map[string]string),
+	}
+	addMetadata(response.Metadata, header)
+	if _, ok := response.Metadata[RequestIDHeader]; !ok {
+		response.Metadata[RequestIDHeader] = requestID
+	}
+	addMetadata(response.Metadata, trailer)
+	if withHeader {
+		response.Header = make(http.Header, len(header)+len(trailer))
+		for k, v := range header {
+			response.Header[k] = append([]string(nil), v...)
+		}
+		for k, v := range trailer {
+			if len(v) > 0 {
+				response.Header[k] = append([]string(nil), v...)
+			}
+		}
+		if response.Header.Get(RequestIDHeader) == "" {
+			response.Header.Set(RequestIDHeader, requestID)
+		}
+	}
+
+	var err error
+	response.Body, err = encodeResponseBody(header.Get("Content-Type"), body)
+	if err != nil {
+		return HTTPResponse{}, permanentError(fmt.Errorf("cannot marshal HTTP response body: %w", err))
+	}
+	return response, nil
+}
+
+// addMetadata adds the fields of header to metadata, joining repeated values
+// as described by joinValues. Fields without values, such as trailers that
+// were announced but not sent, are skipped.
+func addMetadata(metadata map[string]string, header http.Header) {
+	for k, v := range header {
+		if len(v) > 0 {
+			metadata[k] = joinValues(k, v)
+		}
+	}
+}
+
+// joinValues combines the values of a repeated header field named name into
+// one, as RFC 9110 allows, by joining them with commas. Set-Cookie is the
+// exception: cookies may contain commas and cannot be combined that way, so
+// they are joined with newlines, which no header value can contain.
+func joinValues(name string, values []string) string {
+	if http.CanonicalHeaderKey(name) == "Set-Cookie" {
+		return strings.Join(values, "\n")
+	}
+	return strings.Join(values, ", ")
+}
+
+// splitValues reverses joinValues for the fields that can be split back
+// unambiguously: Set-Cookie into its cookies. Any other field is returned as
+// its combined value, since commas may occur within values.
+func splitValues(name, value string) []string {
+	if http.CanonicalHeaderKey(name) == "Set-Cookie" {
+		return strings.Split(value, "\n")
+	}
+	return []string{value}
+}
+
+// Values returns the values of the response header field name. They are
+// taken from Header if it is set, and otherwise from Metadata, where the
+// values of repeated fields other than Set-Cookie stay combined into one.
+func (r HTTPResponse) Values(name string) []string {
+	if r.Header != nil {
+		return r.Header.Values(name)
+	}
+	for k, v := range r.Metadata {
+		if strings.EqualFold(k, name) {
+			return splitValues(k, v)
+		}
+	}
+	return nil
+}
+
+// encodeResponseBody converts a response body with the given content type into
+// a value suitable for HTTPResponse.Body.
+func encodeResponseBody(contentType string, body []byte) (json.RawMessage, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var raw json.RawMessage
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	case strings.HasPrefix(mediaType, "text/"):
+		return json.Marshal(string(body))
+	default:
+		return json.Marshal(body)
+	}
+}
+
+// setHeaders adds the configured static headers to header, skipping those
+// the transport and client always set themselves.
+func (t *HTTP) setHeaders(header http.Header) {
+	for k, v := range t.opts.Headers {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Type", "User-Agent":
+			continue
+		}
+		header.Set(k, strings.TrimSpace(v))
+	}
+}
+
 func (t *HTTP) getUrl(direction string, channel string) string {
+	host, port := t.host, t.port
+	isTLS := t.isTLS.Load().(bool)
+	if direction == "out" {
+		host, port = t.writeHost, t.writePort
+		isTLS = isTLS || t.writeClient != t.client
+	}
 	protocol := "http"
-	if t.isTLS.Load().(bool) {
+	if isTLS {
 		protocol = "https"
 	}
-	path := filepath.Join(yggdrasil.PathPrefix, channel, t.clientID, direction)
+	// URL paths are always slash-separated, so use path.Join rather than
+	// filepath.Join. Joining onto "/" ensures there is exactly one slash
+	// between the server and the path.
+	p := path.Join("/", t.pathPrefix, channel, url.PathEscape(t.clientID), direction)
 
-	return fmt.Sprintf("%s://%s/%s", protocol, t.server, path)
+	return fmt.Sprintf("%s://%s%s", protocol, hostPort(host, port, protocol), p)
 }
@@ -0,0 +1,533 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redhatinsights/yggdrasil"
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+)
+
+// waitGroupTimeout waits for the transport polling goroutines to exit,
+// failing the test if they are still running after timeout.
+func waitGroupTimeout(t *testing.T, transport *HTTP, timeout time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		transport.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("polling goroutines did not exit")
+	}
+}
+
+func TestDisconnectStopsPolling(t *testing.T) {
+	inflight := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inflight <- req.URL.Path
+		// Block until the client aborts the request.
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval:  time.Second,
+		SkipConnectProbe: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-inflight:
+		case <-time.After(time.Second):
+			t.Fatal("polling requests were not sent")
+		}
+	}
+
+	transport.Disconnect(0)
+	waitGroupTimeout(t, transport, time.Second)
+}
+
+func TestReconnect(t *testing.T) {
+	var requests = make(chan string, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests <- req.URL.Path
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Hour, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := transport.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 2; j++ {
+			select {
+			case <-requests:
+			case <-time.After(time.Second):
+				t.Fatalf("polling requests were not sent after connect %v", i)
+			}
+		}
+		transport.Disconnect(0)
+		waitGroupTimeout(t, transport, time.Second)
+	}
+}
+
+func TestConnectProbe(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer tlsSrv.Close()
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer authSrv.Close()
+
+	tests := []struct {
+		description string
+		server      string
+		tls         bool
+		opts        HTTPOptions
+		wantClass   error
+		wantPolling bool
+	}{
+		{
+			description: "failing handshake",
+			server:      tlsSrv.Listener.Addr().String(),
+			tls:         true,
+			wantClass:   ErrTransientTransport,
+		},
+		{
+			description: "rejected credentials",
+			server:      authSrv.Listener.Addr().String(),
+			wantClass:   ErrPermanentTransport,
+		},
+		{
+			description: "probe skipped",
+			server:      authSrv.Listener.Addr().String(),
+			opts:        HTTPOptions{SkipConnectProbe: true},
+			wantPolling: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var tlsConfig *tls.Config
+			if test.tls {
+				// The server's certificate is not trusted.
+				tlsConfig = &tls.Config{RootCAs: x509.NewCertPool()}
+			}
+			test.opts.PollingInterval = time.Hour
+			transport, err := NewHTTPTransportWithOptions("test", test.server, tlsConfig, "testUA", func([]byte, string) error { return nil }, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer waitGroupTimeout(t, transport, time.Second)
+			defer transport.Disconnect(0)
+
+			err = transport.Connect()
+			if test.wantClass == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if !errors.Is(err, test.wantClass) {
+				t.Errorf("error %v is not %v", err, test.wantClass)
+			}
+			if got := transport.State().Connected; got != test.wantPolling {
+				t.Errorf("polling %v, want %v", got, test.wantPolling)
+			}
+		})
+	}
+}
+
+func TestGetURL(t *testing.T) {
+	tests := []struct {
+		description string
+		clientID    string
+		prefix      string
+		tls         bool
+		want        string
+	}{
+		{
+			description: "plain HTTP",
+			clientID:    "1234",
+			prefix:      "yggdrasil",
+			want:        "http://localhost:8080/yggdrasil/data/1234/in",
+		},
+		{
+			description: "HTTPS",
+			clientID:    "1234",
+			prefix:      "yggdrasil",
+			tls:         true,
+			want:        "https://localhost:8080/yggdrasil/data/1234/in",
+		},
+		{
+			description: "leading slash in prefix",
+			clientID:    "1234",
+			prefix:      "/api/yggdrasil",
+			want:        "http://localhost:8080/api/yggdrasil/data/1234/in",
+		},
+	}
+
+	prefix := yggdrasil.PathPrefix
+	defer func() { yggdrasil.PathPrefix = prefix }()
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			yggdrasil.PathPrefix = test.prefix
+			var tlsConfig *tls.Config
+			if test.tls {
+				tlsConfig = &tls.Config{}
+			}
+			transport, err := NewHTTPTransport(test.clientID, "localhost:8080", tlsConfig, "testUA", time.Second, func([]byte, string) error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The URL must not depend on the OS path separator.
+			if got := transport.getUrl("in", "data"); got != test.want {
+				t.Errorf("%v != %v (GOOS=%v)", got, test.want, runtime.GOOS)
+			}
+		})
+	}
+}
+
+func TestGetURLPathPrefixOption(t *testing.T) {
+	tests := []struct {
+		description string
+		prefix      string
+		want        string
+	}{
+		{
+			description: "default",
+			want:        "http://localhost:8080/yggdrasil/data/1234/in",
+		},
+		{
+			description: "custom prefix",
+			prefix:      "api/other/v2",
+			want:        "http://localhost:8080/api/other/v2/data/1234/in",
+		},
+		{
+			description: "trailing slash in prefix",
+			prefix:      "/api/other/",
+			want:        "http://localhost:8080/api/other/data/1234/in",
+		},
+		{
+			description: "no prefix",
+			prefix:      "/",
+			want:        "http://localhost:8080/data/1234/in",
+		},
+	}
+
+	prefix := yggdrasil.PathPrefix
+	defer func() { yggdrasil.PathPrefix = prefix }()
+	yggdrasil.PathPrefix = "yggdrasil"
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			transport, err := NewHTTPTransportWithOptions("1234", "localhost:8080", nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{PathPrefix: test.prefix})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := transport.getUrl("in", "data"); got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewHTTPTransportClientID(t *testing.T) {
+	tests := []struct {
+		clientID  string
+		wantError bool
+	}{
+		{clientID: "0c4bb436-5a4c-4e8b-a0c6-0e5d4d1a8e1f"},
+		{clientID: "host.example.com"},
+		{clientID: "a_b~c"},
+		{clientID: "", wantError: true},
+		{clientID: "a/b", wantError: true},
+		{clientID: "../data", wantError: true},
+		{clientID: "..", wantError: true},
+		{clientID: "a b", wantError: true},
+		{clientID: "a%2Fb", wantError: true},
+		{clientID: "a?b", wantError: true},
+	}
+	for _, test := range tests {
+		_, err := NewHTTPTransport(test.clientID, "localhost:8080", nil, "testUA", time.Second, func([]byte, string) error { return nil })
+		if got := err != nil; got != test.wantError {
+			t.Errorf("client ID %q: error %v, want error %v", test.clientID, err, test.wantError)
+		}
+	}
+}
+
+func TestNewHTTPTransportNilTLSConfig(t *testing.T) {
+	transport, err := NewHTTPTransport("1234", "localhost:8080", nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.isTLS.Load().(bool) {
+		t.Error("transport with a nil TLS config should not use TLS")
+	}
+	if got := transport.getUrl("out", "control"); !strings.HasPrefix(got, "http://") {
+		t.Errorf("URL %v should use the http scheme", got)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	headers := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers <- req.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	var calls int32
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		ClientOptions: internalhttp.ClientOptions{
+			BearerToken: func() (string, error) {
+				return fmt.Sprintf("token-%v", atomic.AddInt32(&calls, 1)), nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transport.fetch(context.Background(), "control", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Bearer token-1", "Bearer token-2"} {
+		if got := <-headers; got != want {
+			t.Errorf("authorization %q != %q", got, want)
+		}
+	}
+}
+
+func TestStaticHeaders(t *testing.T) {
+	requests := make(chan http.Header, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests <- req.Header
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		Headers: map[string]string{
+			"X-Api-Key":     "key",
+			"Content-Type":  "text/plain",
+			"User-Agent":    "otherUA",
+			RequestIDHeader: "static",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transport.fetch(context.Background(), "control", false); err != nil {
+		t.Fatal(err)
+	}
+	poll := <-requests
+	if got := poll.Get("X-Api-Key"); got != "key" {
+		t.Errorf("poll X-Api-Key %q != key", got)
+	}
+	if got := poll.Values("User-Agent"); len(got) != 1 || got[0] != "testUA" {
+		t.Errorf("poll User-Agent %q != testUA", got)
+	}
+
+	if _, err := transport.SendData([]byte(`{"metadata":{"X-Request-ID":"message"}}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	send := <-requests
+	if got := send.Get("X-Api-Key"); got != "key" {
+		t.Errorf("send X-Api-Key %q != key", got)
+	}
+	if got := send.Get("Content-Type"); got != "application/json" {
+		t.Errorf("send Content-Type %q != application/json", got)
+	}
+	if got := send.Get(RequestIDHeader); got != "message" {
+		t.Errorf("send request ID %q != message", got)
+	}
+}
+
+func TestPollingIntervals(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		description string
+		opts        HTTPOptions
+		want        map[string]time.Duration
+	}{
+		{
+			description: "shared",
+			opts:        HTTPOptions{PollingInterval: 5 * time.Second},
+			want:        map[string]time.Duration{"control": 5 * time.Second, "data": 5 * time.Second},
+		},
+		{
+			description: "per channel",
+			opts: HTTPOptions{
+				PollingInterval:        5 * time.Second,
+				ControlPollingInterval: time.Second,
+				DataPollingInterval:    time.Minute,
+			},
+			want: map[string]time.Duration{"control": time.Second, "data": time.Minute},
+		},
+		{
+			description: "control only",
+			opts:        HTTPOptions{PollingInterval: 5 * time.Second, ControlPollingInterval: time.Second},
+			want:        map[string]time.Duration{"control": time.Second, "data": 5 * time.Second},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			for channel, want := range test.want {
+				transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, test.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clock := newFakeClock()
+				transport.clock = clock
+
+				ctx, cancel := context.WithCancel(context.Background())
+				transport.wg.Add(1)
+				go transport.poll(ctx, channel)
+				for i := 0; i < 2; i++ {
+					if got := <-clock.sleeps; got != want {
+						t.Errorf("%v channel slept %v, want %v", channel, got, want)
+					}
+				}
+				cancel()
+				close(clock.stop)
+				waitGroupTimeout(t, transport, time.Second)
+			}
+		})
+	}
+}
+
+func TestGetURLServer(t *testing.T) {
+	tests := []struct {
+		description string
+		server      string
+		tls         bool
+		want        string
+		wantError   bool
+	}{
+		{
+			description: "host and port",
+			server:      "example.com:8080",
+			want:        "http://example.com:8080/yggdrasil/data/1234/in",
+		},
+		{
+			description: "bare host",
+			server:      "example.com",
+			want:        "http://example.com:80/yggdrasil/data/1234/in",
+		},
+		{
+			description: "bare host with TLS",
+			server:      "example.com",
+			tls:         true,
+			want:        "https://example.com:443/yggdrasil/data/1234/in",
+		},
+		{
+			description: "IPv6 literal",
+			server:      "::1",
+			want:        "http://[::1]:80/yggdrasil/data/1234/in",
+		},
+		{
+			description: "bracketed IPv6 literal",
+			server:      "[::1]",
+			want:        "http://[::1]:80/yggdrasil/data/1234/in",
+		},
+		{
+			description: "IPv6 literal and port",
+			server:      "[2001:db8::1]:8443",
+			tls:         true,
+			want:        "https://[2001:db8::1]:8443/yggdrasil/data/1234/in",
+		},
+		{
+			description: "IPv4 literal",
+			server:      "192.0.2.1",
+			want:        "http://192.0.2.1:80/yggdrasil/data/1234/in",
+		},
+		{
+			description: "mistaken scheme",
+			server:      "https://example.com:8443/",
+			tls:         true,
+			want:        "https://example.com:8443/yggdrasil/data/1234/in",
+		},
+		{
+			description: "https scheme without TLS",
+			server:      "https://example.com",
+			wantError:   true,
+		},
+		{
+			description: "http scheme with TLS",
+			server:      "http://example.com",
+			tls:         true,
+			want:        "https://example.com:443/yggdrasil/data/1234/in",
+		},
+		{
+			description: "path",
+			server:      "example.com/api",
+			wantError:   true,
+		},
+		{
+			description: "invalid port",
+			server:      "example.com:http",
+			wantError:   true,
+		},
+		{
+			description: "missing host",
+			server:      ":8080",
+			wantError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var tlsConfig *tls.Config
+			if test.tls {
+				tlsConfig = &tls.Config{}
+			}
+			transport, err := NewHTTPTransportWithOptions("1234", test.server, tlsConfig, "testUA", func([]byte, string) error { return nil }, HTTPOptions{PathPrefix: "yggdrasil"})
+			if test.wantError {
+				if err == nil {
+					t.Fatalf("expected an error for %v", test.server)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := transport.getUrl("in", "data"); got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
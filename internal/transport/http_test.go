@@ -4,12 +4,21 @@
 package transport_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,16 +40,19 @@ func startServer() *http.Server {
 	}
 
 	http.HandleFunc("/yggdrasil/test/401/out", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		getResponse(w)
 	})
 
 	http.HandleFunc("/yggdrasil/test/500/out", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		getResponse(w)
 	})
 
 	http.HandleFunc("/yggdrasil/test/200/out", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		getResponse(w)
 	})
@@ -130,10 +142,13 @@ func TestSend(t *testing.T) {
 		},
 	}
 
-	cb := func([]byte, string) {}
+	cb := func([]byte, string) error { return nil }
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
-			httpTransport, err := transport.NewHTTPTransport(test.client, test.server, nil, "testUA", time.Second, cb)
+			httpTransport, err := transport.NewHTTPTransportWithOptions(test.client, test.server, nil, "testUA", cb, transport.HTTPOptions{
+				PollingInterval: time.Second,
+				Channels:        []transport.Channel{"test"},
+			})
 			if err != nil {
 				t.Error("Cannot create new transport")
 			}
@@ -175,3 +190,347 @@ func TestSend(t *testing.T) {
 		})
 	}
 }
+
+func TestSendResponseBody(t *testing.T) {
+	tests := []struct {
+		description string
+		contentType string
+		body        string
+		err         bool
+		want        json.RawMessage
+	}{
+		{
+			description: "empty body",
+			contentType: "application/json",
+			body:        "",
+			want:        nil,
+		},
+		{
+			description: "JSON body",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"status":"OK"}`,
+			want:        json.RawMessage(`{"status":"OK"}`),
+		},
+		{
+			description: "text body",
+			contentType: "text/plain",
+			body:        "service unavailable",
+			want:        json.RawMessage(`"service unavailable"`),
+		},
+		{
+			description: "binary body",
+			contentType: "application/octet-stream",
+			body:        "\x00\x01",
+			want:        json.RawMessage(`"AAE="`),
+		},
+		{
+			description: "malformed JSON body",
+			contentType: "application/json",
+			body:        `{"status":`,
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				fmt.Fprint(w, test.body)
+			}))
+			defer srv.Close()
+
+			httpTransport, err := transport.NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := httpTransport.SendData([]byte("{}"), "data")
+			if test.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var parsedResponse transport.HTTPResponse
+			if err := json.Unmarshal(res, &parsedResponse); err != nil {
+				t.Fatalf("Cannot unmarshal response, err = %v", err)
+			}
+			if !cmp.Equal(parsedResponse.Body, test.want) {
+				t.Errorf("Response body is not the same %s != %s", parsedResponse.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestSendTrailers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum, X-More, X-Unsent")
+		w.Header().Set("X-More", "true")
+		w.Header().Set("Content-Type", "application/json")
+		// Flushing before the body is complete forces a chunked response.
+		fmt.Fprint(w, `{"status":`)
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, `"OK"}`)
+		w.Header().Set("X-Checksum", "abc123")
+		w.Header().Set("X-More", "false")
+	}))
+	defer srv.Close()
+
+	httpTransport, err := transport.NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := httpTransport.SendData([]byte("{}"), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := httpTransport.UnmarshalResponse(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Metadata["X-Checksum"]; got != "abc123" {
+		t.Errorf("X-Checksum = %q, want abc123", got)
+	}
+	if got := response.Metadata["X-More"]; got != "false" {
+		t.Errorf("X-More = %q, want the trailer value false", got)
+	}
+	if got, ok := response.Metadata["X-Unsent"]; ok {
+		t.Errorf("X-Unsent = %q, want it absent", got)
+	}
+	if got := string(response.Body); got != `{"status":"OK"}` {
+		t.Errorf("body %v, want {\"status\":\"OK\"}", got)
+	}
+}
+
+func TestSendMultiValuedHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+		w.Header().Add("Set-Cookie", "b=2; Path=/; HttpOnly")
+		w.Header().Add("Cache-Control", "no-cache")
+		w.Header().Add("Cache-Control", "max-age=0; private")
+	}))
+	defer srv.Close()
+
+	for _, responseHeader := range []bool{false, true} {
+		httpTransport, err := transport.NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, transport.HTTPOptions{
+			ResponseHeader: responseHeader,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := httpTransport.SendData([]byte("{}"), "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := httpTransport.UnmarshalResponse(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := response.Metadata["Cache-Control"], "no-cache, max-age=0; private"; got != want {
+			t.Errorf("Cache-Control = %q, want %q", got, want)
+		}
+		wantCookies := []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2; Path=/; HttpOnly"}
+		if got := response.Values("set-cookie"); !cmp.Equal(got, wantCookies) {
+			t.Errorf("Set-Cookie values = %q, want %q", got, wantCookies)
+		}
+		wantCacheControl := []string{"no-cache, max-age=0; private"}
+		if responseHeader {
+			wantCacheControl = []string{"no-cache", "max-age=0; private"}
+		}
+		if got := response.Values("Cache-Control"); !cmp.Equal(got, wantCacheControl) {
+			t.Errorf("Cache-Control values = %q, want %q", got, wantCacheControl)
+		}
+	}
+}
+
+func TestSendHTTPStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Error-Code", "invalid-message")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"error":"missing field"}`)
+	}))
+	defer srv.Close()
+
+	httpTransport, err := transport.NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := httpTransport.SendData([]byte("{}"), "data")
+	var statusErr transport.HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected an HTTPStatusError, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("status code %v != %v", statusErr.StatusCode, http.StatusUnprocessableEntity)
+	}
+	if !cmp.Equal(statusErr.Body, []byte(`{"error":"missing field"}`)) {
+		t.Errorf("unexpected error body %s", statusErr.Body)
+	}
+	if got := statusErr.Header.Get("X-Error-Code"); got != "invalid-message" {
+		t.Errorf("unexpected error header %v", got)
+	}
+	if len(res) == 0 {
+		t.Error("Send should return the response alongside the error")
+	}
+}
+
+func TestSendTruncatedResponseBody(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(`{"resp`))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	httpTransport, err := transport.NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, transport.HTTPOptions{
+		SendRetries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The message was accepted, so it is not sent again.
+	_, err = httpTransport.SendData([]byte("{}"), "data")
+	if !errors.Is(err, transport.ErrTruncatedBody) {
+		t.Errorf("got %v, want ErrTruncatedBody", err)
+	}
+	if !errors.Is(err, transport.ErrPermanentTransport) {
+		t.Errorf("%v is not permanent", err)
+	}
+	if got := atomic.LoadInt32(&posts); got != 1 {
+		t.Errorf("message sent %v times, want 1", got)
+	}
+}
+
+func TestLongPoll(t *testing.T) {
+	var mu sync.Mutex
+	var polls int
+	prefer := make(chan string, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/yggdrasil/data/test/in" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		select {
+		case prefer <- req.Header.Get("Prefer"):
+		default:
+		}
+
+		// Hold the request open as a long-polling server would.
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		if polls == 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fmt.Fprintf(w, "message %v", polls)
+	}))
+	defer srv.Close()
+
+	received := make(chan string, 16)
+	httpTransport, err := transport.NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+		received <- string(data)
+		return nil
+	}, transport.HTTPOptions{
+		PollingInterval: time.Hour,
+		LongPoll:        true,
+		LongPollTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := httpTransport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer httpTransport.Disconnect(0)
+
+	if got := <-prefer; got != "wait=5" {
+		t.Errorf("Prefer header %q != %q", got, "wait=5")
+	}
+
+	// The empty first response must not reach the handler, and the following
+	// polls must not wait for the polling interval.
+	for _, want := range []string{"message 2", "message 3"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("%v != %v", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("did not receive %v", want)
+		}
+	}
+}
+
+func TestSendCompression(t *testing.T) {
+	type request struct {
+		encoding string
+		body     []byte
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var r io.Reader = req.Body
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			gr, err := gzip.NewReader(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r = gr
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request{encoding: req.Header.Get("Content-Encoding"), body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	httpTransport, err := transport.NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, transport.HTTPOptions{
+		PollingInterval:   time.Second,
+		Compress:          true,
+		CompressThreshold: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		body        []byte
+		encoding    string
+	}{
+		{description: "small body is not compressed", body: []byte(`{"a":1}`), encoding: ""},
+		{description: "large body is compressed", body: bytes.Repeat([]byte("a"), 4096), encoding: "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := httpTransport.SendData(test.body, "data"); err != nil {
+				t.Fatal(err)
+			}
+			got := <-requests
+			if got.encoding != test.encoding {
+				t.Errorf("Content-Encoding %q != %q", got.encoding, test.encoding)
+			}
+			if !cmp.Equal(got.body, test.body) {
+				t.Error("server did not receive the original body")
+			}
+		})
+	}
+}
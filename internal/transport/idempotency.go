@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader is the HTTP header that carries the key identifying an
+// outbound message, if HTTPOptions.IdempotencyKeys is set, so that the server
+// can recognize a retried POST as a message it has already processed. It is
+// also the message metadata key under which callers may supply their own key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type idempotencyKeyKey struct{}
+
+// withIdempotencyKey returns a copy of ctx carrying the idempotency key of the
+// message being sent.
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// idempotencyKeyFrom returns the idempotency key set on ctx, if any.
+func idempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	return key
+}
+
+// idempotencyKey returns the idempotency key supplied in the metadata of
+// message, or a new random UUID if message does not carry one.
+func idempotencyKey(message []byte) string {
+	var data struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := json.Unmarshal(message, &data); err == nil {
+		if key := data.Metadata[IdempotencyKeyHeader]; key != "" {
+			return key
+		}
+	}
+	return uuid.New().String()
+}
@@ -0,0 +1,128 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	// The server fails the first attempt to send each message.
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, req.Header.Get(IdempotencyKeyHeader))
+		if len(keys)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		IdempotencyKeys: true,
+		SendRetries:     1,
+		Backoff:         ConstantBackoff{Delay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{`{"n":1}`, `{"n":2}`, `{"metadata":{"Idempotency-Key":"supplied"}}`} {
+		if _, err := transport.SendData([]byte(message), "data"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(keys) != 6 {
+		t.Fatalf("server got %v requests, want 6", len(keys))
+	}
+	for i := 0; i < len(keys); i += 2 {
+		if keys[i] == "" || keys[i] != keys[i+1] {
+			t.Errorf("message %v sent with keys %q and %q, want the same key", i/2+1, keys[i], keys[i+1])
+		}
+	}
+	if keys[0] == keys[2] {
+		t.Errorf("distinct messages sent with the same key %q", keys[0])
+	}
+	if keys[4] != "supplied" {
+		t.Errorf("got key %q, want the key from the metadata", keys[4])
+	}
+}
+
+func TestIdempotencyKeysOff(t *testing.T) {
+	var key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key = req.Header.Get(IdempotencyKeyHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{"metadata":{"Idempotency-Key":"supplied"}}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		t.Errorf("sent key %q, want none", key)
+	}
+}
+
+func TestIdempotencyKeysQueued(t *testing.T) {
+	dir := t.TempDir()
+
+	// Queue a message while the server is unreachable.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(down.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		QueueDir:        dir,
+		IdempotencyKeys: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{"n":1}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := transport.queue.list()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("queued %v, %v, want one message", ids, err)
+	}
+	msg, err := transport.queue.read(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.IdempotencyKey == "" {
+		t.Fatal("message queued without an idempotency key")
+	}
+
+	// A new transport using the same queue delivers it with the same key.
+	var key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key = req.Header.Get(IdempotencyKeyHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	transport, err = NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		QueueDir:        dir,
+		IdempotencyKeys: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.drainQueue(context.Background())
+	if key != msg.IdempotencyKey {
+		t.Errorf("replayed with key %q, want the queued key %q", key, msg.IdempotencyKey)
+	}
+}
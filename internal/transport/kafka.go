@@ -0,0 +1,333 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/redhatinsights/yggdrasil"
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaChannelHeader is the Kafka record header naming the channel ("control"
+// or "data") a message belongs to. Inbound records without it are passed to
+// the data channel.
+const KafkaChannelHeader = "channel"
+
+// KafkaOptions holds optional settings for a Kafka transport. Zero-valued
+// fields are replaced with their defaults by NewKafkaTransportWithOptions.
+type KafkaOptions struct {
+	// OutboundTopic is the topic outbound messages are produced to, keyed
+	// by client ID. Defaults to "<prefix>.out", with slashes in the path
+	// prefix replaced by dots.
+	OutboundTopic string
+
+	// InboundTopic is the topic inbound messages are consumed from. It may
+	// be dedicated to the client, or shared by many; records keyed by
+	// another client ID are skipped. Defaults to "<prefix>.<client ID>.in".
+	InboundTopic string
+
+	// GroupID is the consumer group the inbound topic is consumed in, whose
+	// members share its partitions and have them rebalanced as they come
+	// and go. Defaults to the client ID.
+	GroupID string
+
+	// Backoff computes the delay between retries after fetching or handling
+	// an inbound message fails. Defaults to an ExponentialBackoff from one
+	// second up to DefaultMaxBackoff.
+	Backoff BackoffStrategy
+}
+
+// kafkaProducer produces records to Kafka. It is implemented by
+// *kafka.Writer.
+type kafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaConsumer consumes records from Kafka in a consumer group. It is
+// implemented by *kafka.Reader.
+type kafkaConsumer interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Kafka is a Transporter that produces outbound messages to, and consumes
+// inbound messages from, Kafka topics. Inbound messages are delivered at
+// least once: the offset of a record is committed only once the data handler
+// has accepted it.
+type Kafka struct {
+	clientID    string
+	brokers     []string
+	dataHandler DataReceiveHandlerFunc
+	opts        KafkaOptions
+
+	// newProducer and newConsumer create the clients used while connected.
+	// They are replaced with fakes in tests.
+	newProducer func(tlsConfig *tls.Config) kafkaProducer
+	newConsumer func(tlsConfig *tls.Config) kafkaConsumer
+
+	// handling counts calls to the data handler in progress.
+	handling int32
+
+	// mu guards the fields below.
+	mu        sync.Mutex
+	tlsConfig *tls.Config
+	producer  kafkaProducer
+	cancel    context.CancelFunc
+
+	// done is closed once the consumer of the current connection has
+	// stopped and been closed.
+	done chan struct{}
+}
+
+// NewKafkaTransport creates a transport that exchanges messages through the
+// Kafka cluster reachable at brokers, using the default options. A nil
+// tlsConfig creates a transport that connects without TLS.
+func NewKafkaTransport(clientID string, brokers []string, tlsConfig *tls.Config, dataRecvFunc DataReceiveHandlerFunc) (*Kafka, error) {
+	return NewKafkaTransportWithOptions(clientID, brokers, tlsConfig, dataRecvFunc, KafkaOptions{})
+}
+
+// NewKafkaTransportWithOptions creates a Kafka transport configured by opts.
+func NewKafkaTransportWithOptions(clientID string, brokers []string, tlsConfig *tls.Config, dataRecvFunc DataReceiveHandlerFunc, opts KafkaOptions) (*Kafka, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("cannot create Kafka transport: no brokers")
+	}
+	prefix := strings.ReplaceAll(strings.Trim(yggdrasil.PathPrefix, "/"), "/", ".")
+	if opts.OutboundTopic == "" {
+		opts.OutboundTopic = prefix + ".out"
+	}
+	if opts.InboundTopic == "" {
+		opts.InboundTopic = prefix + "." + clientID + ".in"
+	}
+	if opts.GroupID == "" {
+		opts.GroupID = clientID
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff{
+			Min:        time.Second,
+			Max:        DefaultMaxBackoff,
+			Multiplier: DefaultBackoffMultiplier,
+		}
+	}
+
+	t := &Kafka{
+		clientID:    clientID,
+		brokers:     brokers,
+		dataHandler: dataRecvFunc,
+		opts:        opts,
+		tlsConfig:   tlsConfig.Clone(),
+	}
+	t.newProducer = t.dialProducer
+	t.newConsumer = t.dialConsumer
+	return t, nil
+}
+
+func (t *Kafka) dialProducer(tlsConfig *tls.Config) kafkaProducer {
+	return &kafka.Writer{
+		Addr:     kafka.TCP(t.brokers...),
+		Topic:    t.opts.OutboundTopic,
+		Balancer: &kafka.Hash{},
+		// Flush promptly, since SendData waits for its message to be
+		// written.
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    &kafka.Transport{TLS: tlsConfig},
+	}
+}
+
+func (t *Kafka) dialConsumer(tlsConfig *tls.Config) kafkaConsumer {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers: t.brokers,
+		GroupID: t.opts.GroupID,
+		Topic:   t.opts.InboundTopic,
+		Dialer: &kafka.Dialer{
+			Timeout:   10 * time.Second,
+			DualStack: true,
+			TLS:       tlsConfig,
+		},
+	})
+}
+
+// Connect creates the producer and joins the consumer group, starting to
+// consume inbound messages. Connection failures are retried in the
+// background. If already connected, the previous consumer is stopped
+// without waiting for it, so Connect may be called from the data handler.
+func (t *Kafka) Connect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stop()
+	t.start()
+	return nil
+}
+
+// start creates the producer and consumer and starts consuming. t.mu must be
+// held.
+func (t *Kafka) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.producer = t.newProducer(t.tlsConfig)
+	t.done = make(chan struct{})
+
+	go t.run(ctx, t.newConsumer(t.tlsConfig), t.done)
+}
+
+// stop stops consuming and closes the producer, if connected, returning a
+// channel closed once the consumer has stopped and been closed, or nil if
+// not connected. Messages being handled are not committed, and are
+// redelivered. t.mu must be held.
+func (t *Kafka) stop() <-chan struct{} {
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	t.cancel = nil
+
+	if err := t.producer.Close(); err != nil {
+		log.Debugf("cannot close Kafka producer: %v", err)
+	}
+	done := t.done
+	t.producer = nil
+	t.done = nil
+	return done
+}
+
+// run fetches records from consumer and handles them until ctx is cancelled,
+// backing off while fetching fails, then closes consumer and done.
+func (t *Kafka) run(ctx context.Context, consumer kafkaConsumer, done chan struct{}) {
+	defer close(done)
+	defer func() {
+		if err := consumer.Close(); err != nil {
+			log.Debugf("cannot close Kafka consumer: %v", err)
+		}
+	}()
+
+	for attempt := 1; ; {
+		msg, err := consumer.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			delay := t.opts.Backoff.NextDelay(attempt)
+			log.Debugf("cannot fetch Kafka message, retrying in %v: %v", delay, err)
+			attempt++
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+		attempt = 1
+		t.opts.Backoff.Reset()
+
+		if !t.handle(ctx, msg) {
+			return
+		}
+		if err := consumer.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			log.Errorf("cannot commit Kafka message offset: %v", err)
+		}
+	}
+}
+
+// handle passes msg to the data handler, unless it is addressed to another
+// client, retrying until it is accepted. It returns false if ctx is
+// cancelled first, in which case msg must not be committed.
+func (t *Kafka) handle(ctx context.Context, msg kafka.Message) bool {
+	if len(msg.Key) > 0 && string(msg.Key) != t.clientID {
+		return true
+	}
+	channel := "data"
+	for _, header := range msg.Headers {
+		if header.Key == KafkaChannelHeader {
+			channel = string(header.Value)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		atomic.AddInt32(&t.handling, 1)
+		err := t.ReceiveData(msg.Value, channel)
+		atomic.AddInt32(&t.handling, -1)
+		if err == nil {
+			return true
+		}
+		delay := t.opts.Backoff.NextDelay(attempt)
+		log.Errorf("cannot receive %v message, retrying in %v: %v", channel, delay, err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+// ReloadTLSConfig replaces the TLS config used to connect to the brokers. If
+// connected, the producer and consumer are recreated using the new config;
+// the consumer rejoins its group and resumes from the last committed offset.
+func (t *Kafka) ReloadTLSConfig(tlsConfig *tls.Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tlsConfig = tlsConfig.Clone()
+	if t.cancel != nil {
+		t.stop()
+		t.start()
+	}
+	return nil
+}
+
+// Disconnect waits quiesce milliseconds, then stops consuming and closes the
+// producer and consumer. It waits for the consumer to stop unless a message
+// is being handled, since it may be called from the data handler, which the
+// consumer waits for.
+func (t *Kafka) Disconnect(quiesce uint) {
+	time.Sleep(time.Millisecond * time.Duration(quiesce))
+
+	t.mu.Lock()
+	done := t.stop()
+	t.mu.Unlock()
+
+	if done != nil && atomic.LoadInt32(&t.handling) == 0 {
+		<-done
+	}
+}
+
+// SendData produces data to the outbound topic, keyed by client ID and
+// tagged with the dest channel.
+func (t *Kafka) SendData(data []byte, dest string) ([]byte, error) {
+	return t.SendDataWithContext(context.Background(), data, dest)
+}
+
+// SendDataWithContext sends data like SendData, waiting until the record is
+// written or ctx is done.
+func (t *Kafka) SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error) {
+	t.mu.Lock()
+	producer := t.producer
+	t.mu.Unlock()
+	if producer == nil {
+		return nil, fmt.Errorf("cannot send data: not connected")
+	}
+
+	err := producer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(t.clientID),
+		Value:   data,
+		Headers: []kafka.Header{{Key: KafkaChannelHeader, Value: []byte(dest)}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot send data: %w", err)
+	}
+	log.Debugf("sent message to %v channel", dest)
+	log.Tracef("message: %v", string(data))
+
+	return nil, nil
+}
+
+func (t *Kafka) ReceiveData(data []byte, dest string) error {
+	return t.dataHandler(data, dest)
+}
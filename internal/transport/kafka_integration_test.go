@@ -0,0 +1,65 @@
+//go:build go1.16 && integration
+// +build go1.16,integration
+
+package transport
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// kafkaBrokers returns the addresses of the Kafka brokers to test against,
+// skipping the test if KAFKA_BROKERS is not set.
+func kafkaBrokers(t *testing.T) []string {
+	t.Helper()
+	brokers, ok := os.LookupEnv("KAFKA_BROKERS")
+	if !ok {
+		t.Skip("KAFKA_BROKERS not set")
+	}
+	return strings.Split(brokers, ",")
+}
+
+func TestKafkaIntegration(t *testing.T) {
+	// Consume the outbound topic, so every message sent is received back.
+	topic := "yggdrasil-test-" + time.Now().Format("20060102150405")
+	received := make(chan string, 1)
+	transport, err := NewKafkaTransportWithOptions("test", kafkaBrokers(t), nil, func(data []byte, dest string) error {
+		received <- dest + ":" + string(data)
+		return nil
+	}, KafkaOptions{
+		OutboundTopic: topic,
+		InboundTopic:  topic,
+		Backoff:       ConstantBackoff{Delay: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		// The topic may take a moment to be created on first write.
+		_, err := transport.SendData([]byte(`{"n":1}`), "data")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+	}
+
+	select {
+	case got := <-received:
+		if got != `data:{"n":1}` {
+			t.Errorf("received %v, want %v", got, `data:{"n":1}`)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("message not received")
+	}
+}
@@ -0,0 +1,238 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeProducer is a kafkaProducer that records the messages written to it.
+type fakeProducer struct {
+	mu      sync.Mutex
+	written []kafka.Message
+	closed  bool
+}
+
+func (p *fakeProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written = append(p.written, msgs...)
+	return nil
+}
+
+func (p *fakeProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// fakeConsumer is a kafkaConsumer that returns the messages sent on fetch
+// and records the messages committed.
+type fakeConsumer struct {
+	fetch chan kafka.Message
+
+	mu        sync.Mutex
+	committed []kafka.Message
+	closed    bool
+}
+
+func newFakeConsumer() *fakeConsumer {
+	return &fakeConsumer{fetch: make(chan kafka.Message)}
+}
+
+func (c *fakeConsumer) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-c.fetch:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (c *fakeConsumer) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed = append(c.committed, msgs...)
+	return nil
+}
+
+func (c *fakeConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConsumer) committedOffsets() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var offsets []int64
+	for _, msg := range c.committed {
+		offsets = append(offsets, msg.Offset)
+	}
+	return offsets
+}
+
+// newFakeKafkaTransport creates a Kafka transport using producer and
+// consumer.
+func newFakeKafkaTransport(t *testing.T, producer *fakeProducer, consumer *fakeConsumer, dataRecvFunc DataReceiveHandlerFunc) *Kafka {
+	t.Helper()
+	transport, err := NewKafkaTransport("test", []string{"localhost:9092"}, nil, dataRecvFunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.newProducer = func(*tls.Config) kafkaProducer { return producer }
+	transport.newConsumer = func(*tls.Config) kafkaConsumer { return consumer }
+	return transport
+}
+
+func TestKafkaSendData(t *testing.T) {
+	producer := &fakeProducer{}
+	transport := newFakeKafkaTransport(t, producer, newFakeConsumer(), func([]byte, string) error { return nil })
+
+	if _, err := transport.SendData([]byte("{}"), "data"); err == nil {
+		t.Error("expected an error sending while not connected")
+	}
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{"n":1}`), "control"); err != nil {
+		t.Fatal(err)
+	}
+	transport.Disconnect(0)
+
+	if len(producer.written) != 1 {
+		t.Fatalf("%v messages written, want 1", len(producer.written))
+	}
+	msg := producer.written[0]
+	if string(msg.Key) != "test" {
+		t.Errorf("key %q != test", msg.Key)
+	}
+	if string(msg.Value) != `{"n":1}` {
+		t.Errorf("value %s != %s", msg.Value, `{"n":1}`)
+	}
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != KafkaChannelHeader || string(msg.Headers[0].Value) != "control" {
+		t.Errorf("unexpected headers %v", msg.Headers)
+	}
+	if !producer.closed {
+		t.Error("producer not closed on disconnect")
+	}
+}
+
+func TestKafkaReceive(t *testing.T) {
+	type received struct {
+		data      string
+		channel   string
+		committed int
+	}
+	consumer := newFakeConsumer()
+	messages := make(chan received, 3)
+	transport := newFakeKafkaTransport(t, &fakeProducer{}, consumer, func(data []byte, dest string) error {
+		// The offset must not be committed before the handler returns.
+		messages <- received{data: string(data), channel: dest, committed: len(consumer.committedOffsets())}
+		return nil
+	})
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer.fetch <- kafka.Message{Offset: 1, Key: []byte("test"), Value: []byte("a"), Headers: []kafka.Header{{Key: KafkaChannelHeader, Value: []byte("control")}}}
+	consumer.fetch <- kafka.Message{Offset: 2, Key: []byte("other"), Value: []byte("b")}
+	consumer.fetch <- kafka.Message{Offset: 3, Value: []byte("c")}
+	// Wait for the last message to be committed.
+	eventually(t, time.Second, func() bool { return len(consumer.committedOffsets()) == 3 })
+	transport.Disconnect(0)
+
+	want := []received{
+		{data: "a", channel: "control", committed: 0},
+		{data: "c", channel: "data", committed: 2},
+	}
+	for _, w := range want {
+		if got := <-messages; got != w {
+			t.Errorf("received %+v, want %+v", got, w)
+		}
+	}
+	if got := consumer.committedOffsets(); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("committed offsets %v, want [1 2 3]", got)
+	}
+	if !consumer.closed {
+		t.Error("consumer not closed on disconnect")
+	}
+}
+
+func TestKafkaDisconnectFromHandler(t *testing.T) {
+	consumer := newFakeConsumer()
+	disconnected := make(chan struct{})
+	var transport *Kafka
+	transport = newFakeKafkaTransport(t, &fakeProducer{}, consumer, func([]byte, string) error {
+		// yggd does this on a disconnect command.
+		transport.Disconnect(0)
+		close(disconnected)
+		return nil
+	})
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer.fetch <- kafka.Message{Offset: 1, Value: []byte("disconnect")}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("Disconnect called from the data handler did not return")
+	}
+	eventually(t, time.Second, func() bool {
+		consumer.mu.Lock()
+		defer consumer.mu.Unlock()
+		return consumer.closed
+	})
+}
+
+func TestKafkaReloadTLSConfig(t *testing.T) {
+	configs := make(chan *tls.Config, 2)
+	transport := newFakeKafkaTransport(t, &fakeProducer{}, newFakeConsumer(), func([]byte, string) error { return nil })
+	transport.newConsumer = func(tlsConfig *tls.Config) kafkaConsumer {
+		configs <- tlsConfig
+		return newFakeConsumer()
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+	if got := <-configs; got != nil {
+		t.Errorf("initial TLS config %v, want nil", got)
+	}
+
+	if err := transport.ReloadTLSConfig(&tls.Config{ServerName: "reloaded"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-configs; got == nil || got.ServerName != "reloaded" {
+		t.Errorf("consumer not recreated with the reloaded TLS config, got %v", got)
+	}
+}
+
+func TestNewKafkaTransportDefaults(t *testing.T) {
+	if _, err := NewKafkaTransport("test", nil, nil, func([]byte, string) error { return nil }); err == nil {
+		t.Error("expected an error without brokers")
+	}
+
+	transport, err := NewKafkaTransport("test", []string{"localhost:9092"}, nil, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.opts.GroupID != "test" {
+		t.Errorf("group ID %v != test", transport.opts.GroupID)
+	}
+	if got := transport.opts.InboundTopic; got == "" || got == transport.opts.OutboundTopic {
+		t.Errorf("unexpected inbound topic %q", got)
+	}
+	var _ Transporter = transport
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxMessageSize is the default maximum size, in bytes, of a message
+// passed to SendData. It is generous for the JSON envelopes exchanged with
+// the server, while keeping a runaway worker from uploading hundreds of
+// megabytes.
+const DefaultMaxMessageSize = 1 << 20
+
+// ErrMessageTooLarge is returned, wrapped, when a message passed to SendData
+// exceeds HTTPOptions.MaxMessageSize. It is permanent.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// ErrSendLimitReached is returned when a send is attempted while
+// HTTPOptions.MaxConcurrentSends requests are already in flight and
+// HTTPOptions.FailFastSends is set. It is transient.
+var ErrSendLimitReached = errors.New("too many concurrent sends")
+
+// checkMessageSize returns a permanent error wrapping ErrMessageTooLarge if
+// message exceeds MaxMessageSize.
+func (t *HTTP) checkMessageSize(message []byte) error {
+	return t.checkSize(int64(len(message)))
+}
+
+// checkSize returns a permanent error wrapping ErrMessageTooLarge if a
+// message of n bytes exceeds MaxMessageSize.
+func (t *HTTP) checkSize(n int64) error {
+	if t.opts.MaxMessageSize > 0 && n > int64(t.opts.MaxMessageSize) {
+		return permanentError(fmt.Errorf("%w: %v bytes exceeds the limit of %v bytes", ErrMessageTooLarge, n, t.opts.MaxMessageSize))
+	}
+	return nil
+}
+
+// acquireSendSlot reserves one of the MaxConcurrentSends outbound request
+// slots, waiting for one to free up unless FailFastSends is set. The returned
+// function releases the slot.
+func (t *HTTP) acquireSendSlot(ctx context.Context) (func(), error) {
+	if t.sendSlots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-t.sendSlots }
+
+	select {
+	case t.sendSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if t.opts.FailFastSends {
+		return nil, transientError(ErrSendLimitReached)
+	}
+	select {
+	case t.sendSlots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, transientError(ctx.Err())
+	}
+}
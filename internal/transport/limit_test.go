@@ -0,0 +1,131 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentSends(t *testing.T) {
+	const limit = 3
+	var current, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval:    time.Second,
+		MaxConcurrentSends: limit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > limit {
+		t.Errorf("%v concurrent sends, limit is %v", peak, limit)
+	}
+	if peak < 1 {
+		t.Error("no sends reached the server")
+	}
+}
+
+func TestFailFastSends(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval:    time.Second,
+		MaxConcurrentSends: 1,
+		FailFastSends:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := transport.SendData([]byte(`{}`), "data")
+		done <- err
+	}()
+	<-entered
+
+	_, err = transport.SendData([]byte(`{}`), "data")
+	if !errors.Is(err, ErrSendLimitReached) {
+		t.Errorf("expected ErrSendLimitReached, got %v", err)
+	}
+	if !errors.Is(err, ErrTransientTransport) {
+		t.Errorf("expected the error to be transient, got %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	rt := &countingRoundTripper{}
+	transport, err := NewHTTPTransportWithOptions("test", "localhost:1", nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		MaxMessageSize:  1024,
+		QueueDir:        t.TempDir(),
+		RoundTripper:    rt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.SendData(bytes.Repeat([]byte("a"), 1025), "data")
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("expected a too large error, got %v", err)
+	}
+	if retryable(err) {
+		t.Error("a too large message should not be retried")
+	}
+	if n := atomic.LoadInt32(&rt.requests); n != 0 {
+		t.Errorf("%v requests sent for a too large message", n)
+	}
+	if _, queued := transport.pending(); queued != 0 {
+		t.Errorf("%v messages queued, want none", queued)
+	}
+
+	transport.opts.MaxMessageSize = -1
+	if _, err := transport.SendData(bytes.Repeat([]byte("a"), 2*DefaultMaxMessageSize), "data"); errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("message rejected without a limit: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~spc/go-log"
+)
+
+// Logger is a leveled logger taking structured fields. Each call passes a
+// message and alternating keys and values, such as
+//
+//	logger.Debug("sent HTTP request", "channel", "data", "status", 200)
+//
+// Embedders can adapt zap, slog or any other structured logger to it through
+// HTTPOptions.Logger.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// goLogger is the default Logger. It writes to the go-log package logger,
+// formatting fields as key=value pairs after the message.
+type goLogger struct{}
+
+func (goLogger) Debug(msg string, keyvals ...interface{}) { log.Debug(formatFields(msg, keyvals)) }
+func (goLogger) Info(msg string, keyvals ...interface{})  { log.Info(formatFields(msg, keyvals)) }
+func (goLogger) Warn(msg string, keyvals ...interface{})  { log.Warn(formatFields(msg, keyvals)) }
+func (goLogger) Error(msg string, keyvals ...interface{}) { log.Error(formatFields(msg, keyvals)) }
+
+// formatFields appends keyvals to msg as key=value pairs, quoting values that
+// contain spaces. A key without a value is given the value "(MISSING)".
+func formatFields(msg string, keyvals []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		value := "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = fmt.Sprint(keyvals[i+1])
+		}
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %v=%v", keyvals[i], value)
+	}
+	return b.String()
+}
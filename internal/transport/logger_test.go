@@ -0,0 +1,122 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logEntry is a message logged to a captureLogger.
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// captureLogger is a Logger that records the messages logged to it.
+type captureLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *captureLogger) record(level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *captureLogger) Debug(msg string, keyvals ...interface{}) { l.record("debug", msg, keyvals) }
+func (l *captureLogger) Info(msg string, keyvals ...interface{})  { l.record("info", msg, keyvals) }
+func (l *captureLogger) Warn(msg string, keyvals ...interface{})  { l.record("warn", msg, keyvals) }
+func (l *captureLogger) Error(msg string, keyvals ...interface{}) { l.record("error", msg, keyvals) }
+
+// find returns the first entry logged with msg.
+func (l *captureLogger) find(msg string) (logEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			return entry, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestLoggerFields(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	logger := &captureLogger{}
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		SendRetries:     1,
+		Backoff:         ConstantBackoff{Delay: time.Millisecond},
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = transport.SendData([]byte(`{}`), "data")
+
+	sent, ok := logger.find("sent message")
+	if !ok {
+		t.Fatal("send not logged")
+	}
+	if sent.level != "debug" {
+		t.Errorf("level %v != debug", sent.level)
+	}
+	if got := sent.fields["channel"]; got != "data" {
+		t.Errorf("channel field %v != data", got)
+	}
+	if got := sent.fields["status"]; got != http.StatusServiceUnavailable {
+		t.Errorf("status field %v != %v", got, http.StatusServiceUnavailable)
+	}
+	if got := fmt.Sprint(sent.fields["url"]); !strings.HasSuffix(got, "/data/test/out") {
+		t.Errorf("url field %v is not the outbound URL", got)
+	}
+
+	retry, ok := logger.find("cannot send message, retrying")
+	if !ok {
+		t.Fatal("retry not logged")
+	}
+	if got := retry.fields["attempt"]; got != 1 {
+		t.Errorf("attempt field %v != 1", got)
+	}
+	if _, ok := retry.fields["error"]; !ok {
+		t.Error("retry logged without the error")
+	}
+}
+
+func TestFormatFields(t *testing.T) {
+	tests := []struct {
+		description string
+		keyvals     []interface{}
+		want        string
+	}{
+		{description: "none", want: "msg"},
+		{description: "pairs", keyvals: []interface{}{"channel", "data", "status", 200}, want: "msg channel=data status=200"},
+		{description: "quoted", keyvals: []interface{}{"error", "connection refused"}, want: `msg error="connection refused"`},
+		{description: "empty", keyvals: []interface{}{"id", ""}, want: `msg id=""`},
+		{description: "missing value", keyvals: []interface{}{"channel"}, want: "msg channel=(MISSING)"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := formatFields("msg", test.keyvals); got != test.want {
+				t.Errorf("%q != %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "yggdrasil_http_transport"
+
+// HTTPMetrics is a prometheus.Collector that records the activity of an HTTP
+// transport. Metrics are only collected when an HTTPMetrics is passed in
+// HTTPOptions.Metrics, and only exported once it is registered with a
+// prometheus.Registerer, so embedders that do not use Prometheus are not
+// affected. A nil *HTTPMetrics records nothing.
+type HTTPMetrics struct {
+	requests            *prometheus.CounterVec
+	latency             *prometheus.HistogramVec
+	bytesSent           *prometheus.CounterVec
+	bytesReceived       *prometheus.CounterVec
+	polls               *prometheus.CounterVec
+	consecutiveFailures *prometheus.GaugeVec
+	dropped             *prometheus.CounterVec
+	invalid             *prometheus.CounterVec
+}
+
+// NewHTTPMetrics creates a set of unregistered HTTP transport metrics.
+func NewHTTPMetrics() *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "HTTP requests sent, by channel, direction and status code.",
+		}, []string{"channel", "direction", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency, by channel and direction.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"channel", "direction"}),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sent_bytes_total",
+			Help:      "Request body bytes sent, by channel.",
+		}, []string{"channel"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "received_bytes_total",
+			Help:      "Response body bytes received, by channel.",
+		}, []string{"channel"}),
+		polls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "polls_total",
+			Help:      "Inbound poll iterations, by channel.",
+		}, []string{"channel"}),
+		consecutiveFailures: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "consecutive_failures",
+			Help:      "Consecutive failed polls, by channel.",
+		}, []string{"channel"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_messages_total",
+			Help:      "Outbound messages dropped because the transport was disconnected, by channel.",
+		}, []string{"channel"}),
+		invalid: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "invalid_messages_total",
+			Help:      "Inbound messages dropped because they failed validation, by channel.",
+		}, []string{"channel"}),
+	}
+}
+
+func (m *HTTPMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requests,
+		m.latency,
+		m.bytesSent,
+		m.bytesReceived,
+		m.polls,
+		m.consecutiveFailures,
+		m.dropped,
+		m.invalid,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *HTTPMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *HTTPMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// observeRequest records a request sent in direction ("in", "out", "ack" or
+// "nack") on channel. A statusCode of 0 records a request that failed without a
+// response.
+func (m *HTTPMetrics) observeRequest(channel, direction string, statusCode int, elapsed time.Duration, sent, received int) {
+	if m == nil {
+		return
+	}
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	m.requests.WithLabelValues(channel, direction, code).Inc()
+	m.latency.WithLabelValues(channel, direction).Observe(elapsed.Seconds())
+	m.bytesSent.WithLabelValues(channel).Add(float64(sent))
+	m.bytesReceived.WithLabelValues(channel).Add(float64(received))
+}
+
+// observePoll records a poll iteration on channel, and the number of
+// consecutive failed polls on it so far.
+func (m *HTTPMetrics) observePoll(channel string, failures int) {
+	if m == nil {
+		return
+	}
+	m.polls.WithLabelValues(channel).Inc()
+	m.consecutiveFailures.WithLabelValues(channel).Set(float64(failures))
+}
+
+// observeDropped records an outbound message on channel dropped because the
+// transport was disconnected.
+func (m *HTTPMetrics) observeDropped(channel string) {
+	if m == nil {
+		return
+	}
+	m.dropped.WithLabelValues(channel).Inc()
+}
+
+// observeInvalid records an inbound message on channel dropped because it
+// failed validation.
+func (m *HTTPMetrics) observeInvalid(channel string) {
+	if m == nil {
+		return
+	}
+	m.invalid.WithLabelValues(channel).Inc()
+}
@@ -0,0 +1,169 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherMetric returns the value of the metric called name whose labels
+// match labels, or -1 if there is no such metric.
+func gatherMetric(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if want, ok := labels[pair.GetName()]; ok && want != pair.GetValue() {
+					continue metrics
+				}
+			}
+			return metricValue(metric)
+		}
+	}
+	return -1
+}
+
+func metricValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.Counter != nil:
+		return metric.Counter.GetValue()
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue()
+	case metric.Histogram != nil:
+		return float64(metric.Histogram.GetSampleCount())
+	}
+	return -1
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			// Fail the first two polls, then hold any further poll open
+			// until the transport stops polling.
+			if atomic.AddInt32(&polls, 1) > 2 {
+				<-req.Context().Done()
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"OK"}`))
+	}))
+	defer srv.Close()
+
+	metrics := NewHTTPMetrics()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metrics)
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		Metrics:         metrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	if _, err := transport.SendData([]byte(`{"a":1}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport.wg.Add(1)
+	go transport.poll(ctx, "control")
+	<-clock.sleeps
+	<-clock.sleeps
+	cancel()
+	close(clock.stop)
+	waitGroupTimeout(t, transport, time.Second)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{name: "yggdrasil_http_transport_requests_total", labels: map[string]string{"channel": "data", "direction": "out", "code": "200"}, want: 1},
+		{name: "yggdrasil_http_transport_requests_total", labels: map[string]string{"channel": "control", "direction": "in", "code": "503"}, want: 2},
+		{name: "yggdrasil_http_transport_request_duration_seconds", labels: map[string]string{"channel": "data", "direction": "out"}, want: 1},
+		{name: "yggdrasil_http_transport_sent_bytes_total", labels: map[string]string{"channel": "data"}, want: 7},
+		{name: "yggdrasil_http_transport_received_bytes_total", labels: map[string]string{"channel": "data"}, want: 15},
+		{name: "yggdrasil_http_transport_polls_total", labels: map[string]string{"channel": "control"}, want: 2},
+		{name: "yggdrasil_http_transport_consecutive_failures", labels: map[string]string{"channel": "control"}, want: 2},
+	}
+	for _, test := range tests {
+		if got := gatherMetric(t, reg, test.name, test.labels); got != test.want {
+			t.Errorf("%v%v = %v, want %v", test.name, test.labels, got, test.want)
+		}
+	}
+}
+
+func TestNilHTTPMetrics(t *testing.T) {
+	var metrics *HTTPMetrics
+	metrics.observeRequest("data", "out", 200, time.Second, 1, 1)
+	metrics.observePoll("data", 0)
+	metrics.observeDropped("data")
+}
+
+func TestSendDataDisconnected(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			atomic.AddInt32(&posts, 1)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	metrics := NewHTTPMetrics()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metrics)
+	logger := &captureLogger{}
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		Metrics: metrics,
+		Logger:  logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.Disconnect(0)
+
+	_, err = transport.SendData([]byte(`{}`), "data")
+	if !errors.Is(err, ErrTransportDisconnected) {
+		t.Errorf("got %v, want ErrTransportDisconnected", err)
+	}
+	if !errors.Is(err, ErrTransientTransport) {
+		t.Errorf("got %v, want a transient error", err)
+	}
+	if n := atomic.LoadInt32(&posts); n != 0 {
+		t.Errorf("%v messages sent while disconnected, want 0", n)
+	}
+	if got := gatherMetric(t, reg, "yggdrasil_http_transport_dropped_messages_total", map[string]string{"channel": "data"}); got != 1 {
+		t.Errorf("dropped_messages_total = %v, want 1", got)
+	}
+	if entry, ok := logger.find("transport disconnected, dropping message"); !ok || entry.level != "warn" {
+		t.Error("dropped message not logged as a warning")
+	}
+}
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -136,10 +137,22 @@ func (t *MQTT) Disconnect(quiesce uint) {
 // SendData publishes data to an MQTT topic created by combining client
 // information with dest.
 func (t *MQTT) SendData(data []byte, dest string) ([]byte, error) {
+	return t.SendDataWithContext(context.Background(), data, dest)
+}
+
+// SendDataWithContext publishes data like SendData, but stops waiting for the
+// publication to complete when ctx is done.
+func (t *MQTT) SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error) {
 	opts := t.client.OptionsReader()
 	topic := fmt.Sprintf("%v/%v/%v/out", yggdrasil.PathPrefix, opts.ClientID(), dest)
 
-	if token := t.client.Publish(topic, 1, false, data); token.Wait() && token.Error() != nil {
+	token := t.client.Publish(topic, 1, false, data)
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		return nil, fmt.Errorf("cannot publish message: %w", ctx.Err())
+	}
+	if token.Error() != nil {
 		log.Errorf("failed to publish message: %v", token.Error())
 		return nil, token.Error()
 	}
@@ -150,6 +163,5 @@ func (t *MQTT) SendData(data []byte, dest string) ([]byte, error) {
 }
 
 func (t *MQTT) ReceiveData(data []byte, dest string) error {
-	t.receiveHandler(data, dest)
-	return nil
+	return t.receiveHandler(data, dest)
 }
@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NextPollHeader is the response header with which the server hints how long,
+// in seconds, the client should wait before polling the channel again. The
+// hint replaces the polling interval for the next poll, and takes precedence
+// over MoreAvailableHeader, within the bounds of HTTPOptions.MinNextPoll and
+// MaxNextPoll. This lets the server throttle idle clients and speed up busy
+// ones. A malformed hint is ignored.
+const NextPollHeader = "X-Yggdrasil-Next-Poll"
+
+const (
+	// DefaultMinNextPoll is the default lower bound on a next-poll hint.
+	DefaultMinNextPoll = time.Second
+
+	// DefaultMaxNextPoll is the default upper bound on a next-poll hint.
+	DefaultMaxNextPoll = time.Hour
+)
+
+// pollResult describes the outcome of a successful poll.
+type pollResult struct {
+	// received is true if a message was received and dispatched.
+	received bool
+
+	// more is true if the server has more messages waiting, as signalled
+	// by MoreAvailableHeader.
+	more bool
+
+	// nextPoll is the delay hinted by NextPollHeader, if hinted is true.
+	nextPoll time.Duration
+	hinted   bool
+}
+
+// parseNextPoll parses the value of a NextPollHeader header, given as a
+// possibly fractional number of seconds. It returns false if the value is
+// empty, malformed or negative.
+func parseNextPoll(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, false
+	}
+	if seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return time.Duration(math.MaxInt64), true
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// clampNextPoll bounds a next-poll hint by MinNextPoll and MaxNextPoll.
+func (t *HTTP) clampNextPoll(d time.Duration) time.Duration {
+	switch {
+	case d < t.opts.MinNextPoll:
+		return t.opts.MinNextPoll
+	case d > t.opts.MaxNextPoll:
+		return t.opts.MaxNextPoll
+	default:
+		return d
+	}
+}
+
+// newPollResult returns the result of a poll whose response had the given
+// header, before any message in it is handled.
+func newPollResult(header http.Header) pollResult {
+	var result pollResult
+	result.more = strings.EqualFold(strings.TrimSpace(header.Get(MoreAvailableHeader)), "true")
+	result.nextPoll, result.hinted = parseNextPoll(header.Get(NextPollHeader))
+	return result
+}
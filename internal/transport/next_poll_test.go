@@ -0,0 +1,88 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNextPoll(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "30", want: 30 * time.Second, wantOK: true},
+		{value: " 0.5 ", want: 500 * time.Millisecond, wantOK: true},
+		{value: "0", want: 0, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: "soon", wantOK: false},
+		{value: "NaN", wantOK: false},
+		{value: "Inf", wantOK: false},
+	}
+	for _, test := range tests {
+		got, ok := parseNextPoll(test.value)
+		if ok != test.wantOK || got != test.want {
+			t.Errorf("parseNextPoll(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestPollHonorsNextPollHint(t *testing.T) {
+	tests := []struct {
+		description string
+		hint        string
+		more        bool
+		want        time.Duration
+	}{
+		{description: "no hint", want: 10 * time.Second},
+		{description: "invalid hint", hint: "later", want: 10 * time.Second},
+		{description: "slower", hint: "60", want: time.Minute},
+		{description: "faster", hint: "2.5", want: 2500 * time.Millisecond},
+		{description: "below minimum", hint: "0", want: time.Second},
+		{description: "above maximum", hint: "86400", want: 5 * time.Minute},
+		{description: "with more available", hint: "30", more: true, want: 30 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if test.hint != "" {
+					w.Header().Set(NextPollHeader, test.hint)
+				}
+				if test.more {
+					w.Header().Set(MoreAvailableHeader, "true")
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+				PollingInterval: 10 * time.Second,
+				MaxNextPoll:     5 * time.Minute,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := newFakeClock()
+			transport.clock = clock
+
+			ctx, cancel := context.WithCancel(context.Background())
+			transport.wg.Add(1)
+			go transport.poll(ctx, "control")
+
+			if d := <-clock.sleeps; d != test.want {
+				t.Errorf("poll waited %v, want %v", d, test.want)
+			}
+			cancel()
+			close(clock.stop)
+			waitGroupTimeout(t, transport, time.Second)
+		})
+	}
+}
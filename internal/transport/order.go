@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// sendOrder serializes the sends to each channel, in the order they were
+// submitted, if OrderedSends is set. A nil *sendOrder lets sends proceed
+// concurrently.
+type sendOrder struct {
+	mu sync.Mutex
+	// tails holds, for each channel with sends in progress, a channel
+	// closed once the last send submitted to it completes.
+	tails map[string]chan struct{}
+}
+
+func newSendOrder() *sendOrder {
+	return &sendOrder{tails: make(map[string]chan struct{})}
+}
+
+// acquire waits for the sends previously submitted to channel to complete.
+// The returned function must be called once the send completes.
+func (o *sendOrder) acquire(ctx context.Context, channel string) (func(), error) {
+	if o == nil {
+		return func() {}, nil
+	}
+	done := make(chan struct{})
+	o.mu.Lock()
+	prev := o.tails[channel]
+	o.tails[channel] = done
+	o.mu.Unlock()
+
+	release := func() {
+		o.mu.Lock()
+		if o.tails[channel] == done {
+			delete(o.tails, channel)
+		}
+		o.mu.Unlock()
+		close(done)
+	}
+	if prev == nil {
+		return release, nil
+	}
+	select {
+	case <-prev:
+		return release, nil
+	case <-ctx.Done():
+		// The sends submitted after this one must still wait for those
+		// submitted before it.
+		go func() {
+			<-prev
+			release()
+		}()
+		return nil, transientError(ctx.Err())
+	}
+}
@@ -0,0 +1,115 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrderedSends(t *testing.T) {
+	const n = 20
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) == "0" {
+			<-release
+		}
+		// Vary the handling time, so that unordered sends would be
+		// received out of order.
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		mu.Lock()
+		got = append(got, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		OrderedSends: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := transport.SendData([]byte(fmt.Sprint(i)), "data"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		// Let each send be submitted before the next.
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Sends to another channel are not held up by those to data.
+	if _, err := transport.SendData([]byte("control"), "control"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	wg.Wait()
+
+	want := []string{"control"}
+	for i := 0; i < n; i++ {
+		want = append(want, fmt.Sprint(i))
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("server received %v, want %v", got, want)
+	}
+}
+
+func TestOrderedSendsCancelled(t *testing.T) {
+	order := newSendOrder()
+	first, err := order.acquire(context.Background(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := order.acquire(ctx, "data"); !errors.Is(err, ErrTransientTransport) || !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want a transient context.Canceled", err)
+	}
+
+	// A later send still waits for the first, ahead of the cancelled one.
+	acquired := make(chan struct{})
+	go func() {
+		release, err := order.acquire(context.Background(), "data")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		release()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("send proceeded before the previous one completed")
+	case <-time.After(20 * time.Millisecond):
+	}
+	first()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("send did not proceed after the previous one completed")
+	}
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	if len(order.tails) != 0 {
+		t.Errorf("%v channels left with sends in progress, want 0", len(order.tails))
+	}
+}
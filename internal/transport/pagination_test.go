@@ -0,0 +1,77 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPaginatedReceive(t *testing.T) {
+	var pages []string
+	for i := 0; i < 5; i++ {
+		pages = append(pages, fmt.Sprintf(`{"message_id":"%v"}`, i))
+	}
+
+	var serverMu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.Contains(req.URL.Path, "/data/") {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		serverMu.Lock()
+		defer serverMu.Unlock()
+		if len(pages) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		page := pages[0]
+		pages = pages[1:]
+		if len(pages) > 0 {
+			w.Header().Set(MoreAvailableHeader, "true")
+		}
+		_, _ = w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+	// The polling interval is long enough that the backlog is only drained in
+	// time if pages are fetched back to back.
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Hour, func(data []byte, channel string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received[string(data)]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+
+	eventually(t, 2*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 5
+	})
+	// Give a duplicate delivery a chance to show up.
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < 5; i++ {
+		message := fmt.Sprintf(`{"message_id":"%v"}`, i)
+		if n := received[message]; n != 1 {
+			t.Errorf("message %v received %v times, want once", i, n)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// An AuthError is returned by Ping when the server rejects the client's
+// credentials with a 401 or 403 response. It is a permanent error.
+type AuthError struct {
+	StatusCode int
+}
+
+func (e AuthError) Error() string {
+	return fmt.Sprintf("server rejected client credentials: %v %v", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Is classifies the error as permanent.
+func (e AuthError) Is(target error) bool {
+	return target == ErrPermanentTransport
+}
+
+// Ping checks that the server is reachable and accepts the client's
+// credentials by sending a HEAD request to the health URL of the client,
+// independently of polling. It returns nil if the server responds with a 2xx
+// status, an AuthError if it responds with 401 or 403, and a transient error
+// otherwise.
+func (t *HTTP) Ping(ctx context.Context) error {
+	ctx, span := t.tracer.Start(ctx, "Ping", trace.WithSpanKind(trace.SpanKindClient))
+	err := t.ping(ctx)
+	endSpan(span, err)
+	return err
+}
+
+func (t *HTTP) ping(ctx context.Context) error {
+	if t.opts.DryRun {
+		t.opts.Logger.Info("dry run, not pinging server", "url", t.getUrl("health", "control"))
+		return nil
+	}
+
+	reqURL, err := t.requestURL("health", "control")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, reqURL, nil)
+	if err != nil {
+		return permanentError(fmt.Errorf("cannot create HTTP request: %w", err))
+	}
+	t.setHeaders(req.Header)
+	injectTraceContext(ctx, req.Header)
+	resp, err := t.do(t.client, req)
+	if err != nil {
+		return requestError(fmt.Errorf("cannot ping server: %w", err))
+	}
+	resp.Body.Close()
+	t.checkDate(resp)
+	trace.SpanFromContext(ctx).SetAttributes(statusCodeKey.Int(resp.StatusCode))
+	t.opts.Logger.Debug("pinged server", "url", req.URL, "status", resp.StatusCode)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return AuthError{StatusCode: resp.StatusCode}
+	default:
+		return transientError(fmt.Errorf("cannot ping server: %v %v", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+}
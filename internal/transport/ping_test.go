@@ -0,0 +1,75 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		description string
+		status      int
+		wantAuth    bool
+		wantClass   error
+	}{
+		{description: "ok", status: http.StatusOK},
+		{description: "no content", status: http.StatusNoContent},
+		{description: "unauthorized", status: http.StatusUnauthorized, wantAuth: true, wantClass: ErrPermanentTransport},
+		{description: "forbidden", status: http.StatusForbidden, wantAuth: true, wantClass: ErrPermanentTransport},
+		{description: "not found", status: http.StatusNotFound, wantClass: ErrTransientTransport},
+		{description: "server error", status: http.StatusServiceUnavailable, wantClass: ErrTransientTransport},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var method, path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				method, path = req.Method, req.URL.Path
+				w.WriteHeader(test.status)
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", 0, func([]byte, string) error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = transport.Ping(context.Background())
+
+			if method != http.MethodHead || !strings.HasSuffix(path, "/control/test/health") {
+				t.Errorf("pinged with %v %v, want HEAD .../control/test/health", method, path)
+			}
+			if test.wantClass == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var authErr AuthError
+			if got := errors.As(err, &authErr); got != test.wantAuth {
+				t.Errorf("error %v is an AuthError: %v, want %v", err, got, test.wantAuth)
+			}
+			if !errors.Is(err, test.wantClass) {
+				t.Errorf("error %v is not %v", err, test.wantClass)
+			}
+		})
+	}
+}
+
+func TestPingUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", 0, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Ping(context.Background()); !errors.Is(err, ErrTransientTransport) {
+		t.Errorf("error %v is not transient", err)
+	}
+}
@@ -0,0 +1,305 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManualPoll(t *testing.T) {
+	var polls int32
+	var mu sync.Mutex
+	messages := []string{"first"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.Contains(req.URL.Path, "/data/") || len(messages) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(messages[0]))
+		messages = messages[1:]
+	}))
+	defer srv.Close()
+
+	for _, workers := range []int{0, 1} {
+		mu.Lock()
+		messages = []string{"first"}
+		mu.Unlock()
+		atomic.StoreInt32(&polls, 0)
+
+		received := make(chan string, 1)
+		transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+			received <- string(data)
+			return nil
+		}, HTTPOptions{
+			PollingInterval:  time.Millisecond,
+			ManualPoll:       true,
+			SkipConnectProbe: true,
+			ReceiveWorkers:   workers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := transport.Poll(context.Background(), "data"); err == nil {
+			t.Error("expected an error polling before connecting")
+		}
+		if err := transport.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := atomic.LoadInt32(&polls); n != 0 {
+			t.Fatalf("%v polls without calling Poll", n)
+		}
+
+		got, err := transport.Poll(context.Background(), "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got {
+			t.Error("Poll reported no message")
+		}
+		select {
+		case data := <-received:
+			if data != "first" {
+				t.Errorf("received %q, want %q", data, "first")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message not dispatched")
+		}
+
+		if got, err := transport.Poll(context.Background(), "data"); err != nil || got {
+			t.Errorf("Poll of an empty channel = %v, %v, want false, nil", got, err)
+		}
+		if _, err := transport.Poll(context.Background(), "other"); err == nil {
+			t.Error("expected an error polling an unknown channel")
+		}
+
+		transport.Disconnect(0)
+		waitGroupTimeout(t, transport, time.Second)
+		if n := atomic.LoadInt32(&polls); n != 2 {
+			t.Errorf("%v polls, want 2", n)
+		}
+	}
+}
+
+func TestPollRetries(t *testing.T) {
+	tests := []struct {
+		description string
+		retries     int
+		status      int
+		want        []time.Duration
+		received    bool
+	}{
+		{
+			description: "network errors",
+			retries:     3,
+			want:        []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, time.Minute},
+			received:    true,
+		},
+		{
+			description: "server errors",
+			retries:     3,
+			status:      http.StatusServiceUnavailable,
+			want:        []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, time.Minute},
+			received:    true,
+		},
+		{
+			description: "retries exhausted",
+			retries:     1,
+			want:        []time.Duration{100 * time.Millisecond, 7 * time.Second},
+		},
+		{
+			description: "permanent error",
+			retries:     3,
+			status:      http.StatusBadRequest,
+			want:        []time.Duration{7 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// The server fails the first two polls, then delivers a message.
+			var polls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if atomic.AddInt32(&polls, 1) > 2 {
+					w.Write([]byte(`{}`))
+					return
+				}
+				if test.status != 0 {
+					w.WriteHeader(test.status)
+					return
+				}
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.Close()
+			}))
+			defer srv.Close()
+
+			received := make(chan struct{}, 1)
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+				received <- struct{}{}
+				return nil
+			}, HTTPOptions{
+				PollingInterval: time.Minute,
+				Backoff:         ConstantBackoff{Delay: 7 * time.Second},
+				PollRetries:     test.retries,
+				PollRetryDelay:  100 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := newFakeClock()
+			transport.clock = clock
+
+			ctx, cancel := context.WithCancel(context.Background())
+			transport.wg.Add(1)
+			go transport.poll(ctx, "data")
+
+			for i, want := range test.want {
+				if d := <-clock.sleeps; d != want {
+					t.Errorf("sleep %v: waited %v, want %v", i+1, d, want)
+				}
+			}
+			select {
+			case <-received:
+				if !test.received {
+					t.Error("message received")
+				}
+			default:
+				if test.received {
+					t.Error("message not received")
+				}
+			}
+			cancel()
+			close(clock.stop)
+			waitGroupTimeout(t, transport, time.Second)
+		})
+	}
+}
+
+func TestPollTruncatedBody(t *testing.T) {
+	const message = `{"message_id":"1"}`
+	tests := []struct {
+		description string
+		retries     int
+		want        []time.Duration
+		received    bool
+	}{
+		{
+			description: "retried at once",
+			want:        []time.Duration{time.Minute},
+			received:    true,
+		},
+		{
+			description: "retries exhausted",
+			retries:     1,
+			want:        []time.Duration{7 * time.Second},
+		},
+		{
+			description: "retries disabled",
+			retries:     -1,
+			want:        []time.Duration{7 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// The server drops the connection partway through the body of
+			// the first two polls, then delivers the message whole.
+			var polls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if atomic.AddInt32(&polls, 1) > 2 {
+					w.Write([]byte(message))
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(message)))
+				w.Write([]byte(message[:5]))
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}))
+			defer srv.Close()
+
+			received := make(chan string, 3)
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+				received <- string(data)
+				return nil
+			}, HTTPOptions{
+				PollingInterval:      time.Minute,
+				Backoff:              ConstantBackoff{Delay: 7 * time.Second},
+				TruncatedBodyRetries: test.retries,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := newFakeClock()
+			transport.clock = clock
+
+			ctx, cancel := context.WithCancel(context.Background())
+			transport.wg.Add(1)
+			go transport.poll(ctx, "data")
+
+			for i, want := range test.want {
+				if d := <-clock.sleeps; d != want {
+					t.Errorf("sleep %v: waited %v, want %v", i+1, d, want)
+				}
+			}
+			select {
+			case data := <-received:
+				if !test.received {
+					t.Errorf("message %q received", data)
+				} else if data != message {
+					t.Errorf("received %q, want %q", data, message)
+				}
+			default:
+				if test.received {
+					t.Error("message not received")
+				}
+			}
+			cancel()
+			close(clock.stop)
+			waitGroupTimeout(t, transport, time.Second)
+		})
+	}
+}
+
+func TestFetchTruncatedBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(`{"mess`))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+		t.Error("data handler called")
+		return nil
+	}, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.fetch(context.Background(), "data", false)
+	if !errors.Is(err, ErrTruncatedBody) {
+		t.Errorf("got %v, want ErrTruncatedBody", err)
+	}
+	if !retryable(err) {
+		t.Errorf("%v is not retryable", err)
+	}
+}
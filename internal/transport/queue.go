@@ -0,0 +1,260 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultQueueSize is the default maximum number of messages held in the
+// outbound queue.
+const DefaultQueueSize = 1000
+
+// queueFileExt is the file name extension of queued messages.
+const queueFileExt = ".msg"
+
+// queueTempExt is the file name extension of messages being written to the
+// queue.
+const queueTempExt = ".tmp"
+
+// ErrQueueFull is returned when a message cannot be queued because the
+// outbound queue is full of messages that are being sent.
+var ErrQueueFull = errors.New("outbound queue full")
+
+// queuedMessage is the on-disk representation of an outbound message.
+type queuedMessage struct {
+	Channel        string `json:"channel"`
+	Data           []byte `json:"data"`
+	ContentType    string `json:"content_type,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// diskQueue is a bounded, persistent FIFO of outbound messages. Each message
+// is stored in its own file in dir, named with a sequence number so that
+// lexical order is submission order. When the queue is full, the oldest
+// message that is not being sent is dropped to make room for a new one.
+type diskQueue struct {
+	dir  string
+	size int
+	log  Logger
+
+	mu       sync.Mutex
+	seq      uint64
+	inflight map[string]bool
+}
+
+// openDiskQueue opens the queue stored in dir, creating dir if necessary and
+// removing messages whose writing was interrupted.
+func openDiskQueue(dir string, size int, logger Logger) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create queue directory: %w", err)
+	}
+	tmps, err := filepath.Glob(filepath.Join(dir, "*"+queueTempExt))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read queue directory: %w", err)
+	}
+	for _, tmp := range tmps {
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot remove partially written message: %w", err)
+		}
+	}
+	q := &diskQueue{
+		dir:      dir,
+		size:     size,
+		log:      logger,
+		inflight: make(map[string]bool),
+	}
+	ids, err := q.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		last := strings.TrimSuffix(ids[len(ids)-1], queueFileExt)
+		q.seq, err = strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse queue sequence number: %w", err)
+		}
+	}
+	return q, nil
+}
+
+// list returns the IDs of all queued messages, oldest first.
+func (q *diskQueue) list() ([]string, error) {
+	entries, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read queue directory: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.HasSuffix(entry.Name(), queueFileExt) {
+			ids = append(ids, entry.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// push stores a message sent as contentType with the given idempotency key,
+// if any, dropping the oldest messages that are not claimed if the queue is
+// full, or failing with ErrQueueFull if they all are. The returned ID is
+// claimed by the caller, who must release it.
+func (q *diskQueue) push(channel string, data []byte, contentType string, idempotencyKey string) (string, error) {
+	content, err := json.Marshal(queuedMessage{Channel: channel, Data: data, ContentType: contentType, IdempotencyKey: idempotencyKey})
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal queued message: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ids, err := q.list()
+	if err != nil {
+		return "", err
+	}
+	// Messages being sent are left alone: their senders still need them.
+	dropped := 0
+	for i := 0; len(ids)-dropped >= q.size; i++ {
+		if i == len(ids) {
+			return "", transientError(ErrQueueFull)
+		}
+		if q.inflight[ids[i]] {
+			continue
+		}
+		q.log.Warn("outbound queue is full, dropping oldest message", "message", ids[i])
+		if err := os.Remove(filepath.Join(q.dir, ids[i])); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("cannot drop queued message: %w", err)
+		}
+		dropped++
+	}
+
+	q.seq++
+	id := fmt.Sprintf("%020d%v", q.seq, queueFileExt)
+	// Write to a temporary file first so that a crash never leaves a
+	// partially written message in the queue.
+	tmp := filepath.Join(q.dir, id+queueTempExt)
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("cannot write queued message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, id)); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("cannot write queued message: %w", err)
+	}
+	q.inflight[id] = true
+
+	return id, nil
+}
+
+// read returns the message stored under id.
+func (q *diskQueue) read(id string) (queuedMessage, error) {
+	var msg queuedMessage
+	content, err := ioutil.ReadFile(filepath.Join(q.dir, id))
+	if err != nil {
+		return msg, fmt.Errorf("cannot read queued message: %w", err)
+	}
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return msg, fmt.Errorf("cannot unmarshal queued message: %w", err)
+	}
+	return msg, nil
+}
+
+// remove deletes the message stored under id.
+func (q *diskQueue) remove(id string) error {
+	if err := os.Remove(filepath.Join(q.dir, id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove queued message: %w", err)
+	}
+	return nil
+}
+
+// claim marks id as being sent, returning false if it is already claimed.
+func (q *diskQueue) claim(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.inflight[id] {
+		return false
+	}
+	q.inflight[id] = true
+	return true
+}
+
+// release clears the claim on id.
+func (q *diskQueue) release(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inflight, id)
+}
+
+// sendQueued sends a message that has been pushed onto the queue under id,
+// removing it from the queue once it is acknowledged.
+func (t *HTTP) sendQueued(ctx context.Context, id string, message []byte, channel string) ([]byte, error) {
+	defer t.queue.release(id)
+
+	data, err := t.post(ctx, message, channel)
+	if !retryable(err) {
+		if err != nil {
+			t.opts.Logger.Error("server rejected queued message, dropping it", "channel", channel, "message", id, "error", err)
+		}
+		if rerr := t.queue.remove(id); rerr != nil {
+			t.opts.Logger.Error("cannot remove queued message", "message", id, "error", rerr)
+		}
+	}
+	return data, err
+}
+
+// drain sends queued messages every polling interval until ctx is cancelled.
+func (t *HTTP) drain(ctx context.Context) {
+	defer t.wg.Done()
+
+	for {
+		t.drainQueue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.clock.After(t.opts.PollingInterval):
+		}
+	}
+}
+
+// drainQueue sends queued messages, oldest first, stopping at the first
+// message that cannot be delivered so that delivery order is preserved.
+func (t *HTTP) drainQueue(ctx context.Context) {
+	ids, err := t.queue.list()
+	if err != nil {
+		t.opts.Logger.Error("cannot list queued messages", "error", err)
+		return
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		if !t.queue.claim(id) {
+			continue
+		}
+		msg, err := t.queue.read(id)
+		if err != nil {
+			t.opts.Logger.Error("dropping unreadable queued message", "message", id, "error", err)
+			if err := t.queue.remove(id); err != nil {
+				t.opts.Logger.Error("cannot remove queued message", "message", id, "error", err)
+			}
+			t.queue.release(id)
+			continue
+		}
+		msgCtx := WithContentType(ctx, msg.ContentType)
+		if msg.IdempotencyKey != "" {
+			msgCtx = withIdempotencyKey(msgCtx, msg.IdempotencyKey)
+		}
+		if _, err := t.sendQueued(msgCtx, id, msg.Data, msg.Channel); retryable(err) {
+			t.opts.Logger.Debug("cannot send queued message, retrying", "channel", msg.Channel, "message", id, "delay", t.opts.PollingInterval, "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiskQueueDropsOldest(t *testing.T) {
+	q, err := openDiskQueue(t.TempDir(), 2, goLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		id, err := q.push("data", []byte(fmt.Sprintf("message %v", i)), DefaultContentType, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.release(id)
+	}
+
+	ids, err := q.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, id := range ids {
+		msg, err := q.read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(msg.Data))
+	}
+	want := []string{"message 1", "message 2"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+}
+
+func TestDiskQueueKeepsClaimedMessages(t *testing.T) {
+	q, err := openDiskQueue(t.TempDir(), 2, goLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first message is still being sent; the second is not.
+	if _, err := q.push("data", []byte("message 0"), DefaultContentType, ""); err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.push("data", []byte("message 1"), DefaultContentType, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.release(second)
+
+	if _, err := q.push("data", []byte("message 2"), DefaultContentType, ""); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := q.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, id := range ids {
+		msg, err := q.read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(msg.Data))
+	}
+	want := []string{"message 0", "message 2"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+
+	// Now that every queued message is being sent, there is no room left.
+	if _, err := q.push("data", []byte("message 3"), DefaultContentType, ""); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got %v, want ErrQueueFull", err)
+	}
+}
+
+func TestDiskQueueRemovesPartialMessages(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "00000000000000000001.msg.tmp")
+	if err := ioutil.WriteFile(tmp, []byte(`{"chan`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openDiskQueue(dir, 10, goLogger{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("partially written message left in the queue: %v", err)
+	}
+}
+
+func TestQueueNotOpenedForInvalidOptions(t *testing.T) {
+	tests := []struct {
+		description string
+		clientID    string
+		server      string
+		opts        HTTPOptions
+	}{
+		{description: "client ID", clientID: "..", server: "localhost"},
+		{description: "server", clientID: "test", server: "localhost/api"},
+		{description: "write server", clientID: "test", server: "localhost", opts: HTTPOptions{WriteServer: ":8080"}},
+		{description: "channel", clientID: "test", server: "localhost", opts: HTTPOptions{Channels: []Channel{"a/b"}}},
+		{description: "encryption key", clientID: "test", server: "localhost", opts: HTTPOptions{EncryptionKeys: map[string][]byte{"key": []byte("short")}}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "queue")
+			test.opts.QueueDir = dir
+			if _, err := NewHTTPTransportWithOptions(test.clientID, test.server, nil, "testUA", nil, test.opts); err == nil {
+				t.Fatal("expected an error")
+			}
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("queue directory created for a transport that was not: %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskQueueReopen(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openDiskQueue(dir, 10, goLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := q.push("data", []byte("first"), DefaultContentType, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = openDiskQueue(dir, 10, goLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.push("data", []byte("second"), DefaultContentType, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second <= first {
+		t.Errorf("message %v queued after %v does not sort after it", second, first)
+	}
+}
+
+func TestQueueReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	// Queue a message while the server is unreachable.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(down.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Hour,
+		QueueDir:        dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The send succeeds, leaving the message queued.
+	if _, err := transport.SendData([]byte(`{"n":1}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new transport using the same queue delivers it once connected.
+	received := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(req.Body)
+			received <- req.URL.Path + " " + string(body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	transport, err = NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Hour,
+		QueueDir:        dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		if want := `/yggdrasil/data/test/out {"n":1}`; got != want {
+			t.Errorf("%v != %v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued message was not replayed")
+	}
+	// Disconnecting aborts sends in flight, so quiesce to let the replayed
+	// message be acknowledged.
+	transport.Disconnect(1000)
+	waitGroupTimeout(t, transport, time.Second)
+
+	ids, err := transport.queue.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("acknowledged messages remain queued: %v", ids)
+	}
+}
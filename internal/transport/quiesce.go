@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// quiescePollInterval is how often quiesce checks for outstanding sends.
+const quiescePollInterval = 10 * time.Millisecond
+
+// quiesce waits until no sends are in flight and the outbound queue is empty,
+// delivering queued messages in the meantime, or until timeout has elapsed.
+func (t *HTTP) quiesce(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		inflight, queued := t.pending()
+		if inflight == 0 && queued == 0 {
+			return
+		}
+		if t.queue != nil && !t.disconnected.Load().(bool) {
+			t.drainQueue(ctx)
+			if inflight, queued = t.pending(); inflight == 0 && queued == 0 {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			t.opts.Logger.Warn("quiesce timed out, dropping pending messages", "timeout", timeout, "inflight", inflight, "queued", queued)
+			return
+		case <-time.After(quiescePollInterval):
+		}
+	}
+}
+
+// pending returns the number of sends in flight and of messages in the
+// outbound queue.
+func (t *HTTP) pending() (inflight int, queued int) {
+	inflight = int(atomic.LoadInt32(&t.inflight))
+	if t.queue != nil {
+		ids, err := t.queue.list()
+		if err != nil {
+			t.opts.Logger.Error("cannot list queued messages", "error", err)
+		}
+		queued = len(ids)
+	}
+	return inflight, queued
+}
@@ -0,0 +1,118 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDisconnectWaitsForInflightSend(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	<-entered
+
+	start := time.Now()
+	transport.Disconnect(5000)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Disconnect took %v, should return once the send completed", elapsed)
+	}
+	select {
+	case <-done:
+	default:
+		t.Error("Disconnect returned before the in-flight send completed")
+	}
+}
+
+func TestDisconnectQuiesceDeadline(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _, _ = transport.SendData([]byte(`{}`), "data") }()
+	<-entered
+
+	start := time.Now()
+	transport.Disconnect(100)
+	elapsed := time.Since(start)
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("Disconnect returned after %v, before the quiesce deadline", elapsed)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("Disconnect took %v, should give up at the quiesce deadline", elapsed)
+	}
+}
+
+func TestDisconnectDrainsQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var healthy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Hour,
+		QueueDir:        dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	if _, queued := transport.pending(); queued != 1 {
+		t.Fatalf("%v messages queued, want 1", queued)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	start := time.Now()
+	transport.Disconnect(5000)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Disconnect took %v, should return once the queue is empty", elapsed)
+	}
+	if _, queued := transport.pending(); queued != 0 {
+		t.Errorf("%v messages still queued after Disconnect", queued)
+	}
+}
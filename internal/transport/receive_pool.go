@@ -0,0 +1,78 @@
+package transport
+
+import "sync"
+
+// receivePool hands inbound messages to a fixed number of workers through a
+// bounded queue. A nil *receivePool handles each message on the calling
+// goroutine.
+type receivePool struct {
+	workers int
+	jobs    chan func()
+	logger  Logger
+}
+
+func newReceivePool(workers int, queueSize int, logger Logger) *receivePool {
+	return &receivePool{
+		workers: workers,
+		jobs:    make(chan func(), queueSize),
+		logger:  logger,
+	}
+}
+
+// start starts the workers, which run until the polling goroutines counted
+// by polling have returned, so that nothing more can be queued, and then
+// handle any messages still queued before returning. wg is incremented for
+// each worker.
+func (p *receivePool) start(wg *sync.WaitGroup, polling *sync.WaitGroup) {
+	if p == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		polling.Wait()
+		close(stopped)
+	}()
+	wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-p.jobs:
+					job()
+				case <-stopped:
+					p.drain()
+					return
+				}
+			}
+		}()
+	}
+}
+
+// drain runs the queued jobs until the queue is empty.
+func (p *receivePool) drain() {
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+		default:
+			return
+		}
+	}
+}
+
+// submit queues job for a worker, blocking while the queue is full so that
+// the caller stops polling until the workers catch up.
+func (p *receivePool) submit(channel string, job func()) {
+	if p == nil {
+		job()
+		return
+	}
+	select {
+	case p.jobs <- job:
+		return
+	default:
+	}
+	p.logger.Debug("receive queue full, pausing polling", "channel", channel, "queue_size", cap(p.jobs))
+	p.jobs <- job
+}
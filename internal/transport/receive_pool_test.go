@@ -0,0 +1,120 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// messageServer serves a message on every poll of the data channel, counting
+// the polls.
+func messageServer(polls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.Contains(req.URL.Path, "/data/") {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		atomic.AddInt32(polls, 1)
+		w.Write([]byte("message"))
+	}))
+}
+
+func TestReceiveWorkersConcurrency(t *testing.T) {
+	const workers = 3
+
+	var polls int32
+	srv := messageServer(&polls)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var running, maxRunning int
+	release := make(chan struct{})
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}, HTTPOptions{
+		PollingInterval:  time.Millisecond,
+		ReceiveWorkers:   workers,
+		ReceiveQueueSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == workers
+	})
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if maxRunning != workers {
+		t.Errorf("%v messages handled at once, want %v", maxRunning, workers)
+	}
+	mu.Unlock()
+
+	close(release)
+	transport.Disconnect(0)
+	waitGroupTimeout(t, transport, time.Second)
+}
+
+func TestReceiveWorkersBackpressure(t *testing.T) {
+	var polls int32
+	srv := messageServer(&polls)
+	defer srv.Close()
+
+	var handled int32
+	release := make(chan struct{})
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return nil
+	}, HTTPOptions{
+		PollingInterval:  time.Millisecond,
+		ReceiveWorkers:   1,
+		ReceiveQueueSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// One message is being handled, two are queued and the poll that
+	// fetched the fourth waits for room in the queue.
+	const saturated = 4
+	eventually(t, time.Second, func() bool { return atomic.LoadInt32(&polls) == saturated })
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != saturated {
+		t.Fatalf("%v polls with a full queue, want %v", n, saturated)
+	}
+
+	close(release)
+	eventually(t, time.Second, func() bool { return atomic.LoadInt32(&polls) > saturated })
+
+	transport.Disconnect(0)
+	waitGroupTimeout(t, transport, time.Second)
+	if n, p := atomic.LoadInt32(&handled), atomic.LoadInt32(&polls); n != p {
+		t.Errorf("%v messages handled after disconnecting, want %v", n, p)
+	}
+}
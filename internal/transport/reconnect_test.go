@@ -0,0 +1,112 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectAfterPermanentFailure(t *testing.T) {
+	var revoked int32 = 1
+	polls := make(chan string, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&revoked) != 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		polls <- req.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	events := make(chan Event, 10)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Hour,
+		OnEvent:         func(event Event) { events <- event },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transport.Connect(); !errors.Is(err, ErrPermanentTransport) {
+		t.Fatalf("connect error %v, want a permanent error", err)
+	}
+	if transport.State().Connected {
+		t.Fatal("polling after a permanent failure")
+	}
+
+	atomic.StoreInt32(&revoked, 0)
+	if err := transport.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer waitGroupTimeout(t, transport, time.Second)
+	defer transport.Disconnect(0)
+	if !transport.IsConnected() {
+		t.Error("not connected after reconnecting")
+	}
+	// The probe and a poll of each channel.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-polls:
+		case <-time.After(time.Second):
+			t.Fatal("polling not restored")
+		}
+	}
+
+	if err := transport.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []EventType{EventConnected, EventDisconnected, EventConnected} {
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Errorf("event %v, want %v", event.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %v event", want)
+		}
+	}
+}
+
+func TestReconnectConcurrently(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := transport.Reconnect(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if !transport.IsConnected() {
+		t.Error("not connected after concurrent reconnects")
+	}
+
+	transport.Disconnect(0)
+	waitGroupTimeout(t, transport, time.Second)
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultReplayWindow is the default maximum difference between the
+	// signing time of an inbound message and the time it is received.
+	DefaultReplayWindow = 5 * time.Minute
+
+	// DefaultReplayNonces is the default number of inbound message nonces
+	// remembered to detect replays.
+	DefaultReplayNonces = 10000
+)
+
+// ErrReplayedMessage is returned when a signed inbound message was signed
+// too long ago or too far in the future, or carries a nonce already seen.
+var ErrReplayedMessage = errors.New("replayed message")
+
+// replayGuard rejects signed messages outside of the replay window, and those
+// whose nonce was seen within it. A nil *replayGuard accepts every message.
+type replayGuard struct {
+	window time.Duration
+	nonces *dedupCache
+}
+
+func newReplayGuard(window time.Duration, size int) *replayGuard {
+	return &replayGuard{
+		window: window,
+		// A message is accepted for window either side of its timestamp,
+		// so its nonce must be remembered for twice as long.
+		nonces: newDedupCache(size, 2*window),
+	}
+}
+
+// check validates the signature timestamp and nonce in header at now,
+// recording the nonce as seen.
+func (g *replayGuard) check(header http.Header, now time.Time) error {
+	if g == nil {
+		return nil
+	}
+	timestamp, err := strconv.ParseInt(header.Get(SignatureTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or malformed timestamp", ErrReplayedMessage)
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > g.window {
+		return fmt.Errorf("%w: signed %v ago", ErrReplayedMessage, age)
+	}
+	if -age > g.window {
+		return fmt.Errorf("%w: signed %v in the future", ErrReplayedMessage, -age)
+	}
+	nonce := header.Get(SignatureNonceHeader)
+	if nonce == "" {
+		return fmt.Errorf("%w: missing nonce", ErrReplayedMessage)
+	}
+	if g.nonces.seen(nonce, now) {
+		return fmt.Errorf("%w: nonce already seen", ErrReplayedMessage)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayProtection(t *testing.T) {
+	public, private := newSigningKey(t)
+	message := []byte(`{}`)
+
+	var mu sync.Mutex
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		copyHeader(w.Header(), header)
+		_, _ = w.Write(message)
+	}))
+	defer srv.Close()
+
+	newTransport := func(window time.Duration) (*HTTP, chan []byte) {
+		received := make(chan []byte, 2)
+		transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+			received <- data
+			return nil
+		}, HTTPOptions{
+			PollingInterval: time.Second,
+			VerifyKeys:      []ed25519.PublicKey{public},
+			ReplayWindow:    window,
+			Logger:          &captureLogger{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transport, received
+	}
+	// poll serves a message with signature headers h to transport, and
+	// reports whether it was passed to the data handler.
+	poll := func(transport *HTTP, received chan []byte, h http.Header) bool {
+		t.Helper()
+		mu.Lock()
+		header = h
+		mu.Unlock()
+		if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-received:
+			return true
+		default:
+			return false
+		}
+	}
+
+	tests := []struct {
+		description string
+		signedAt    time.Duration
+		want        bool
+	}{
+		{description: "fresh", want: true},
+		{description: "within skew", signedAt: time.Minute, want: true},
+		{description: "expired", signedAt: -10 * time.Minute},
+		{description: "future beyond skew", signedAt: 10 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			transport, received := newTransport(0)
+			h := signedHeader(t, private, message, time.Now().Add(test.signedAt))
+			if got := poll(transport, received, h); got != test.want {
+				t.Errorf("accepted %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("replayed nonce", func(t *testing.T) {
+		transport, received := newTransport(0)
+		h := signedHeader(t, private, message, time.Now())
+		if !poll(transport, received, h) {
+			t.Fatal("message rejected")
+		}
+		if poll(transport, received, h) {
+			t.Error("replayed message accepted")
+		}
+		if !poll(transport, received, signedHeader(t, private, message, time.Now())) {
+			t.Error("message with a new nonce rejected")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		transport, received := newTransport(-1)
+		h := signedHeader(t, private, message, time.Now().Add(-time.Hour))
+		if !poll(transport, received, h) || !poll(transport, received, h) {
+			t.Error("message rejected with replay protection disabled")
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the HTTP header that carries the ID correlating a
+// message across the client and the server. It is also the message metadata
+// key under which callers may supply their own ID.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID returns a copy of ctx carrying the request ID of the message
+// being handled.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID that the server sent with the
+// inbound message whose handler ctx was passed to, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the correlation ID supplied in the metadata of message,
+// or a new random UUID if message does not carry one.
+func requestID(message []byte) string {
+	var data struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := json.Unmarshal(message, &data); err == nil {
+		if id := data.Metadata[RequestIDHeader]; id != "" {
+			return id
+		}
+	}
+	return uuid.New().String()
+}
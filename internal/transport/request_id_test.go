@@ -0,0 +1,124 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendRequestID(t *testing.T) {
+	tests := []struct {
+		description string
+		message     string
+		want        string
+	}{
+		{description: "generated", message: `{"metadata":{}}`},
+		{description: "not a data message", message: `[]`},
+		{description: "supplied in metadata", message: `{"metadata":{"X-Request-ID":"abc"}}`, want: "abc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			var ids []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				ids = append(ids, req.Header.Get(RequestIDHeader))
+				// Fail the first attempt, so the send is retried.
+				if len(ids) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+				PollingInterval: time.Second,
+				SendRetries:     1,
+				Backoff:         ConstantBackoff{Delay: time.Millisecond},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := transport.SendData([]byte(test.message), "data")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ids) != 2 {
+				t.Fatalf("server received %v requests, want 2", len(ids))
+			}
+			if ids[0] == "" {
+				t.Fatal("request ID header not set")
+			}
+			if ids[0] != ids[1] {
+				t.Errorf("request ID changed between attempts: %v != %v", ids[0], ids[1])
+			}
+			if test.want != "" && ids[0] != test.want {
+				t.Errorf("request ID %v != %v", ids[0], test.want)
+			}
+
+			var response HTTPResponse
+			if err := json.Unmarshal(data, &response); err != nil {
+				t.Fatal(err)
+			}
+			if got := response.Metadata[RequestIDHeader]; got != ids[0] {
+				t.Errorf("response metadata request ID %v != %v", got, ids[0])
+			}
+		})
+	}
+}
+
+func TestRequestIDUnique(t *testing.T) {
+	if requestID([]byte(`{}`)) == requestID([]byte(`{}`)) {
+		t.Error("generated request IDs are not unique")
+	}
+}
+
+func TestReceiveRequestID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(RequestIDHeader, "abc")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	ids := make(chan string, 2)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+		t.Error("data handler called instead of the context handler")
+		return nil
+	}, HTTPOptions{
+		ContextHandler: func(ctx context.Context, data []byte, dest string) error {
+			ids <- RequestIDFromContext(ctx)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ids; got != "abc" {
+		t.Errorf("handler got request ID %q, want abc", got)
+	}
+
+	// Messages passed in directly carry none.
+	if err := transport.ReceiveData([]byte(`{}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ids; got != "" {
+		t.Errorf("handler got request ID %q, want none", got)
+	}
+}
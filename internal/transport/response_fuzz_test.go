@@ -0,0 +1,69 @@
+//go:build go1.18
+// +build go1.18
+
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func FuzzParseResponse(f *testing.F) {
+	f.Add(200, "application/json", "X-Tag", "a", "", []byte(`{"n":1}`), false)
+	f.Add(202, "application/problem+json", "Set-Cookie", "a=1; Path=/", "Set-Cookie", []byte(`[[[[]]]]`), true)
+	f.Add(500, "text/plain; charset=utf-8", "", "", "X-Checksum", []byte("\xff\xfe"), true)
+	f.Add(204, "", "Content-Type", "", "", []byte(nil), true)
+	f.Add(-1, "application/json; charset=", "X-Request-ID", "1", "X-Request-ID", []byte(`{"a":`), true)
+	f.Add(400, "image/png", "\x00", "\n", "\x00", []byte{0, 1, 2}, false)
+
+	f.Fuzz(func(t *testing.T, statusCode int, contentType, name, value, trailerName string, body []byte, withHeader bool) {
+		var header, trailer http.Header
+		if contentType != "" || name != "" {
+			header = http.Header{}
+			header.Set("Content-Type", contentType)
+			header[name] = []string{value, value}
+		}
+		if trailerName != "" {
+			trailer = http.Header{trailerName: {value}, "X-Announced": nil}
+		}
+
+		response, err := parseResponse(statusCode, header, trailer, body, "1", withHeader)
+		if err != nil {
+			if !errors.Is(err, ErrPermanentTransport) && !errors.Is(err, ErrTransientTransport) {
+				t.Fatalf("unclassified error %v", err)
+			}
+			return
+		}
+
+		if response.StatusCode != statusCode {
+			t.Errorf("status %v != %v", response.StatusCode, statusCode)
+		}
+		if len(body) == 0 && response.Body != nil {
+			t.Errorf("empty body stored as %q", response.Body)
+		}
+		if response.Body != nil && !json.Valid(response.Body) {
+			t.Errorf("body stored as invalid JSON %q", response.Body)
+		}
+		if response.Metadata[RequestIDHeader] == "" {
+			t.Error("metadata without a request ID")
+		}
+		if withHeader && len(response.Header.Values(RequestIDHeader)) == 0 {
+			t.Error("header without a request ID")
+		}
+		for _, serializer := range []Serializer{JSONSerializer{}, GobSerializer{}} {
+			data, err := serializer.Marshal(response)
+			if err != nil {
+				t.Fatalf("cannot marshal envelope with %T: %v", serializer, err)
+			}
+			var got HTTPResponse
+			if err := serializer.Unmarshal(data, &got); err != nil {
+				t.Fatalf("cannot unmarshal envelope with %T: %v", serializer, err)
+			}
+			if got.StatusCode != statusCode {
+				t.Errorf("%T: status %v != %v after a round trip", serializer, got.StatusCode, statusCode)
+			}
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseRetryAfter parses the value of a Retry-After header, given either as a
+// number of seconds or as an HTTP date, into a delay relative to now. It
+// returns false if the value is empty or malformed.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 || seconds > math.MaxInt64/int64(time.Second) {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	delay := date.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// retryAfter returns the delay requested by res if it is a 429 or 503
+// response carrying a well-formed Retry-After header.
+func retryAfter(res *http.Response, now time.Time) (time.Duration, bool) {
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return parseRetryAfter(res.Header.Get("Retry-After"), now)
+	default:
+		return 0, false
+	}
+}
+
+// holdOff records that the server asked not to receive any further requests
+// for d.
+func (t *HTTP) holdOff(d time.Duration) {
+	t.holdOffMu.Lock()
+	defer t.holdOffMu.Unlock()
+
+	if until := t.clock.Now().Add(d); until.After(t.holdOffUntil) {
+		t.holdOffUntil = until
+	}
+}
+
+// holdOffRemaining returns how long remains before the server is willing to
+// receive requests again.
+func (t *HTTP) holdOffRemaining() time.Duration {
+	t.holdOffMu.Lock()
+	defer t.holdOffMu.Unlock()
+
+	if remaining := t.holdOffUntil.Sub(t.clock.Now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// checkRetryAfter records the delay requested by res, if any.
+func (t *HTTP) checkRetryAfter(res *http.Response) {
+	if delay, ok := retryAfter(res, t.clock.Now()); ok {
+		t.holdOff(delay)
+	}
+}
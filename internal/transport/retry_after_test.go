@@ -0,0 +1,119 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		input       string
+		want        time.Duration
+		ok          bool
+	}{
+		{description: "seconds", input: "120", want: 2 * time.Minute, ok: true},
+		{description: "zero seconds", input: "0", want: 0, ok: true},
+		{description: "padded seconds", input: " 3 ", want: 3 * time.Second, ok: true},
+		{description: "HTTP date", input: "Tue, 01 Jun 2021 12:00:30 GMT", want: 30 * time.Second, ok: true},
+		{description: "HTTP date in the past", input: "Tue, 01 Jun 2021 11:00:00 GMT", want: 0, ok: true},
+		{description: "empty", input: "", ok: false},
+		{description: "negative seconds", input: "-5", ok: false},
+		{description: "overflowing seconds", input: "99999999999999999", ok: false},
+		{description: "fractional seconds", input: "1.5", ok: false},
+		{description: "garbage", input: "soon", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, ok := parseRetryAfter(test.input, now)
+			if ok != test.ok {
+				t.Fatalf("ok %v != %v", ok, test.ok)
+			}
+			if got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfterIgnoredForOtherStatuses(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Header:     http.Header{"Retry-After": []string{"10"}},
+	}
+	if _, ok := retryAfter(res, time.Now()); ok {
+		t.Error("Retry-After should only be honored on 429 and 503 responses")
+	}
+}
+
+func TestSendHonorsRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	defer close(clock.stop)
+	transport.clock = clock
+
+	if _, err := transport.SendData([]byte(`{}`), "data"); err == nil {
+		t.Fatal("expected an error for a 503 response")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = transport.SendData([]byte(`{}`), "data")
+		close(done)
+	}()
+	select {
+	case d := <-clock.sleeps:
+		if d != 30*time.Second {
+			t.Errorf("send waited %v, want %v", d, 30*time.Second)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send did not wait for the Retry-After delay")
+	}
+	<-done
+}
+
+func TestPollHonorsRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Second, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport.wg.Add(1)
+	go transport.poll(ctx, "control")
+
+	if d := <-clock.sleeps; d != 2*time.Minute {
+		t.Errorf("poll waited %v, want %v", d, 2*time.Minute)
+	}
+	cancel()
+	close(clock.stop)
+	waitGroupTimeout(t, transport, time.Second)
+}
@@ -0,0 +1,159 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SendStream sends the message read from r to dest like SendData, streaming
+// it to the server instead of holding it in memory. length is the size of
+// the message in bytes, or -1 if it is unknown, in which case the message is
+// sent with chunked transfer encoding. MaxMessageSize still applies.
+//
+// A streamed message cannot be resent, so it is neither retried nor
+// compressed, and its body is not logged. Messages that must be read whole
+// first, because they are signed or encrypted, or because the transport
+// queues messages or is in dry-run mode, are read into memory and sent as
+// SendData sends them.
+func (t *HTTP) SendStream(r io.Reader, length int64, dest string) ([]byte, error) {
+	return t.SendStreamWithContext(context.Background(), r, length, dest)
+}
+
+// SendStreamWithContext sends a message like SendStream. Cancelling ctx, or
+// reaching its deadline, aborts the send.
+func (t *HTTP) SendStreamWithContext(ctx context.Context, r io.Reader, length int64, dest string) ([]byte, error) {
+	atomic.AddInt32(&t.inflight, 1)
+	defer atomic.AddInt32(&t.inflight, -1)
+
+	ctx, span := t.tracer.Start(ctx, "SendStream", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(channelKey.String(dest)))
+	res, err := t.sendStream(ctx, r, length, dest)
+	endSpan(span, err)
+	return res, err
+}
+
+func (t *HTTP) sendStream(ctx context.Context, r io.Reader, length int64, channel string) ([]byte, error) {
+	if err := t.checkChannel(channel); err != nil {
+		return nil, err
+	}
+	if length >= 0 {
+		if err := t.checkSize(length); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkContentType(ctx); err != nil {
+		return nil, err
+	}
+	body := &limitedUpload{r: r, limit: int64(t.opts.MaxMessageSize)}
+	if t.mustBuffer(channel) {
+		message, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, body.failure(err)
+		}
+		return t.send(ctx, message, channel)
+	}
+
+	release, err := t.order.acquire(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if t.disconnected.Load().(bool) {
+		t.opts.Logger.Warn("transport disconnected, dropping message", "channel", channel)
+		t.observeDropped(channel)
+		return nil, transientError(ErrTransportDisconnected)
+	}
+	data, err := t.postStream(ctx, body, length, channel)
+	if err != nil {
+		t.state.recordError(err)
+	}
+	return data, err
+}
+
+// mustBuffer reports whether a message sent to channel must be read whole
+// before it is sent.
+func (t *HTTP) mustBuffer(channel string) bool {
+	return t.opts.DryRun ||
+		t.queue != nil ||
+		t.signingKeys.Load().(*signingKeys).private != nil ||
+		(channel == "data" && t.encryptionKeys.Load().(*encryptionKeys).current != "")
+}
+
+// postStream sends the message read from body, of the given length or -1, to
+// channel in a single request.
+func (t *HTTP) postStream(ctx context.Context, body *limitedUpload, length int64, channel string) ([]byte, error) {
+	release, err := t.waitToSend(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	requestID := uuid.New().String()
+	log.Tracef("posting HTTP request %v with a streamed body", requestID)
+
+	reqURL, err := t.requestURL("out", channel)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, body)
+	if err != nil {
+		return nil, permanentError(fmt.Errorf("cannot create HTTP request: %w", err))
+	}
+	// An unknown length makes the client use chunked transfer encoding.
+	req.ContentLength = length
+	t.setHeaders(req.Header)
+	req.Header.Set("Content-Type", contentType(ctx))
+	req.Header.Set(RequestIDHeader, requestID)
+	if t.opts.IdempotencyKeys {
+		req.Header.Set(IdempotencyKeyHeader, uuid.New().String())
+	}
+	if t.opts.ExpectContinue && (length < 0 || length > int64(t.opts.ExpectContinueThreshold)) {
+		req.Header.Set("Expect", "100-continue")
+	}
+	data, err := t.doPost(ctx, req, channel, requestID, func() int { return int(body.n) })
+	if body.err != nil {
+		return nil, body.failure(body.err)
+	}
+	return data, err
+}
+
+// limitedUpload reads a streamed message, failing once more than limit bytes
+// have been read if limit is positive, and records the first error from r.
+type limitedUpload struct {
+	r     io.Reader
+	limit int64
+	n     int64
+	err   error
+}
+
+func (u *limitedUpload) Read(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	n, err := u.r.Read(p)
+	u.n += int64(n)
+	if u.limit > 0 && u.n > u.limit {
+		u.err = ErrMessageTooLarge
+		return 0, u.err
+	}
+	if err != nil && err != io.EOF {
+		u.err = err
+	}
+	return n, err
+}
+
+// failure classifies err, an error reading the message: exceeding the size
+// limit is permanent, as is any failure of the reader, since the message
+// cannot be read again.
+func (u *limitedUpload) failure(err error) error {
+	if err == ErrMessageTooLarge {
+		return permanentError(fmt.Errorf("%w: more than the limit of %v bytes", ErrMessageTooLarge, u.limit))
+	}
+	return permanentError(fmt.Errorf("cannot read message: %w", err))
+}
@@ -0,0 +1,149 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSendStream(t *testing.T) {
+	const size = 16 << 20
+
+	tests := []struct {
+		description    string
+		length         int64
+		wantChunked    bool
+		wantLength     int64
+		maxMessageSize int
+		wantErr        error
+	}{
+		{description: "unknown length", length: -1, wantChunked: true, maxMessageSize: -1},
+		{description: "known length", length: size, wantLength: size, maxMessageSize: -1},
+		{description: "unknown length too large", length: -1, maxMessageSize: size / 2, wantErr: ErrMessageTooLarge},
+		{description: "known length too large", length: size, maxMessageSize: size / 2, wantErr: ErrMessageTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			var gotSum [sha256.Size]byte
+			var gotLength int64
+			var gotEncoding []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				h := sha256.New()
+				io.Copy(h, req.Body)
+				mu.Lock()
+				defer mu.Unlock()
+				copy(gotSum[:], h.Sum(nil))
+				gotLength = req.ContentLength
+				gotEncoding = req.TransferEncoding
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+				MaxMessageSize: test.maxMessageSize,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The message is generated as it is read, and never held in
+			// memory whole.
+			want := sha256.New()
+			r := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(1)), size), want)
+			_, err = transport.SendStream(r, test.length, "data")
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) || !errors.Is(err, ErrPermanentTransport) {
+					t.Errorf("got %v, want a permanent %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !bytes.Equal(gotSum[:], want.Sum(nil)) {
+				t.Error("server received a different body")
+			}
+			if chunked := len(gotEncoding) == 1 && gotEncoding[0] == "chunked"; chunked != test.wantChunked {
+				t.Errorf("got transfer encoding %v, want chunked %v", gotEncoding, test.wantChunked)
+			}
+			if test.wantLength != 0 && gotLength != test.wantLength {
+				t.Errorf("got content length %v, want %v", gotLength, test.wantLength)
+			}
+		})
+	}
+}
+
+func TestSendStreamBuffered(t *testing.T) {
+	_, private := newSigningKey(t)
+	var mu sync.Mutex
+	var header http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		header = req.Header
+		body = data
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		SigningKey: private,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendStream(strings.NewReader(`{"n":1}`), -1, "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if string(body) != `{"n":1}` {
+		t.Errorf("server received %q, want {\"n\":1}", body)
+	}
+	if header.Get(SignatureHeader) == "" {
+		t.Error("signed message streamed without a signature")
+	}
+}
+
+func TestSendStreamReadError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(ioutil.Discard, req.Body)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("worker crashed")
+	r := io.MultiReader(strings.NewReader(`{"n":`), &failingReader{err: failure})
+	if _, err := transport.SendStream(r, -1, "data"); !errors.Is(err, failure) || !errors.Is(err, ErrPermanentTransport) {
+		t.Errorf("got %v, want a permanent %v", err, failure)
+	}
+}
+
+// failingReader fails every read with err.
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// A Serializer encodes and decodes the HTTPResponse envelope the transport
+// returns from sends, so that the two ends agree on its format.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONSerializer is the default Serializer, encoding the envelope as JSON.
+type JSONSerializer struct{}
+
+// Marshal encodes v as JSON.
+func (JSONSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON in data into v.
+func (JSONSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobSerializer encodes the envelope with encoding/gob, which is more compact
+// than JSON, and faster to decode, for channels carrying many messages.
+type GobSerializer struct{}
+
+// Marshal encodes v with gob.
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the gob-encoded data into v.
+func (GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// marshalResponse encodes response with the configured serializer.
+func (t *HTTP) marshalResponse(response HTTPResponse) ([]byte, error) {
+	data, err := t.opts.Serializer.Marshal(response)
+	if err != nil {
+		return nil, permanentError(fmt.Errorf("cannot marshal HTTP response: %w", err))
+	}
+	return data, nil
+}
+
+// UnmarshalResponse decodes a response returned by SendData, which was
+// encoded with the configured serializer.
+func (t *HTTP) UnmarshalResponse(data []byte) (HTTPResponse, error) {
+	var response HTTPResponse
+	if err := t.opts.Serializer.Unmarshal(data, &response); err != nil {
+		return HTTPResponse{}, fmt.Errorf("cannot unmarshal HTTP response: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,178 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+// cborSerializer encodes the envelope as CBOR.
+type cborSerializer struct{}
+
+func (cborSerializer) Marshal(v interface{}) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborSerializer) Unmarshal(data []byte, v interface{}) error {
+	return cbor.Unmarshal(data, v)
+}
+
+func TestSerializer(t *testing.T) {
+	tests := []struct {
+		description string
+		serializer  Serializer
+		dryRun      bool
+		want        HTTPResponse
+	}{
+		{
+			description: "json",
+			want: HTTPResponse{
+				StatusCode: http.StatusAccepted,
+				Body:       json.RawMessage(`{"n":1}`),
+				Metadata:   map[string]string{RequestIDHeader: "1"},
+			},
+		},
+		{
+			description: "cbor",
+			serializer:  cborSerializer{},
+			want: HTTPResponse{
+				StatusCode: http.StatusAccepted,
+				Body:       json.RawMessage(`{"n":1}`),
+				Metadata:   map[string]string{RequestIDHeader: "1"},
+			},
+		},
+		{
+			description: "gob",
+			serializer:  GobSerializer{},
+			want: HTTPResponse{
+				StatusCode: http.StatusAccepted,
+				Body:       json.RawMessage(`{"n":1}`),
+				Metadata:   map[string]string{RequestIDHeader: "1"},
+			},
+		},
+		{
+			description: "cbor dry run",
+			serializer:  cborSerializer{},
+			dryRun:      true,
+			want: HTTPResponse{
+				StatusCode: http.StatusOK,
+				Metadata:   map[string]string{RequestIDHeader: "1"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set(RequestIDHeader, req.Header.Get(RequestIDHeader))
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte(`{"n":1}`))
+			}))
+			defer srv.Close()
+
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+				Serializer: test.serializer,
+				DryRun:     test.dryRun,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := transport.SendData([]byte(`{"metadata":{"`+RequestIDHeader+`":"1"}}`), "data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.serializer != nil && json.Valid(data) {
+				t.Errorf("response %q was encoded as JSON", data)
+			}
+			got, err := transport.UnmarshalResponse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only the headers the test sets are compared.
+			for k := range got.Metadata {
+				if k != RequestIDHeader {
+					delete(got.Metadata, k)
+				}
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+		})
+	}
+}
+
+func TestResponseHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2; Path=/")
+		w.Header().Add("X-Tag", "first")
+		w.Header().Add("X-Tag", "second")
+		w.WriteHeader(http.StatusAccepted)
+		w.Header().Add("X-Checksum", "abc")
+		w.Header().Add("X-Checksum", "def")
+	}))
+	defer srv.Close()
+
+	for _, serializer := range []Serializer{JSONSerializer{}, GobSerializer{}, cborSerializer{}} {
+		t.Run(fmt.Sprintf("%T", serializer), func(t *testing.T) {
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+				Serializer:     serializer,
+				ResponseHeader: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := transport.SendData([]byte(`{"metadata":{"`+RequestIDHeader+`":"1"}}`), "data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := transport.UnmarshalResponse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string][]string{
+				"Set-Cookie":    {"a=1", "b=2; Path=/"},
+				"X-Tag":         {"first", "second"},
+				"X-Checksum":    {"abc", "def"},
+				RequestIDHeader: {"1"},
+			}
+			for k, v := range want {
+				if !cmp.Equal(got.Header.Values(k), v) {
+					t.Errorf("header %v: %q != %q", k, got.Header.Values(k), v)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseHeaderOff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("X-Tag", "first")
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := transport.SendData([]byte(`{}`), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"Header"`) {
+		t.Errorf("response %s has a Header", data)
+	}
+}
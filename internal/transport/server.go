@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultPorts are the ports used for each URL scheme when the server address
+// does not name one.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// splitServer parses a server address given as host[:port] into its host and
+// port, which is empty if the address does not name one. IPv6 literals may be
+// given with or without brackets, but need brackets to be followed by a port.
+// A URL scheme mistakenly included in server is stripped, since whether TLS
+// is used is up to the transport, but unless useTLS is set an https scheme is
+// refused with an error wrapping ErrTLSDowngrade rather than silently reached
+// over plain HTTP.
+func splitServer(server string, useTLS bool) (host string, port string, err error) {
+	addr := server
+	if i := strings.Index(addr, "://"); i >= 0 {
+		if !useTLS && strings.EqualFold(addr[:i], "https") {
+			return "", "", fmt.Errorf("cannot use server address %v without a TLS config: %w", server, ErrTLSDowngrade)
+		}
+		addr = addr[i+len("://"):]
+	}
+	addr = strings.TrimSuffix(addr, "/")
+	if strings.Contains(addr, "/") {
+		return "", "", fmt.Errorf("cannot parse server address %v: unexpected path", server)
+	}
+
+	switch {
+	case net.ParseIP(addr) != nil:
+		host = addr
+	case strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]"):
+		host = addr[1 : len(addr)-1]
+	case !strings.Contains(addr, ":"):
+		host = addr
+	default:
+		host, port, err = net.SplitHostPort(addr)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot parse server address %v: %w", server, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", "", fmt.Errorf("cannot parse server address %v: invalid port %v", server, port)
+		}
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("cannot parse server address %v: missing host", server)
+	}
+	return host, port, nil
+}
+
+// joinServer returns the server address of host and port, which splitServer
+// parses back into them.
+func joinServer(host, port string) string {
+	switch {
+	case port != "":
+		return net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		return "[" + host + "]"
+	default:
+		return host
+	}
+}
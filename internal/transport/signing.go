@@ -0,0 +1,159 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SignatureHeader is the HTTP header carrying the base64-encoded Ed25519
+// signature of a message. The signature covers the method of the request the
+// message is sent with or fetched by, its channel and the client ID,
+// separated by spaces, the values of SignatureTimestampHeader and
+// SignatureNonceHeader and the message body, before any encryption or
+// compression, each followed by a newline. A message signed for one channel,
+// client or direction therefore does not verify for another.
+const SignatureHeader = "X-Signature"
+
+const (
+	// SignatureTimestampHeader carries the time a message was signed, in
+	// seconds since the Unix epoch.
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+
+	// SignatureNonceHeader carries a random value unique to each signed
+	// message.
+	SignatureNonceHeader = "X-Signature-Nonce"
+)
+
+// ErrInvalidSignature is returned when an inbound message is unsigned, or
+// its signature does not verify against any of the configured public keys.
+var ErrInvalidSignature = errors.New("invalid message signature")
+
+// signingKeys holds the key outbound messages are signed with and the keys
+// inbound messages are verified against. A nil key or an empty list of keys
+// disables signing or verification, respectively.
+type signingKeys struct {
+	private ed25519.PrivateKey
+	public  []ed25519.PublicKey
+}
+
+// sign sets a timestamp, a nonce and the signature of body, sent with scope as
+// returned by signatureScope, on header, if a signing key is set.
+func (k *signingKeys) sign(header http.Header, scope string, body []byte, now time.Time) error {
+	if k.private == nil {
+		return nil
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	header.Set(SignatureTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	header.Set(SignatureNonceHeader, base64.RawURLEncoding.EncodeToString(nonce))
+	header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(k.private, signedData(header, scope, body))))
+	return nil
+}
+
+// verify checks the signature of body, received with scope as returned by
+// signatureScope, found in header against each public key, if any is set.
+func (k *signingKeys) verify(header http.Header, scope string, body []byte) error {
+	if len(k.public) == 0 {
+		return nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(header.Get(SignatureHeader))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	data := signedData(header, scope, body)
+	for _, key := range k.public {
+		if ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// signatureScope returns the scope of the signature of a message sent or
+// fetched with method on channel for clientID.
+func signatureScope(method, channel, clientID string) string {
+	return method + " " + channel + " " + clientID
+}
+
+// signedData returns the data covered by the signature of a message with the
+// given scope, header and body.
+func signedData(header http.Header, scope string, body []byte) []byte {
+	var data bytes.Buffer
+	data.WriteString(scope + "\n")
+	data.WriteString(header.Get(SignatureTimestampHeader) + "\n")
+	data.WriteString(header.Get(SignatureNonceHeader) + "\n")
+	data.Write(body)
+	data.WriteString("\n")
+	return data.Bytes()
+}
+
+// ReloadSigningKeys replaces the key outbound messages are signed with and
+// the public keys inbound messages are verified against. Listing both the
+// old and new public keys while the server rotates its key accepts messages
+// signed with either.
+func (t *HTTP) ReloadSigningKeys(private ed25519.PrivateKey, public []ed25519.PublicKey) {
+	t.signingKeys.Store(&signingKeys{private: private, public: public})
+}
+
+// LoadSigningKey reads a PEM-encoded PKCS #8 Ed25519 private key from path.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEMBlock(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %w", err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("cannot load signing key: %T is not an Ed25519 key", key)
+	}
+	return private, nil
+}
+
+// LoadVerifyKey reads a PEM-encoded PKIX Ed25519 public key from path.
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEMBlock(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key: %w", err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("cannot load verify key: %T is not an Ed25519 key", key)
+	}
+	return public, nil
+}
+
+// readPEMBlock reads the first PEM block from path, which must be of type
+// blockType.
+func readPEMBlock(path string, blockType string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("cannot decode key file %v: no PEM data", path)
+	}
+	if block.Type != blockType {
+		return nil, fmt.Errorf("cannot decode key file %v: unexpected %v block", path, block.Type)
+	}
+	return block, nil
+}
@@ -0,0 +1,259 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSigningKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+// signedHeader returns the signature headers of body, signed with key at now
+// as a message polled from the data channel by the client "test".
+func signedHeader(t *testing.T, key ed25519.PrivateKey, body []byte, now time.Time) http.Header {
+	t.Helper()
+	return scopedSignedHeader(t, key, signatureScope(http.MethodGet, "data", "test"), body, now)
+}
+
+// scopedSignedHeader returns the signature headers of body, signed with key at
+// now for scope.
+func scopedSignedHeader(t *testing.T, key ed25519.PrivateKey, scope string, body []byte, now time.Time) http.Header {
+	t.Helper()
+	header := http.Header{}
+	if err := (&signingKeys{private: key}).sign(header, scope, body, now); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// copyHeader sets the values of the headers in from on to.
+func copyHeader(to, from http.Header) {
+	for k, v := range from {
+		to[k] = v
+	}
+}
+
+func TestSignOutbound(t *testing.T) {
+	public, private := newSigningKey(t)
+	headers := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers <- req.Header
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		SigningKey:      private,
+		// Compression must not affect the signature.
+		Compress:          true,
+		CompressThreshold: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte(`{"content":"signed"}`)
+	if _, err := transport.SendData(message, "data"); err != nil {
+		t.Fatal(err)
+	}
+	header := <-headers
+	signature, err := base64.StdEncoding.DecodeString(header.Get(SignatureHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get(SignatureTimestampHeader) == "" || header.Get(SignatureNonceHeader) == "" {
+		t.Error("signature timestamp or nonce missing")
+	}
+	if !ed25519.Verify(public, signedData(header, signatureScope(http.MethodPost, "data", "test"), message), signature) {
+		t.Error("signature does not verify")
+	}
+}
+
+func TestVerifyInbound(t *testing.T) {
+	public, private := newSigningKey(t)
+	_, otherPrivate := newSigningKey(t)
+	message := []byte(`{"content":"signed"}`)
+
+	now := time.Now()
+	tampered := signedHeader(t, private, message, now)
+	tampered.Set(SignatureNonceHeader, "other")
+	tests := []struct {
+		description string
+		header      http.Header
+		want        bool
+	}{
+		{
+			description: "valid signature",
+			header:      signedHeader(t, private, message, now),
+			want:        true,
+		},
+		{
+			description: "tampered body",
+			header:      signedHeader(t, private, []byte(`{"content":"original"}`), now),
+		},
+		{
+			description: "tampered nonce",
+			header:      tampered,
+		},
+		{
+			description: "other channel",
+			header:      scopedSignedHeader(t, private, signatureScope(http.MethodGet, "control", "test"), message, now),
+		},
+		{
+			description: "other client",
+			header:      scopedSignedHeader(t, private, signatureScope(http.MethodGet, "data", "other"), message, now),
+		},
+		{
+			description: "outbound message",
+			header:      scopedSignedHeader(t, private, signatureScope(http.MethodPost, "data", "test"), message, now),
+		},
+		{
+			description: "wrong key",
+			header:      signedHeader(t, otherPrivate, message, now),
+		},
+		{
+			description: "unsigned",
+			header:      http.Header{},
+		},
+		{
+			description: "malformed signature",
+			header:      http.Header{SignatureHeader: []string{"not base64"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				copyHeader(w.Header(), test.header)
+				_, _ = w.Write(message)
+			}))
+			defer srv.Close()
+
+			received := make(chan []byte, 1)
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+				received <- data
+				return nil
+			}, HTTPOptions{
+				PollingInterval: time.Second,
+				VerifyKeys:      []ed25519.PublicKey{public},
+				Logger:          &captureLogger{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-received:
+				if !test.want {
+					t.Error("message with an invalid signature passed to the data handler")
+				}
+			default:
+				if test.want {
+					t.Error("message with a valid signature dropped")
+				}
+			}
+		})
+	}
+}
+
+func TestReloadSigningKeys(t *testing.T) {
+	oldPublic, _ := newSigningKey(t)
+	newPublic, newPrivate := newSigningKey(t)
+	message := []byte(`{}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		copyHeader(w.Header(), signedHeader(t, newPrivate, message, time.Now()))
+		_, _ = w.Write(message)
+	}))
+	defer srv.Close()
+
+	received := make(chan []byte, 2)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+		received <- data
+		return nil
+	}, HTTPOptions{
+		PollingInterval: time.Second,
+		VerifyKeys:      []ed25519.PublicKey{oldPublic},
+		Logger:          &captureLogger{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 0 {
+		t.Fatal("message signed with a key not yet trusted passed to the data handler")
+	}
+
+	transport.ReloadSigningKeys(nil, []ed25519.PublicKey{oldPublic, newPublic})
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 {
+		t.Error("message signed with a rotated key dropped")
+	}
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	public, private := newSigningKey(t)
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(dir, "signing.pem")
+	if err := ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPath := filepath.Join(dir, "verify.pem")
+	if err := ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gotPrivate, err := LoadSigningKey(privatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotPrivate.Equal(private) {
+		t.Error("loaded private key does not match")
+	}
+	gotPublic, err := LoadVerifyKey(publicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotPublic.Equal(public) {
+		t.Error("loaded public key does not match")
+	}
+
+	if _, err := LoadSigningKey(publicPath); err == nil {
+		t.Error("expected an error loading a public key as a signing key")
+	}
+	if _, err := LoadVerifyKey(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("expected an error loading a missing key file")
+	}
+}
@@ -0,0 +1,277 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LastEventIDHeader carries the ID of the last server-sent event received when
+// the transport reconnects to an event stream, so that the server can resume
+// the stream after it.
+const LastEventIDHeader = "Last-Event-ID"
+
+// DefaultEventStreamIdleTimeout is the default time an event stream may go
+// without sending anything, including comments sent as keep-alives, before
+// the transport reconnects.
+const DefaultEventStreamIdleTimeout = 2 * time.Minute
+
+// maxEventLineOverhead is how much longer than the largest event data allowed
+// a line of an event stream may be, to fit the "data: " field name and a CRLF
+// line ending.
+const maxEventLineOverhead = len("data: \r\n")
+
+// errEventStreamIdle is returned when an event stream is dropped after being
+// idle for EventStreamIdleTimeout.
+var errEventStreamIdle = errors.New("event stream idle")
+
+// serverEvent is an event dispatched from an event stream.
+type serverEvent struct {
+	id   string
+	data []byte
+}
+
+// subscribe receives the messages of channel from its event stream until ctx
+// is cancelled, reconnecting when the stream drops, backing off while
+// connecting fails and pausing while the circuit breaker is open.
+func (t *HTTP) subscribe(ctx context.Context, channel string) {
+	defer t.wg.Done()
+
+	var lastEventID string
+	var failures int
+	var retry time.Duration
+	for {
+		if ok, wait := t.breaker.allow(t.clock.Now(), t.pollingInterval(channel)); !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.clock.After(wait):
+			}
+			continue
+		}
+
+		spanCtx, span := t.tracer.Start(ctx, "subscribe", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(channelKey.String(channel)))
+		connected, err := t.openEventStream(spanCtx, channel, &lastEventID, &retry)
+		endSpan(span, err)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A stream that was established was a success, however it ended;
+		// only failing to establish one is backed off.
+		var delay time.Duration
+		if connected {
+			failures = 0
+			t.opts.Backoff.Reset()
+			t.breaker.record(t.clock.Now(), nil)
+			delay = retry
+			t.opts.Logger.Debug("event stream closed, reconnecting", "channel", channel, "last_event_id", lastEventID, "delay", delay, "error", err)
+			err = nil
+		} else {
+			failures++
+			t.breaker.record(t.clock.Now(), err)
+			delay = t.opts.Backoff.NextDelay(failures)
+			t.opts.Logger.Debug("cannot open event stream, backing off", "channel", channel, "attempt", failures, "delay", delay, "error", err)
+		}
+		if remaining := t.holdOffRemaining(); remaining > delay {
+			delay = remaining
+		}
+		t.reportPoll(channel, failures, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.clock.After(delay):
+		}
+	}
+}
+
+// openEventStream opens the event stream of channel, resuming after
+// *lastEventID, and dispatches its events until it ends. It updates
+// *lastEventID and *retry as the server sends them, and reports whether the
+// stream was established.
+func (t *HTTP) openEventStream(parent context.Context, channel string, lastEventID *string, retry *time.Duration) (bool, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	reqURL, err := t.requestURL("events", channel)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return false, permanentError(fmt.Errorf("cannot create HTTP request: %w", err))
+	}
+	t.setHeaders(req.Header)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if *lastEventID != "" {
+		req.Header.Set(LastEventIDHeader, *lastEventID)
+	}
+	injectTraceContext(ctx, req.Header)
+	start := time.Now()
+	resp, err := t.do(t.client, req)
+	if err != nil {
+		t.observeRequest(channel, "events", 0, time.Since(start), 0, 0)
+		return false, requestError(fmt.Errorf("cannot open event stream: %w", err))
+	}
+	defer resp.Body.Close()
+	t.checkRetryAfter(resp)
+	t.checkDate(resp)
+	trace.SpanFromContext(ctx).SetAttributes(statusCodeKey.Int(resp.StatusCode))
+	t.opts.Logger.Debug("opened event stream", "channel", channel, "url", req.URL, "status", resp.StatusCode, "last_event_id", *lastEventID)
+
+	if resp.StatusCode >= 400 {
+		data, _ := readBody(resp, t.opts.MaxResponseSize)
+		t.observeRequest(channel, "events", resp.StatusCode, time.Since(start), 0, len(data))
+		return false, HTTPStatusError{StatusCode: resp.StatusCode, Body: data, Header: resp.Header}
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		t.observeRequest(channel, "events", resp.StatusCode, time.Since(start), 0, 0)
+		return false, permanentError(fmt.Errorf("cannot open event stream: unexpected content type %q", resp.Header.Get("Content-Type")))
+	}
+	t.state.recordSuccessfulPoll(t.clock.Now())
+
+	// Drop the stream if the server goes quiet for too long, since the
+	// connection may be dead without either side having noticed.
+	var timer *time.Timer
+	if t.opts.EventStreamIdleTimeout > 0 {
+		timer = time.AfterFunc(t.opts.EventStreamIdleTimeout, cancel)
+		defer timer.Stop()
+	}
+	var received int
+	r := bufio.NewReader(resp.Body)
+	err = readEvents(r, t.opts.MaxResponseSize, func(n int) {
+		received += n
+		// A live stream counts as a successful poll for health checks.
+		t.state.recordSuccessfulPoll(t.clock.Now())
+		if timer != nil {
+			timer.Reset(t.opts.EventStreamIdleTimeout)
+		}
+	}, func(event serverEvent) {
+		if event.id != "" {
+			*lastEventID = event.id
+		}
+		// Handling may outlast the stream, so it must not be tied to it.
+		t.dispatchEvent(parent, channel, resp.Header, event)
+	}, func(d time.Duration) {
+		*retry = d
+	})
+	t.observeRequest(channel, "events", resp.StatusCode, time.Since(start), 0, received)
+	if ctx.Err() != nil && parent.Err() == nil {
+		return true, transientError(errEventStreamIdle)
+	}
+	if errors.Is(err, ErrResponseTooLarge) {
+		return true, permanentError(fmt.Errorf("cannot read event stream: %w", err))
+	}
+	if err != nil {
+		return true, transientError(fmt.Errorf("cannot read event stream: %w", err))
+	}
+	return true, nil
+}
+
+// dispatchEvent passes the data of event, received on the event stream of
+// channel, to the data handler.
+func (t *HTTP) dispatchEvent(ctx context.Context, channel string, header http.Header, event serverEvent) {
+	// Events carry no headers of their own, so they can be neither signed
+	// nor encrypted.
+	if len(t.signingKeys.Load().(*signingKeys).public) > 0 {
+		t.opts.Logger.Warn("dropping message", "channel", channel, "event_id", event.id, "error", ErrInvalidSignature)
+		return
+	}
+	opts := receiveSpanOptions(header)
+	t.receivePool.submit(channel, func() {
+		spanCtx, span := t.tracer.Start(ctx, "ReceiveData", opts...)
+		err := t.ReceiveData(event.data, channel)
+		endSpan(span, err)
+		t.acknowledge(spanCtx, channel, event.id, err)
+	})
+}
+
+// readEvents parses the server-sent events read from r, calling read with the
+// number of bytes of each line read, dispatch with each event and setRetry
+// with each reconnection time the server sets, until r is exhausted. Events
+// whose data exceeds limit bytes are discarded. A line too long to hold such
+// data fails with ErrResponseTooLarge as soon as it is detected, without
+// reading the rest of it, so that the stream cannot exhaust memory.
+func readEvents(r *bufio.Reader, limit int64, read func(int), dispatch func(serverEvent), setRetry func(time.Duration)) error {
+	var event serverEvent
+	var hasData, oversized bool
+	for {
+		line, err := readLine(r, limit+int64(maxEventLineOverhead))
+		if err == io.EOF {
+			// An event that was not terminated by a blank line is
+			// incomplete and is discarded.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		read(len(line))
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			if hasData && !oversized {
+				dispatch(event)
+			}
+			event.data = nil
+			hasData, oversized = false, false
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		// The event type is not used: each channel has a stream of its own.
+		switch field {
+		case "data":
+			if hasData {
+				event.data = append(event.data, '\n')
+			}
+			event.data = append(event.data, value...)
+			hasData = true
+			oversized = oversized || int64(len(event.data)) > limit
+			if oversized {
+				event.data = nil
+			}
+		case "id":
+			// IDs containing NUL are ignored, as the specification requires.
+			if !strings.ContainsRune(value, 0) {
+				event.id = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 32); err == nil {
+				setRetry(time.Duration(ms) * time.Millisecond)
+			}
+		}
+	}
+}
+
+// readLine reads a line from r like ReadString('\n'), failing with
+// ErrResponseTooLarge once more than limit bytes have been read without
+// finding its end.
+func readLine(r *bufio.Reader, limit int64) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if int64(len(line)+len(chunk)) > limit {
+			return "", ErrResponseTooLarge
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
@@ -0,0 +1,244 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadEvents(t *testing.T) {
+	type event struct {
+		ID   string
+		Data string
+	}
+	tests := []struct {
+		description string
+		input       string
+		want        []event
+		wantRetry   time.Duration
+	}{
+		{
+			description: "single event",
+			input:       "data: message\n\n",
+			want:        []event{{Data: "message"}},
+		},
+		{
+			description: "multi-line data",
+			input:       "data: a\ndata:b\n\n",
+			want:        []event{{Data: "a\nb"}},
+		},
+		{
+			description: "CRLF line endings",
+			input:       "id: 1\r\ndata: message\r\n\r\n",
+			want:        []event{{ID: "1", Data: "message"}},
+		},
+		{
+			description: "comments and unknown fields",
+			input:       ": keep-alive\nevent: update\nfoo: bar\ndata: message\n\n",
+			want:        []event{{Data: "message"}},
+		},
+		{
+			description: "ID persists",
+			input:       "id: 7\ndata: first\n\ndata: second\n\n",
+			want:        []event{{ID: "7", Data: "first"}, {ID: "7", Data: "second"}},
+		},
+		{
+			description: "events without data",
+			input:       "id: 1\n\n\n",
+		},
+		{
+			description: "incomplete event",
+			input:       "data: first\n\ndata: second\n",
+			want:        []event{{Data: "first"}},
+		},
+		{
+			description: "oversized event",
+			input:       "data: 01234\ndata: 56789a\n\ndata: small\n\n",
+			want:        []event{{Data: "small"}},
+		},
+		{
+			description: "retry",
+			input:       "retry: 1500\nretry: soon\ndata: message\n\n",
+			want:        []event{{Data: "message"}},
+			wantRetry:   1500 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got []event
+			var read int
+			var retry time.Duration
+			err := readEvents(bufio.NewReader(strings.NewReader(test.input)), 10, func(n int) {
+				read += n
+			}, func(e serverEvent) {
+				got = append(got, event{ID: e.id, Data: string(e.data)})
+			}, func(d time.Duration) {
+				retry = d
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%#v != %#v", got, test.want)
+			}
+			if retry != test.wantRetry {
+				t.Errorf("got retry %v, want %v", retry, test.wantRetry)
+			}
+			if complete := strings.LastIndex(test.input, "\n") + 1; read != complete {
+				t.Errorf("read %v bytes, want %v", read, complete)
+			}
+		})
+	}
+}
+
+func TestReadEventsLineTooLong(t *testing.T) {
+	// Reading stops long before the end of the line.
+	line := io.MultiReader(strings.NewReader("data: "), neverEnding('a'))
+	var read int
+	err := readEvents(bufio.NewReaderSize(line, 16), 64, func(n int) {
+		read += n
+	}, func(serverEvent) {
+		t.Error("event dispatched")
+	}, func(time.Duration) {})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("got %v, want ErrResponseTooLarge", err)
+	}
+	if read != 0 {
+		t.Errorf("read %v bytes of an incomplete line", read)
+	}
+}
+
+// neverEnding is an endless reader of the same byte.
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestServerSentEvents(t *testing.T) {
+	var mu sync.Mutex
+	var lastEventIDs []string
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasSuffix(req.URL.Path, "/events") {
+			atomic.AddInt32(&polls, 1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !strings.Contains(req.URL.Path, "/data/") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			<-req.Context().Done()
+			return
+		}
+		if req.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("got Accept %q", req.Header.Get("Accept"))
+		}
+		mu.Lock()
+		lastEventIDs = append(lastEventIDs, req.Header.Get(LastEventIDHeader))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch req.Header.Get(LastEventIDHeader) {
+		case "":
+			// Drop the stream after two events.
+			fmt.Fprint(w, "retry: 10\n\n")
+			fmt.Fprint(w, "id: 1\ndata: {\"n\":1}\n\n")
+			fmt.Fprint(w, ": keep-alive\n\n")
+			fmt.Fprint(w, "id: 2\nevent: message\ndata: {\"n\":\ndata: 2}\n\n")
+		case "2":
+			fmt.Fprint(w, "id: 3\ndata: {\"n\":3}\n\n")
+			w.(http.Flusher).Flush()
+			<-req.Context().Done()
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	received := make(chan string, 10)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+		received <- dest + " " + string(data)
+		return nil
+	}, HTTPOptions{
+		ServerSentEvents: true,
+		SkipConnectProbe: true,
+		PollingInterval:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`data {"n":1}`, "data {\"n\":\n2}", `data {"n":3}`}
+	var got []string
+	for range want {
+		select {
+		case message := <-received:
+			got = append(got, message)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %q, want %q", got, want)
+		}
+	}
+	transport.Disconnect(0)
+	waitGroupTimeout(t, transport, time.Second)
+
+	if !cmp.Equal(got, want) {
+		t.Errorf("%#v != %#v", got, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if wantIDs := []string{"", "2"}; !cmp.Equal(lastEventIDs, wantIDs) {
+		t.Errorf("got Last-Event-IDs %q, want %q", lastEventIDs, wantIDs)
+	}
+	if n := atomic.LoadInt32(&polls); n != 0 {
+		t.Errorf("%v polls, want none", n)
+	}
+}
+
+func TestServerSentEventsIdleTimeout(t *testing.T) {
+	var streams int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/data/") {
+			atomic.AddInt32(&streams, 1)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		ServerSentEvents:       true,
+		SkipConnectProbe:       true,
+		EventStreamIdleTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 2*time.Second, func() bool { return atomic.LoadInt32(&streams) >= 3 })
+	transport.Disconnect(0)
+	waitGroupTimeout(t, transport, time.Second)
+}
@@ -0,0 +1,75 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxStartupJitter(t *testing.T) {
+	const maxJitter = time.Minute
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	randomMu.Lock()
+	saved := random
+	random = rand.New(rand.NewSource(1))
+	randomMu.Unlock()
+	defer func() {
+		randomMu.Lock()
+		random = saved
+		randomMu.Unlock()
+	}()
+	want := time.Duration(rand.New(rand.NewSource(1)).Int63n(int64(maxJitter)))
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		MaxStartupJitter: maxJitter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- transport.Connect()
+	}()
+
+	select {
+	case got := <-clock.sleeps:
+		if got != want {
+			t.Errorf("got startup delay %v, want %v", got, want)
+		}
+		if got < 0 || got >= maxJitter {
+			t.Errorf("startup delay %v out of [0, %v)", got, maxJitter)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the startup delay")
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("%v requests sent before the startup delay, want 0", n)
+	}
+
+	close(clock.stop)
+	if err := <-connected; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n == 0 {
+		t.Error("no request sent after the startup delay")
+	}
+	transport.Disconnect(0)
+	waitGroupTimeout(t, transport, time.Second)
+}
@@ -0,0 +1,149 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPState is a snapshot of the health of an HTTP transport.
+type HTTPState struct {
+	// Connected is true between a call to Connect and the next call to
+	// Disconnect.
+	Connected bool
+
+	// LastSuccessfulPoll is the time of the most recent poll on any channel
+	// answered with a 2xx response, empty or not, or the zero time if no
+	// poll has succeeded yet.
+	LastSuccessfulPoll time.Time
+
+	// LastError is the most recent error returned by a poll or a send, or
+	// nil if none has failed yet.
+	LastError error
+
+	// BackoffDelay is the delay before the next poll on the channel that is
+	// currently backing off the longest, or zero if all polls are
+	// succeeding.
+	BackoffDelay time.Duration
+
+	// Breaker is the state of the circuit breaker, which is always
+	// BreakerClosed if HTTPOptions.BreakerThreshold is not set.
+	Breaker BreakerState
+}
+
+// httpState tracks the values reported by HTTP.State.
+type httpState struct {
+	mu        sync.Mutex
+	lastError error
+	backoff   map[string]time.Duration
+
+	// lastSuccessfulPoll holds the time.Time of the last poll answered with
+	// a 2xx response. It is read without taking mu, by health checks.
+	lastSuccessfulPoll atomic.Value
+}
+
+// recordPoll records the outcome of a poll on channel, and the delay before
+// the next poll on that channel if it failed. It reports whether the poll
+// changed the transport from having no failing channel to having one, or
+// back.
+func (s *httpState) recordPoll(channel string, now time.Time, err error, delay time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.backoff == nil {
+		s.backoff = make(map[string]time.Duration)
+	}
+	failing := len(s.backoff) > 0
+	if err != nil {
+		s.lastError = err
+		s.backoff[channel] = delay
+	} else {
+		delete(s.backoff, channel)
+	}
+	return failing != (len(s.backoff) > 0)
+}
+
+// recordSuccessfulPoll records that a poll was answered with a 2xx response
+// at now.
+func (s *httpState) recordSuccessfulPoll(now time.Time) {
+	s.lastSuccessfulPoll.Store(now)
+}
+
+// lastPoll returns the time of the last poll answered with a 2xx response, or
+// the zero time if there was none.
+func (s *httpState) lastPoll() time.Time {
+	now, _ := s.lastSuccessfulPoll.Load().(time.Time)
+	return now
+}
+
+// recordError records a failed send.
+func (s *httpState) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err
+}
+
+// reset clears the backoff state of all channels.
+func (s *httpState) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backoff = nil
+}
+
+func (s *httpState) snapshot() HTTPState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := HTTPState{
+		LastSuccessfulPoll: s.lastPoll(),
+		LastError:          s.lastError,
+	}
+	for _, delay := range s.backoff {
+		if delay > state.BackoffDelay {
+			state.BackoffDelay = delay
+		}
+	}
+	return state
+}
+
+// State returns a snapshot of the health of the transport. It is safe to call
+// concurrently with any other method.
+func (t *HTTP) State() HTTPState {
+	state := t.state.snapshot()
+	state.Connected = t.connected()
+	state.Breaker = t.breaker.current()
+	return state
+}
+
+// IsConnected reports whether the transport is connected and none of its
+// channels is failing to poll.
+func (t *HTTP) IsConnected() bool {
+	if !t.connected() {
+		return false
+	}
+	return t.state.snapshot().BackoffDelay == 0
+}
+
+// LastSuccessfulPoll returns the time of the most recent poll on any channel
+// answered with a 2xx response, empty or not, or the zero time if no poll has
+// succeeded yet. It is safe to call concurrently with any other method.
+func (t *HTTP) LastSuccessfulPoll() time.Time {
+	return t.state.lastPoll()
+}
+
+// Healthy reports whether a poll has succeeded within threshold, typically a
+// few polling intervals, for liveness and readiness probes. It is false until
+// the first poll succeeds, which Connect waits for unless SkipConnectProbe is
+// set.
+func (t *HTTP) Healthy(threshold time.Duration) bool {
+	last := t.state.lastPoll()
+	return !last.IsZero() && t.clock.Now().Sub(last) <= threshold
+}
+
+// connected reports whether Connect has been called since the last
+// Disconnect.
+func (t *HTTP) connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cancel != nil
+}
@@ -0,0 +1,163 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// eventually calls f until it returns true, failing the test if it does not
+// within timeout.
+func eventually(t *testing.T, timeout time.Duration, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestState(t *testing.T) {
+	var healthy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		Backoff:         ConstantBackoff{Delay: 7 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	if state := transport.State(); state.LastError != nil || !state.LastSuccessfulPoll.IsZero() {
+		t.Fatalf("unexpected initial state %+v", state)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport.wg.Add(1)
+	go transport.poll(ctx, "data")
+
+	// The first poll fails.
+	eventually(t, time.Second, func() bool { return transport.State().LastError != nil })
+	state := transport.State()
+	var statusErr HTTPStatusError
+	if !errors.As(state.LastError, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected last error %v", state.LastError)
+	}
+	if state.BackoffDelay != 7*time.Second {
+		t.Errorf("backoff delay %v != %v", state.BackoffDelay, 7*time.Second)
+	}
+
+	// The next poll succeeds.
+	atomic.StoreInt32(&healthy, 1)
+	<-clock.sleeps
+	eventually(t, time.Second, func() bool { return !transport.State().LastSuccessfulPoll.IsZero() })
+	state = transport.State()
+	if state.BackoffDelay != 0 {
+		t.Errorf("backoff delay %v != 0 after a successful poll", state.BackoffDelay)
+	}
+	if state.LastError == nil {
+		t.Error("last error should be kept after a successful poll")
+	}
+
+	cancel()
+	close(clock.stop)
+	waitGroupTimeout(t, transport, time.Second)
+}
+
+func TestHealthy(t *testing.T) {
+	var status int32 = http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+	const threshold = time.Minute
+
+	if transport.Healthy(threshold) {
+		t.Error("healthy before any poll")
+	}
+
+	// An empty 2xx response is a successful poll.
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := transport.LastSuccessfulPoll(), clock.Now(); !got.Equal(want) {
+		t.Errorf("last successful poll %v, want %v", got, want)
+	}
+	clock.advance(threshold)
+	if !transport.Healthy(threshold) {
+		t.Error("unhealthy within the threshold of a successful poll")
+	}
+
+	// Failed polls do not count.
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	clock.advance(time.Second)
+	if _, err := transport.fetch(context.Background(), "data", false); err == nil {
+		t.Fatal("poll succeeded")
+	}
+	if transport.Healthy(threshold) {
+		t.Error("healthy past the threshold of the last successful poll")
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if !transport.Healthy(threshold) {
+		t.Error("unhealthy after a successful poll")
+	}
+	if got := transport.State().LastSuccessfulPoll; !got.Equal(clock.Now()) {
+		t.Errorf("state reports last successful poll %v, want %v", got, clock.Now())
+	}
+}
+
+func TestIsConnected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", time.Hour, func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.IsConnected() {
+		t.Error("transport should not be connected before Connect")
+	}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if !transport.IsConnected() || !transport.State().Connected {
+		t.Error("transport should be connected after Connect")
+	}
+	transport.Disconnect(0)
+	if transport.IsConnected() || transport.State().Connected {
+		t.Error("transport should not be connected after Disconnect")
+	}
+}
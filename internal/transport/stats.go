@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTPStats is a snapshot of the counters of an HTTP transport, recorded
+// whether or not HTTPOptions.Metrics is set, for deployments that do without
+// the Prometheus client. WriteOpenMetrics renders it for scraping.
+type HTTPStats struct {
+	// Requests is the number of HTTP requests sent, in any direction.
+	Requests uint64
+
+	// RequestErrors is the number of requests that failed without a
+	// response or were answered with a status code of 400 or greater.
+	RequestErrors uint64
+
+	// BytesSent and BytesReceived are the numbers of request and response
+	// body bytes sent and received.
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// Polls is the number of poll iterations, successful or not.
+	Polls uint64
+
+	// DroppedMessages is the number of outbound messages dropped because
+	// the transport was disconnected.
+	DroppedMessages uint64
+
+	// InvalidMessages is the number of inbound messages dropped because
+	// they failed validation.
+	InvalidMessages uint64
+
+	// LastSuccessfulPoll is the time of the most recent successful poll, as
+	// reported by HTTP.LastSuccessfulPoll.
+	LastSuccessfulPoll time.Time
+}
+
+// httpStats accumulates the counters reported by HTTP.Stats.
+type httpStats struct {
+	mu    sync.Mutex
+	stats HTTPStats
+}
+
+// Stats returns a snapshot of the counters of the transport. It is safe to
+// call concurrently with any other method.
+func (t *HTTP) Stats() HTTPStats {
+	t.stats.mu.Lock()
+	stats := t.stats.stats
+	t.stats.mu.Unlock()
+	stats.LastSuccessfulPoll = t.state.lastPoll()
+	return stats
+}
+
+// observeRequest records a request sent in direction on channel, as
+// HTTPMetrics.observeRequest does.
+func (t *HTTP) observeRequest(channel, direction string, statusCode int, elapsed time.Duration, sent, received int) {
+	t.opts.Metrics.observeRequest(channel, direction, statusCode, elapsed, sent, received)
+
+	t.stats.mu.Lock()
+	defer t.stats.mu.Unlock()
+	t.stats.stats.Requests++
+	if statusCode == 0 || statusCode >= 400 {
+		t.stats.stats.RequestErrors++
+	}
+	t.stats.stats.BytesSent += uint64(sent)
+	t.stats.stats.BytesReceived += uint64(received)
+}
+
+// observePoll records a poll iteration on channel, as
+// HTTPMetrics.observePoll does.
+func (t *HTTP) observePoll(channel string, failures int) {
+	t.opts.Metrics.observePoll(channel, failures)
+
+	t.stats.mu.Lock()
+	defer t.stats.mu.Unlock()
+	t.stats.stats.Polls++
+}
+
+// observeDropped records an outbound message on channel dropped because the
+// transport was disconnected.
+func (t *HTTP) observeDropped(channel string) {
+	t.opts.Metrics.observeDropped(channel)
+
+	t.stats.mu.Lock()
+	defer t.stats.mu.Unlock()
+	t.stats.stats.DroppedMessages++
+}
+
+// observeInvalid records an inbound message on channel dropped because it
+// failed validation.
+func (t *HTTP) observeInvalid(channel string) {
+	t.opts.Metrics.observeInvalid(channel)
+
+	t.stats.mu.Lock()
+	defer t.stats.mu.Unlock()
+	t.stats.stats.InvalidMessages++
+}
+
+// WriteOpenMetrics writes s to w in the OpenMetrics text format, under the
+// same names as the metrics exported by HTTPMetrics, without per-channel
+// labels. A zero LastSuccessfulPoll is written as 0.
+func (s HTTPStats) WriteOpenMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	counter := func(name, unit, help string, value uint64) {
+		family := metricsNamespace + "_" + name
+		bw.WriteString("# TYPE " + family + " counter\n")
+		if unit != "" {
+			bw.WriteString("# UNIT " + family + " " + unit + "\n")
+		}
+		bw.WriteString("# HELP " + family + " " + help + "\n")
+		bw.WriteString(family + "_total " + strconv.FormatUint(value, 10) + "\n")
+	}
+	counter("requests", "", "HTTP requests sent.", s.Requests)
+	counter("request_errors", "", "HTTP requests that failed or were answered with an error status.", s.RequestErrors)
+	counter("sent_bytes", "bytes", "Request body bytes sent.", s.BytesSent)
+	counter("received_bytes", "bytes", "Response body bytes received.", s.BytesReceived)
+	counter("polls", "", "Inbound poll iterations.", s.Polls)
+	counter("dropped_messages", "", "Outbound messages dropped because the transport was disconnected.", s.DroppedMessages)
+	counter("invalid_messages", "", "Inbound messages dropped because they failed validation.", s.InvalidMessages)
+
+	family := metricsNamespace + "_last_successful_poll_timestamp_seconds"
+	var timestamp float64
+	if !s.LastSuccessfulPoll.IsZero() {
+		timestamp = float64(s.LastSuccessfulPoll.UnixNano()) / float64(time.Second)
+	}
+	bw.WriteString("# TYPE " + family + " gauge\n")
+	bw.WriteString("# UNIT " + family + " seconds\n")
+	bw.WriteString("# HELP " + family + " Time of the last successful poll.\n")
+	bw.WriteString(family + " " + strconv.FormatFloat(timestamp, 'f', -1, 64) + "\n")
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
@@ -0,0 +1,113 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStats(t *testing.T) {
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if req.Method == http.MethodGet {
+			w.Write([]byte("hello"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	transport.clock = clock
+
+	if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte("abc"), "data"); err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	if _, err := transport.fetch(context.Background(), "data", false); err == nil {
+		t.Fatal("expected an error polling a failing server")
+	}
+	transport.observePoll("data", 1)
+	transport.observeDropped("data")
+	transport.observeInvalid("data")
+
+	want := HTTPStats{
+		Requests:           3,
+		RequestErrors:      1,
+		BytesSent:          3,
+		BytesReceived:      5,
+		Polls:              1,
+		DroppedMessages:    1,
+		InvalidMessages:    1,
+		LastSuccessfulPoll: clock.Now(),
+	}
+	if got := transport.Stats(); !cmp.Equal(got, want) {
+		t.Errorf("%#v != %#v", got, want)
+	}
+}
+
+func TestStatsWriteOpenMetrics(t *testing.T) {
+	stats := HTTPStats{
+		Requests:           3,
+		RequestErrors:      1,
+		BytesSent:          10,
+		BytesReceived:      20,
+		Polls:              2,
+		LastSuccessfulPoll: time.Unix(1600000000, 500000000),
+	}
+	var b strings.Builder
+	if err := stats.WriteOpenMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE yggdrasil_http_transport_requests counter
+# HELP yggdrasil_http_transport_requests HTTP requests sent.
+yggdrasil_http_transport_requests_total 3
+# TYPE yggdrasil_http_transport_request_errors counter
+# HELP yggdrasil_http_transport_request_errors HTTP requests that failed or were answered with an error status.
+yggdrasil_http_transport_request_errors_total 1
+# TYPE yggdrasil_http_transport_sent_bytes counter
+# UNIT yggdrasil_http_transport_sent_bytes bytes
+# HELP yggdrasil_http_transport_sent_bytes Request body bytes sent.
+yggdrasil_http_transport_sent_bytes_total 10
+# TYPE yggdrasil_http_transport_received_bytes counter
+# UNIT yggdrasil_http_transport_received_bytes bytes
+# HELP yggdrasil_http_transport_received_bytes Response body bytes received.
+yggdrasil_http_transport_received_bytes_total 20
+# TYPE yggdrasil_http_transport_polls counter
+# HELP yggdrasil_http_transport_polls Inbound poll iterations.
+yggdrasil_http_transport_polls_total 2
+# TYPE yggdrasil_http_transport_dropped_messages counter
+# HELP yggdrasil_http_transport_dropped_messages Outbound messages dropped because the transport was disconnected.
+yggdrasil_http_transport_dropped_messages_total 0
+# TYPE yggdrasil_http_transport_invalid_messages counter
+# HELP yggdrasil_http_transport_invalid_messages Inbound messages dropped because they failed validation.
+yggdrasil_http_transport_invalid_messages_total 0
+# TYPE yggdrasil_http_transport_last_successful_poll_timestamp_seconds gauge
+# UNIT yggdrasil_http_transport_last_successful_poll_timestamp_seconds seconds
+# HELP yggdrasil_http_transport_last_successful_poll_timestamp_seconds Time of the last successful poll.
+yggdrasil_http_transport_last_successful_poll_timestamp_seconds 1600000000.5
+# EOF
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
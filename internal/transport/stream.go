@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// streamable reports whether an inbound message with the given header can be
+// streamed to the stream handler, rather than read whole first so that it can
+// be decrypted, verified, validated or checked for duplicates.
+func (t *HTTP) streamable(header http.Header) bool {
+	return t.opts.StreamHandler != nil &&
+		t.dedup == nil &&
+		t.opts.Validate == nil &&
+		header.Get(EncryptionHeader) == "" &&
+		len(t.signingKeys.Load().(*signingKeys).public) == 0
+}
+
+// stream passes the body of resp, a message received on channel, to the
+// stream handler as it is read.
+func (t *HTTP) stream(ctx context.Context, resp *http.Response, channel string, start time.Time) (pollResult, error) {
+	result := newPollResult(resp.Header)
+	body := bufio.NewReader(resp.Body)
+	if _, err := body.Peek(1); err == io.EOF {
+		t.observeRequest(channel, "in", resp.StatusCode, time.Since(start), 0, 0)
+		return result, nil
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, resp.Body}
+	decoded, err := decodedBody(resp)
+	if err != nil {
+		t.observeRequest(channel, "in", resp.StatusCode, time.Since(start), 0, 0)
+		return pollResult{}, fmt.Errorf("cannot read response body: %w", err)
+	}
+	defer decoded.Close()
+
+	r := &limitedBody{r: decoded, limit: t.opts.MaxResponseSize}
+	spanCtx, span := t.tracer.Start(ctx, "ReceiveData", receiveSpanOptions(resp.Header)...)
+	err = t.handle(channel, func() error { return t.opts.StreamHandler(r, channel) })
+	if err == nil && r.err != nil {
+		// The handler ignored the read error, but it cannot have had the
+		// whole message.
+		err = r.err
+	}
+	endSpan(span, err)
+	t.acknowledge(spanCtx, channel, resp.Header.Get(RequestIDHeader), err)
+	t.observeRequest(channel, "in", resp.StatusCode, time.Since(start), 0, int(r.n))
+	if r.err != nil {
+		return pollResult{}, fmt.Errorf("cannot read response body: %w", r.err)
+	}
+	result.received = true
+	return result, nil
+}
+
+// limitedBody reads a response body, failing with an error wrapping
+// ErrResponseTooLarge once more than limit bytes have been read. It records
+// the number of bytes read and the first read error other than io.EOF.
+type limitedBody struct {
+	r     io.Reader
+	limit int64
+	n     int64
+	err   error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if remaining := b.limit + 1 - b.n; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.n > b.limit {
+		b.n = b.limit
+		b.err = permanentError(fmt.Errorf("%w: more than %v bytes", ErrResponseTooLarge, b.limit))
+		return n - 1, b.err
+	}
+	if err != nil && err != io.EOF {
+		b.err = bodyReadError(err)
+		return n, b.err
+	}
+	return n, err
+}
@@ -0,0 +1,216 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStreamHandler(t *testing.T) {
+	const size = 8 << 20
+	message := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(message)
+
+	tests := []struct {
+		description string
+		gzip        bool
+	}{
+		{description: "identity"},
+		{description: "gzip", gzip: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// The server sends the second half of the message only once the
+			// handler has started reading the first, so the test times out
+			// if the body is read whole before the handler is called.
+			started := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				var body io.Writer = w
+				if test.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+					gw := gzip.NewWriter(w)
+					defer gw.Close()
+					body = gw
+				}
+				body.Write(message[:size/2])
+				if gw, ok := body.(*gzip.Writer); ok {
+					gw.Flush()
+				}
+				w.(http.Flusher).Flush()
+				select {
+				case <-started:
+				case <-time.After(5 * time.Second):
+					return
+				}
+				body.Write(message[size/2:])
+			}))
+			defer srv.Close()
+
+			var got []byte
+			var dest string
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+				t.Error("data handler called")
+				return nil
+			}, HTTPOptions{
+				MaxResponseSize: 2 * size,
+				StreamHandler: func(r io.Reader, d string) error {
+					dest = d
+					first := make([]byte, 1)
+					if _, err := io.ReadFull(r, first); err != nil {
+						t.Error(err)
+						return nil
+					}
+					close(started)
+					rest, err := ioutil.ReadAll(r)
+					if err != nil {
+						t.Error(err)
+					}
+					got = append(first, rest...)
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := transport.fetch(context.Background(), "data", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.received {
+				t.Error("message not received")
+			}
+			if dest != "data" {
+				t.Errorf("got channel %v, want data", dest)
+			}
+			if !bytes.Equal(got, message) {
+				t.Errorf("got %v bytes, want the %v-byte message", len(got), len(message))
+			}
+		})
+	}
+}
+
+func TestStreamHandlerTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(make([]byte, 1024))
+	}))
+	defer srv.Close()
+
+	var n int
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		MaxResponseSize: 100,
+		StreamHandler: func(r io.Reader, dest string) error {
+			data, err := ioutil.ReadAll(r)
+			n = len(data)
+			if !errors.Is(err, ErrResponseTooLarge) {
+				t.Errorf("got read error %v, want ErrResponseTooLarge", err)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.fetch(context.Background(), "data", false)
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("got %v, want ErrResponseTooLarge", err)
+	}
+	if n != 100 {
+		t.Errorf("handler read %v bytes, want 100", n)
+	}
+}
+
+func TestStreamHandlerTruncated(t *testing.T) {
+	var mu sync.Mutex
+	var acks []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			mu.Lock()
+			acks = append(acks, path.Base(req.URL.Path))
+			mu.Unlock()
+			return
+		}
+		w.Header().Set(RequestIDHeader, "1")
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	var readErr error
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		AckMessages: true,
+		Logger:      &captureLogger{},
+		StreamHandler: func(r io.Reader, dest string) error {
+			_, readErr = ioutil.ReadAll(r)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.fetch(context.Background(), "data", false)
+	if !errors.Is(err, ErrTruncatedBody) {
+		t.Errorf("got %v, want ErrTruncatedBody", err)
+	}
+	if !errors.Is(readErr, ErrTruncatedBody) {
+		t.Errorf("got read error %v, want ErrTruncatedBody", readErr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"nack"}; !cmp.Equal(acks, want) {
+		t.Errorf("%v != %v", acks, want)
+	}
+}
+
+func TestStreamHandlerBuffered(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"message_id":"1"}`))
+	}))
+	defer srv.Close()
+
+	var got []string
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", nil, HTTPOptions{
+		DedupWindow: 10,
+		StreamHandler: func(r io.Reader, dest string) error {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Error(err)
+			}
+			got = append(got, string(data))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 1 || got[0] != `{"message_id":"1"}` {
+		t.Errorf("got messages %q, want the message once", got)
+	}
+}
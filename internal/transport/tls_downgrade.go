@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrTLSDowngrade is returned, wrapped, when HTTPOptions.StrictTLS is set and
+// the transport would send a plain HTTP request after having been configured
+// for TLS.
+var ErrTLSDowngrade = errors.New("refusing to downgrade from TLS to plain HTTP")
+
+// requestURL returns the URL of channel in direction, like getUrl, failing if
+// it would downgrade the transport to plain HTTP.
+func (t *HTTP) requestURL(direction string, channel string) (string, error) {
+	u := t.getUrl(direction, channel)
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", permanentError(fmt.Errorf("cannot parse URL: %w", err))
+	}
+	if err := t.checkDowngrade(parsed.Scheme); err != nil {
+		return "", err
+	}
+	return u, nil
+}
+
+// checkDowngrade returns a permanent error wrapping ErrTLSDowngrade, after
+// logging it and reporting an EventTLSDowngrade, if StrictTLS is set, the
+// transport has been configured for TLS and scheme is not https.
+func (t *HTTP) checkDowngrade(scheme string) error {
+	if !t.opts.StrictTLS || !t.tlsConfigured.Load().(bool) || scheme == "https" {
+		return nil
+	}
+	err := permanentError(fmt.Errorf("%w: %v scheme", ErrTLSDowngrade, scheme))
+	t.opts.Logger.Error("refusing plain HTTP after TLS was configured, possible downgrade attack", "scheme", scheme)
+	t.events.emit(Event{Type: EventTLSDowngrade, Time: t.clock.Now(), Err: err})
+	return err
+}
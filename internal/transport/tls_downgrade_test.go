@@ -0,0 +1,134 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStrictTLSReload(t *testing.T) {
+	tests := []struct {
+		description string
+		strict      bool
+		tlsConfig   *tls.Config
+		wantError   error
+		wantScheme  string
+	}{
+		{
+			description: "strict after TLS",
+			strict:      true,
+			tlsConfig:   &tls.Config{},
+			wantError:   ErrTLSDowngrade,
+			wantScheme:  "https://",
+		},
+		{
+			description: "strict without TLS",
+			strict:      true,
+			wantScheme:  "http://",
+		},
+		{
+			description: "not strict",
+			tlsConfig:   &tls.Config{},
+			wantScheme:  "http://",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			transport, err := NewHTTPTransportWithOptions("test", "localhost", test.tlsConfig, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+				StrictTLS: test.strict,
+				Logger:    &captureLogger{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := transport.ReloadTLSConfig(nil); !errors.Is(err, test.wantError) {
+				t.Errorf("got %v, want %v", err, test.wantError)
+			}
+			if got := transport.getUrl("in", "data"); !strings.HasPrefix(got, test.wantScheme) {
+				t.Errorf("got URL %v, want scheme %v", got, test.wantScheme)
+			}
+		})
+	}
+}
+
+func TestStrictTLSRequests(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	logger := &captureLogger{}
+	events := make(chan Event, 10)
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), &tls.Config{}, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		StrictTLS: true,
+		Logger:    logger,
+		OnEvent:   func(event Event) { events <- event },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a code path that switches the transport back to plain HTTP.
+	transport.isTLS.Store(false)
+
+	requestTests := []struct {
+		description string
+		do          func() error
+	}{
+		{
+			description: "send",
+			do: func() error {
+				_, err := transport.SendData([]byte(`{}`), "data")
+				return err
+			},
+		},
+		{
+			description: "poll",
+			do: func() error {
+				_, err := transport.fetch(context.Background(), "data", false)
+				return err
+			},
+		},
+		{
+			description: "ping",
+			do:          func() error { return transport.Ping(context.Background()) },
+		},
+	}
+	for _, test := range requestTests {
+		t.Run(test.description, func(t *testing.T) {
+			err := test.do()
+			if !errors.Is(err, ErrTLSDowngrade) {
+				t.Errorf("got %v, want ErrTLSDowngrade", err)
+			}
+			if !errors.Is(err, ErrPermanentTransport) {
+				t.Errorf("got %v, want a permanent error", err)
+			}
+			select {
+			case event := <-events:
+				if event.Type != EventTLSDowngrade {
+					t.Errorf("got event %v, want %v", event.Type, EventTLSDowngrade)
+				}
+			case <-time.After(time.Second):
+				t.Error("no TLS downgrade event")
+			}
+		})
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("%v plain HTTP requests sent, want 0", n)
+	}
+	if entry, ok := logger.find("refusing plain HTTP after TLS was configured, possible downgrade attack"); !ok || entry.level != "error" {
+		t.Error("downgrade not logged as an error")
+	}
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/redhatinsights/yggdrasil/internal/transport"
+
+// Span attributes recorded by the transport.
+const (
+	channelKey    = attribute.Key("yggdrasil.channel")
+	statusCodeKey = attribute.Key("http.status_code")
+)
+
+// propagator carries trace context in W3C traceparent and tracestate
+// headers.
+var propagator = propagation.TraceContext{}
+
+// newTracer returns a tracer from tp, or from the global tracer provider if
+// tp is nil. The global provider creates no-op spans unless the application
+// has configured one.
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext adds the trace context of ctx to header.
+func injectTraceContext(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// remoteSpanContext returns the trace context, if any, the server sent in
+// header.
+func remoteSpanContext(header http.Header) trace.SpanContext {
+	ctx := propagator.Extract(context.Background(), propagation.HeaderCarrier(header))
+	return trace.SpanContextFromContext(ctx)
+}
+
+// receiveSpanOptions links the handling of a message to the server's trace,
+// if the response carrying it sent one.
+func receiveSpanOptions(header http.Header) []trace.SpanStartOption {
+	var opts []trace.SpanStartOption
+	if remote := remoteSpanContext(header); remote.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: remote}))
+	}
+	return opts
+}
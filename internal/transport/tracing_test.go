@@ -0,0 +1,90 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSendDataSpan(t *testing.T) {
+	traceparent := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		traceparent <- req.Header.Get("traceparent")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		TracerProvider:  provider,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %v spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "SendData" {
+		t.Errorf("span name %v != SendData", span.Name())
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if got := attrs[channelKey].AsString(); got != "data" {
+		t.Errorf("channel attribute %q != %q", got, "data")
+	}
+	if got := attrs[statusCodeKey].AsInt64(); got != http.StatusAccepted {
+		t.Errorf("status code attribute %v != %v", got, http.StatusAccepted)
+	}
+
+	header := <-traceparent
+	if !strings.Contains(header, span.SpanContext().TraceID().String()) {
+		t.Errorf("traceparent header %q does not carry trace ID %v", header, span.SpanContext().TraceID())
+	}
+}
+
+func TestSendDataSpanError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil }, HTTPOptions{
+		PollingInterval: time.Second,
+		TracerProvider:  sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.SendData([]byte(`{}`), "data"); err == nil {
+		t.Fatal("expected an error for a 400 response")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %v spans, want 1", len(spans))
+	}
+	if spans[0].Status().Code.String() != "Error" {
+		t.Errorf("span status %v, want Error", spans[0].Status().Code)
+	}
+}
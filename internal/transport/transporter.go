@@ -1,8 +1,33 @@
 package transport
 
-import "crypto/tls"
+import (
+	"context"
+	"crypto/tls"
+	"io"
+)
 
-type DataReceiveHandlerFunc func([]byte, string)
+// DataReceiveHandlerFunc handles a message received on a channel. Returning an
+// error signals that the message could not be processed; transports that
+// support it leave the message to be redelivered rather than acknowledging it.
+type DataReceiveHandlerFunc func([]byte, string) error
+
+// NoErrorHandler adapts a data handler that cannot fail, so that every message
+// it handles is acknowledged.
+func NoErrorHandler(handler func([]byte, string)) DataReceiveHandlerFunc {
+	return func(data []byte, dest string) error {
+		handler(data, dest)
+		return nil
+	}
+}
+
+// DataReceiveStreamHandlerFunc is a data handler that reads the message
+// received on dest from r, instead of being passed it whole.
+type DataReceiveStreamHandlerFunc func(r io.Reader, dest string) error
+
+// DataReceiveContextHandlerFunc is a data handler that is also passed a
+// context carrying what is known of the message beyond its data, such as the
+// request ID returned by RequestIDFromContext.
+type DataReceiveContextHandlerFunc func(ctx context.Context, data []byte, dest string) error
 
 // Transporter is an interface representing the ability to send and receive
 // data. It abstracts away the concrete implementation, leaving that up to the
@@ -11,6 +36,13 @@ type Transporter interface {
 	Connect() error
 	Disconnect(quiesce uint)
 	SendData(data []byte, dest string) ([]byte, error)
+	SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error)
 	ReceiveData(data []byte, dest string) error
 	ReloadTLSConfig(tlsConfig *tls.Config) error
 }
+
+// Reconnecter is implemented by transports that can re-establish their
+// connection in place, discarding the backoff built up by earlier failures.
+type Reconnecter interface {
+	Reconnect() error
+}
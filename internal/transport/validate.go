@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidMessage is returned, wrapped, by ReceiveData when
+// HTTPOptions.Validate rejects an inbound message. It is permanent.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// A ValidateFunc checks the body of a message received on channel before it
+// is passed to the data handler, returning an error describing why it is
+// invalid. A JSON schema compiled with any schema library can be adapted into
+// a ValidateFunc.
+type ValidateFunc func(data []byte, channel string) error
+
+// validate checks data, received on channel, with Validate, if set, logging
+// and counting the messages it rejects.
+func (t *HTTP) validate(data []byte, channel string) error {
+	if t.opts.Validate == nil {
+		return nil
+	}
+	if err := t.opts.Validate(data, channel); err != nil {
+		err = permanentError(fmt.Errorf("%w: %v", ErrInvalidMessage, err))
+		t.opts.Logger.Warn("dropping invalid message", "channel", channel, "message_id", messageID(data), "error", err)
+		t.observeInvalid(channel)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requireType is a ValidateFunc accepting JSON objects with a string "type".
+func requireType(data []byte, channel string) error {
+	var message struct {
+		Type *string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+	if message.Type == nil {
+		return errors.New("missing type")
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		description string
+		body        string
+		wantValid   bool
+	}{
+		{description: "conforming", body: `{"type":"command","message_id":"1"}`, wantValid: true},
+		{description: "missing field", body: `{"message_id":"1"}`},
+		{description: "wrong type", body: `{"type":1}`},
+		{description: "not JSON", body: `type=command`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+
+			metrics := NewHTTPMetrics()
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(metrics)
+			logger := &captureLogger{}
+			var handled bool
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error {
+				handled = true
+				return nil
+			}, HTTPOptions{
+				Validate: requireType,
+				Metrics:  metrics,
+				Logger:   logger,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+				t.Fatal(err)
+			}
+			if handled != test.wantValid {
+				t.Errorf("handled %v, want %v", handled, test.wantValid)
+			}
+			// gatherMetric returns -1 for a counter never incremented.
+			want := -1.0
+			if !test.wantValid {
+				want = 1
+			}
+			if got := gatherMetric(t, reg, "yggdrasil_http_transport_invalid_messages_total", map[string]string{"channel": "data"}); got != want {
+				t.Errorf("invalid_messages_total = %v, want %v", got, want)
+			}
+			if entry, ok := logger.find("dropping invalid message"); ok != !test.wantValid || (ok && entry.level != "warn") {
+				t.Errorf("invalid message logged %v, want %v", ok, !test.wantValid)
+			}
+
+			err = transport.ReceiveData([]byte(test.body), "data")
+			if test.wantValid && err != nil {
+				t.Errorf("got %v, want nil", err)
+			}
+			if !test.wantValid && (!errors.Is(err, ErrInvalidMessage) || !errors.Is(err, ErrPermanentTransport)) {
+				t.Errorf("got %v, want a permanent ErrInvalidMessage", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/gorilla/websocket"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+// webSocketFrame is the envelope of every message exchanged over a WebSocket
+// transport. It identifies the channel ("control" or "data") the message
+// belongs to, so both channels can share a single connection.
+type webSocketFrame struct {
+	Channel string `json:"channel"`
+	Data    []byte `json:"data"`
+}
+
+// WebSocket is a Transporter that sends and receives data and control
+// messages over a single WebSocket connection, reconnecting with backoff when
+// the connection drops.
+type WebSocket struct {
+	clientID    string
+	server      string
+	userAgent   string
+	dataHandler DataReceiveHandlerFunc
+	backoff     BackoffStrategy
+
+	// mu guards the fields below.
+	mu        sync.Mutex
+	tlsConfig *tls.Config
+	conn      *websocket.Conn
+	cancel    context.CancelFunc
+
+	// writeMu serializes writes to conn, which supports only one concurrent
+	// writer.
+	writeMu sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// NewWebSocketTransport creates a transport that exchanges messages with
+// server over a WebSocket connection. A nil tlsConfig creates a transport
+// that connects without TLS.
+func NewWebSocketTransport(clientID string, server string, tlsConfig *tls.Config, userAgent string, dataRecvFunc DataReceiveHandlerFunc) (*WebSocket, error) {
+	return &WebSocket{
+		clientID:    clientID,
+		server:      server,
+		userAgent:   userAgent,
+		dataHandler: dataRecvFunc,
+		tlsConfig:   tlsConfig.Clone(),
+		backoff: ExponentialBackoff{
+			Min:        time.Second,
+			Max:        DefaultMaxBackoff,
+			Multiplier: DefaultBackoffMultiplier,
+		},
+	}, nil
+}
+
+// Connect dials the server and starts receiving messages, returning an error
+// if the first connection attempt fails.
+func (t *WebSocket) Connect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := t.dial(ctx, t.tlsConfig)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("cannot connect to server: %w", err)
+	}
+	t.conn = conn
+	t.cancel = cancel
+
+	t.wg.Add(1)
+	go t.run(ctx, conn)
+
+	return nil
+}
+
+// run receives messages from conn until it fails, then reconnects, until ctx
+// is cancelled.
+func (t *WebSocket) run(ctx context.Context, conn *websocket.Conn) {
+	defer t.wg.Done()
+
+	for {
+		if err := t.read(conn); err != nil && ctx.Err() == nil {
+			log.Errorf("connection lost unexpectedly: %v", err)
+		}
+
+		conn = t.reconnect(ctx)
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials the server, backing off between failed attempts, until it
+// succeeds or ctx is cancelled, in which case it returns nil.
+func (t *WebSocket) reconnect(ctx context.Context) *websocket.Conn {
+	for attempt := 1; ; attempt++ {
+		delay := t.backoff.NextDelay(attempt)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		t.mu.Lock()
+		tlsConfig := t.tlsConfig
+		t.mu.Unlock()
+
+		conn, err := t.dial(ctx, tlsConfig)
+		if err != nil {
+			log.Debugf("cannot reconnect to server, retrying: %v", err)
+			continue
+		}
+
+		t.mu.Lock()
+		if ctx.Err() != nil {
+			t.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		t.conn = conn
+		t.mu.Unlock()
+
+		t.backoff.Reset()
+		log.Debugf("reconnected to server after %v attempts", attempt)
+		return conn
+	}
+}
+
+// read dispatches frames received on conn to the data handler until reading
+// fails.
+func (t *WebSocket) read(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var frame webSocketFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			log.Errorf("cannot unmarshal WebSocket frame: %v", err)
+			continue
+		}
+		if err := t.ReceiveData(frame.Data, frame.Channel); err != nil {
+			log.Errorf("cannot receive %v message: %v", frame.Channel, err)
+		}
+	}
+}
+
+func (t *WebSocket) dial(ctx context.Context, tlsConfig *tls.Config) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+
+	header := http.Header{}
+	header.Set("User-Agent", t.userAgent)
+
+	conn, _, err := dialer.DialContext(ctx, t.getUrl(tlsConfig != nil), header)
+	return conn, err
+}
+
+// ReloadTLSConfig replaces the TLS config used to connect to the server and
+// drops the current connection, which is then re-established using the new
+// config.
+func (t *WebSocket) ReloadTLSConfig(tlsConfig *tls.Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tlsConfig = tlsConfig.Clone()
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	return nil
+}
+
+// Disconnect waits quiesce milliseconds, then closes the connection and stops
+// reconnecting.
+func (t *WebSocket) Disconnect(quiesce uint) {
+	time.Sleep(time.Millisecond * time.Duration(quiesce))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	if t.conn != nil {
+		t.writeMu.Lock()
+		_ = t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		t.writeMu.Unlock()
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+// SendData sends data to the server in a frame addressed to the dest channel.
+func (t *WebSocket) SendData(data []byte, dest string) ([]byte, error) {
+	return t.SendDataWithContext(context.Background(), data, dest)
+}
+
+// SendDataWithContext sends data like SendData. It fails if ctx is done
+// before the frame is written, and bounds the write by the deadline of ctx,
+// if any.
+func (t *WebSocket) SendDataWithContext(ctx context.Context, data []byte, dest string) ([]byte, error) {
+	frame, err := json.Marshal(webSocketFrame{Channel: dest, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal WebSocket frame: %w", err)
+	}
+
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn == nil {
+		return nil, fmt.Errorf("cannot send data: not connected")
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("cannot send data: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("cannot send data: %w", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		return nil, fmt.Errorf("cannot send data: %w", err)
+	}
+	log.Debugf("sent message to %v channel", dest)
+	log.Tracef("message: %v", string(data))
+
+	return nil, nil
+}
+
+func (t *WebSocket) ReceiveData(data []byte, dest string) error {
+	return t.dataHandler(data, dest)
+}
+
+func (t *WebSocket) getUrl(isTLS bool) string {
+	scheme := "ws"
+	if isTLS {
+		scheme = "wss"
+	}
+	p := path.Join("/", yggdrasil.PathPrefix, "ws", url.PathEscape(t.clientID))
+
+	return fmt.Sprintf("%s://%s%s", scheme, t.server, p)
+}
@@ -0,0 +1,153 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type wsMessage struct {
+	data []byte
+	dest string
+}
+
+// startWebSocketServer starts a server that upgrades connections to the
+// WebSocket endpoint of client ID "test" and reports each connection on the
+// returned channel.
+func startWebSocketServer(t *testing.T) (*httptest.Server, <-chan *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 4)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/yggdrasil/ws/test" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			t.Errorf("cannot upgrade connection: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	return srv, conns
+}
+
+func TestWebSocket(t *testing.T) {
+	srv, conns := startWebSocketServer(t)
+	defer srv.Close()
+
+	received := make(chan wsMessage, 4)
+	transport, err := NewWebSocketTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func(data []byte, dest string) error {
+		received <- wsMessage{data: data, dest: dest}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.backoff = ConstantBackoff{Delay: 10 * time.Millisecond}
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+	conn := <-conns
+
+	// Outbound messages are framed with their channel.
+	if _, err := transport.SendData([]byte(`{"type":"event"}`), "control"); err != nil {
+		t.Fatal(err)
+	}
+	var frame webSocketFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Channel != "control" || string(frame.Data) != `{"type":"event"}` {
+		t.Errorf("unexpected frame %+v", frame)
+	}
+
+	// Inbound frames reach the handler with their channel.
+	push := func(conn *websocket.Conn, channel, data string) {
+		t.Helper()
+		frame, _ := json.Marshal(webSocketFrame{Channel: channel, Data: []byte(data)})
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(dest, data string) {
+		t.Helper()
+		select {
+		case msg := <-received:
+			if msg.dest != dest || string(msg.data) != data {
+				t.Errorf("received %v %s, want %v %v", msg.dest, msg.data, dest, data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("did not receive %v message", dest)
+		}
+	}
+	push(conn, "data", `{"n":1}`)
+	expect("data", `{"n":1}`)
+
+	// The transport reconnects after the connection drops.
+	conn.Close()
+	select {
+	case conn = <-conns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transport did not reconnect")
+	}
+	push(conn, "control", `{"n":2}`)
+	expect("control", `{"n":2}`)
+}
+
+func TestWebSocketConnectError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	transport, err := NewWebSocketTransport("test", strings.TrimPrefix(srv.URL, "http://"), nil, "testUA", func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(); err == nil {
+		t.Error("expected an error connecting to a server without a WebSocket endpoint")
+	}
+}
+
+func TestWebSocketReloadTLSConfig(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	conns := make(chan *websocket.Conn, 4)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	defer srv.Close()
+
+	tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig
+	transport, err := NewWebSocketTransport("test", strings.TrimPrefix(srv.URL, "https://"), tlsConfig, "testUA", func([]byte, string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.backoff = ConstantBackoff{Delay: 10 * time.Millisecond}
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Disconnect(0)
+	<-conns
+
+	if err := transport.ReloadTLSConfig(tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-conns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transport did not reconnect with the new TLS config")
+	}
+}
@@ -0,0 +1,96 @@
+//go:build go1.16
+// +build go1.16
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteServer(t *testing.T) {
+	// requests records the requests served by each server.
+	var mu sync.Mutex
+	requests := make(map[string][]string)
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			requests[name] = append(requests[name], req.Method+" "+req.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+
+	read := httptest.NewServer(record("read"))
+	defer read.Close()
+	write := httptest.NewServer(record("write"))
+	defer write.Close()
+	writeTLS := httptest.NewTLSServer(record("write"))
+	defer writeTLS.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(writeTLS.Certificate())
+
+	tests := []struct {
+		description string
+		opts        HTTPOptions
+		wantWrite   string
+	}{
+		{
+			description: "single server",
+			wantWrite:   "read",
+		},
+		{
+			description: "write server",
+			opts:        HTTPOptions{WriteServer: strings.TrimPrefix(write.URL, "http://")},
+			wantWrite:   "write",
+		},
+		{
+			description: "write server over TLS",
+			opts: HTTPOptions{
+				WriteServer:    strings.TrimPrefix(writeTLS.URL, "https://"),
+				WriteTLSConfig: &tls.Config{RootCAs: roots},
+			},
+			wantWrite: "write",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			mu.Lock()
+			requests = make(map[string][]string)
+			mu.Unlock()
+
+			transport, err := NewHTTPTransportWithOptions("test", strings.TrimPrefix(read.URL, "http://"), nil, "testUA", nil, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := transport.fetch(context.Background(), "data", false); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := transport.SendData([]byte(`{}`), "data"); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			wantIn := []string{"GET /yggdrasil/data/test/in"}
+			wantOut := []string{"POST /yggdrasil/data/test/out"}
+			if test.wantWrite == "read" {
+				wantIn = append(wantIn, wantOut...)
+				wantOut = nil
+			}
+			if got := requests["read"]; strings.Join(got, ",") != strings.Join(wantIn, ",") {
+				t.Errorf("read server got %q, want %q", got, wantIn)
+			}
+			if got := requests["write"]; strings.Join(got, ",") != strings.Join(wantOut, ",") {
+				t.Errorf("write server got %q, want %q", got, wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package yggdrasil
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// UserAgent returns a User-Agent header value identifying the client to the
+// server, built from product, the yggdrasil version, the Go version and the
+// operating system and architecture the client runs on, such as
+// "rhc/0.2.0 yggdrasil/0.2.1 (go1.16.2; linux/amd64)". product is the
+// calling product's own token, in the "name/version" form, and is omitted if
+// empty. Callers that need a different format can pass any value they build
+// themselves as the User-Agent instead.
+func UserAgent(product string) string {
+	name := LongName
+	if name == "" {
+		name = "yggdrasil"
+	}
+	if Version != "" {
+		name += "/" + Version
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v %v (%v; %v/%v)", product, name, runtime.Version(), runtime.GOOS, runtime.GOARCH))
+}
@@ -0,0 +1,55 @@
+package yggdrasil
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	defer func(longName, version string) {
+		LongName, Version = longName, version
+	}(LongName, Version)
+	platform := " (" + runtime.Version() + "; " + runtime.GOOS + "/" + runtime.GOARCH + ")"
+
+	tests := []struct {
+		description string
+		longName    string
+		version     string
+		product     string
+		want        string
+	}{
+		{
+			description: "product",
+			longName:    "yggdrasil",
+			version:     "0.2.1",
+			product:     "rhc/0.2.0",
+			want:        "rhc/0.2.0 yggdrasil/0.2.1" + platform,
+		},
+		{
+			description: "no product",
+			longName:    "yggdrasil",
+			version:     "0.2.1",
+			want:        "yggdrasil/0.2.1" + platform,
+		},
+		{
+			description: "no version",
+			longName:    "yggdrasil",
+			product:     "rhc/0.2.0",
+			want:        "rhc/0.2.0 yggdrasil" + platform,
+		},
+		{
+			description: "no long name",
+			version:     "0.2.1",
+			want:        "yggdrasil/0.2.1" + platform,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			LongName, Version = test.longName, test.version
+			if got := UserAgent(test.product); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}